@@ -0,0 +1,152 @@
+package base
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/dataloader"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/microsvs/base/pkg/utils"
+)
+
+// AuthRule 访问类型或者字段需要满足的条件, 所有设置的条件都要满足
+type AuthRule struct {
+	Roles       []string // 拥有其中任一角色
+	Permissions []string // 拥有全部权限
+	// 所有权判断, p.Source为字段所在的对象, 根字段可以使用p.Args
+	Owner func(p graphql.ResolveParams, user *types.User) bool
+}
+
+// Authorize 设置访问规则, name为"Type"时作用于该类型的所有字段, "Type.field"只作用于该字段
+/* example
+d.Authorize("Mutation.deleteOrder", base.AuthRule{Roles: []string{"admin"}})
+d.Authorize("Order", base.AuthRule{Owner: base.OwnedBy("user_id")})
+d.Authorize("Order.mobile", base.AuthRule{Permissions: []string{"order:mobile"}})
+*/
+func (d *Daemon) Authorize(name string, rule AuthRule) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.authRules == nil {
+		d.authRules = make(map[string]AuthRule)
+	}
+	d.authRules[name] = rule
+}
+
+// OwnedBy 判断对象的field字段是否为当前用户ID
+func OwnedBy(field string) func(p graphql.ResolveParams, user *types.User) bool {
+	return func(p graphql.ResolveParams, user *types.User) bool {
+		imap, ok := RedirectResultMap(p.Source)
+		if !ok {
+			if err := utils.GenericTypeConvert(p.Source, &imap); err != nil {
+				return false
+			}
+		}
+		return imap[field] != nil && fmt.Sprint(imap[field]) == user.ID
+	}
+}
+
+// installAuthorization 在resolve之前检查访问规则, 规则可以在schema安装之后设置
+func (d *Daemon) installAuthorization(schema *graphql.Schema) {
+	walkSchemaFields(schema, func(obj *graphql.Object, field *graphql.FieldDefinition) {
+		if field.Resolve != nil {
+			field.Resolve = d.authorizeResolve(obj.Name(), field.Name, field.Resolve)
+		}
+		if field.Subscribe != nil {
+			field.Subscribe = d.authorizeResolve(obj.Name(), field.Name, field.Subscribe)
+		}
+	})
+}
+
+func (d *Daemon) authorizeResolve(typeName, fieldName string, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		d.mutex.RLock()
+		typeRule, typeOK := d.authRules[typeName]
+		fieldRule, fieldOK := d.authRules[typeName+"."+fieldName]
+		d.mutex.RUnlock()
+		if !typeOK && !fieldOK {
+			return next(p)
+		}
+		user := contextUser(p.Context)
+		if (typeOK && !typeRule.allow(p, user)) || (fieldOK && !fieldRule.allow(p, user)) {
+			var uid string
+			if user != nil {
+				uid = user.ID
+			}
+			log.ErrorRaw("[authorizeResolve] access %s.%s denied. user_id=%s", typeName, fieldName, uid)
+			return nil, errors.FGENoPermission
+		}
+		return next(p)
+	}
+}
+
+// loadUserGrants 从用户服务读取角色和权限, 同一个请求只读取一次
+// 用户服务没有提供角色和权限时视为没有角色和权限
+var loadUserGrants = func(ctx context.Context, id string) *types.User {
+	loader := dataloader.For(ctx, "user_grants", func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		ret := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			user, err := rpc.GetUserGrantsRPC(ctx, Service2Url(rpc.FGSUser), key)
+			if err != nil {
+				log.ErrorRaw("[loadUserGrants] load roles of %s failed. err=%s", key, err.Error())
+				user = &types.User{ID: key}
+			}
+			ret[key] = user
+		}
+		return ret, nil
+	})
+	value, _ := loader.Load(ctx, id)()
+	user, _ := value.(*types.User)
+	return user
+}
+
+// contextUser 当前用户, 角色和权限不随rpc context传递, 未读取时从用户服务读取
+func contextUser(ctx context.Context) *types.User {
+	if ctx == nil {
+		return nil
+	}
+	user, _ := ctx.Value(rpc.KeyUser).(*types.User)
+	if user == nil || len(user.ID) <= 0 || user.Roles != nil || user.Permissions != nil {
+		return user
+	}
+	grants := loadUserGrants(ctx, user.ID)
+	if grants == nil {
+		return user
+	}
+	// 不修改context中的user, 同一个请求的resolver可能并发执行
+	ret := *user
+	ret.Roles, ret.Permissions = grants.Roles, grants.Permissions
+	return &ret
+}
+
+func (rule AuthRule) allow(p graphql.ResolveParams, user *types.User) bool {
+	if user == nil || len(user.ID) <= 0 {
+		return false
+	}
+	if len(rule.Roles) > 0 && !containsAny(user.Roles, rule.Roles) {
+		return false
+	}
+	for _, perm := range rule.Permissions {
+		if !containsAny(user.Permissions, []string{perm}) {
+			return false
+		}
+	}
+	if rule.Owner != nil && !rule.Owner(p, user) {
+		return false
+	}
+	return true
+}
+
+func containsAny(items, targets []string) bool {
+	for _, item := range items {
+		for _, target := range targets {
+			if item == target {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package base
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	schema := newRedirectSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		return map[string]interface{}{"name": "xhj", "owner": "1001"}, nil
+	})
+	d := &Daemon{schema: &schema}
+	d.installResolvers(&schema)
+	// 没有角色和权限的用户从用户服务读取
+	grants := loadUserGrants
+	defer func() { loadUserGrants = grants }()
+	loadUserGrants = func(ctx context.Context, id string) *types.User {
+		if id == "1003" {
+			return &types.User{ID: id, Roles: []string{"admin"}}
+		}
+		return &types.User{ID: id}
+	}
+	// GLCompany为其他测试共用, 结束后清除规则
+	defer func() {
+		d.mutex.Lock()
+		d.authRules = nil
+		d.mutex.Unlock()
+	}()
+	d.Authorize("Query.company", AuthRule{Roles: []string{"admin", "ops"}})
+	d.Authorize("Company", AuthRule{Owner: OwnedBy("owner")})
+	d.Authorize("Company.workers", AuthRule{Permissions: []string{"company:workers"}})
+
+	do := func(query string, user *types.User) *graphql.Result {
+		ctx := context.Background()
+		if user != nil {
+			ctx = context.WithValue(ctx, rpc.KeyUser, user)
+		}
+		return graphql.Do(graphql.Params{Schema: schema, RequestString: query, Context: ctx})
+	}
+	// 未登录
+	result := do("{ company { name } }", nil)
+	assert.Equal(t, errors.FGENoPermission.Error(), result.Errors[0].Message)
+	// 没有角色
+	result = do("{ company { name } }", &types.User{ID: "1001"})
+	assert.Equal(t, errors.FGENoPermission.Error(), result.Errors[0].Message)
+	// 不是所有者
+	result = do("{ company { name } }", &types.User{ID: "1002", Roles: []string{"ops"}})
+	assert.Equal(t, errors.FGENoPermission.Error(), result.Errors[0].Message)
+
+	user := &types.User{ID: "1001", Roles: []string{"ops"}}
+	result = do("{ company { name } }", user)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"company": map[string]interface{}{"name": "xhj"}}, result.Data)
+	// 缺少权限
+	result = do("{ company { workers { company } } }", user)
+	assert.Equal(t, errors.FGENoPermission.Error(), result.Errors[0].Message)
+	user.Permissions = []string{"company:workers"}
+	result = do("{ company { workers { company } } }", user)
+	assert.Empty(t, result.Errors)
+
+	ctx := context.WithValue(context.Background(), rpc.KeyUser, &types.User{ID: "1003"})
+	assert.Equal(t, []string{"admin"}, contextUser(ctx).Roles)
+	assert.Nil(t, ctx.Value(rpc.KeyUser).(*types.User).Roles)
+}
+
+// 重复安装schema时从原始的resolver重新包装, 规则只检查一次
+func TestAuthorizeReinstall(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"ping": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return "pong", nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	d := &Daemon{schema: &schema}
+	d.installResolvers(&schema)
+	assert.Nil(t, d.UpdateSchema(&schema))
+	var checks int
+	d.Authorize("Query.ping", AuthRule{Owner: func(p graphql.ResolveParams, user *types.User) bool {
+		checks++
+		return true
+	}})
+	ctx := context.WithValue(context.Background(), rpc.KeyUser, &types.User{ID: "1001", Roles: []string{}})
+	result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ ping }", Context: ctx})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, 1, checks)
+}
+
+// 替换schema以后释放旧schema记录的原始resolver
+func TestUpdateSchemaReleaseResolvers(t *testing.T) {
+	newSchema := func() *graphql.Schema {
+		schema, err := graphql.NewSchema(graphql.SchemaConfig{
+			Query: graphql.NewObject(graphql.ObjectConfig{
+				Name:   "Query",
+				Fields: graphql.Fields{"ping": &graphql.Field{Type: graphql.String}},
+			}),
+		})
+		assert.Nil(t, err)
+		return &schema
+	}
+	old := newSchema()
+	d, err := NewGLDaemon(rpc.FGSGateway, old)
+	assert.Nil(t, err)
+	field := old.QueryType().Fields()["ping"]
+	installed.Lock()
+	assert.Contains(t, installed.fields, field)
+	installed.Unlock()
+
+	assert.Nil(t, d.UpdateSchema(newSchema()))
+	installed.Lock()
+	assert.NotContains(t, installed.fields, field)
+	installed.Unlock()
+}
@@ -0,0 +1,399 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/libkv/store"
+	"gopkg.in/yaml.v2"
+)
+
+// Format 复合类型(struct, map, slice)配置值的编码格式
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// BindRetryInterval 子树不存在或者watch中断以后重新watch的间隔
+var BindRetryInterval = 5 * time.Second
+
+// Binding 配置子树与Go结构体的绑定, 配置变化时原子替换为新的结构体
+type Binding struct {
+	path      string
+	typ       reflect.Type
+	format    Format
+	value     atomic.Value // 当前结构体指针
+	mutex     sync.Mutex
+	callbacks []func(old, cur interface{})
+	stopCh    chan struct{}
+	closeOnce sync.Once
+	keys      map[string]context.CancelFunc // 子key的watch, 只在watch协程中访问
+}
+
+// Bind 把path下一级的配置绑定到v指向的结构体, 字段对应的key依次取kv tag、json tag、字段名
+// 支持的tag:
+//   - default:"..." 配置不存在时的默认值
+//   - validate:"required,min=1,max=100,oneof=a|b" 校验失败时Bind返回错误, 热更新时保留旧值
+// string、数值、bool、time.Duration直接解析, 其他类型按format解码, secret自动解密, 敏感字段使用secret.String
+/* example
+type MysqlConfig struct {
+	Master  string        `kv:"master" validate:"required"`
+	MaxConn int           `kv:"max_conn" default:"100" validate:"min=1"`
+	Timeout time.Duration `kv:"timeout" default:"3s"`
+	Slaves  []string      `kv:"slaves"` // ["root@tcp(127.0.0.2)"]
+}
+var cfg MysqlConfig
+b, err := discovery.Bind("db/user", &cfg, discovery.FormatJSON)
+b.OnChange(func(old, cur interface{}) {
+	reconnect(cur.(*MysqlConfig))
+})
+b.Load().(*MysqlConfig).MaxConn
+*/
+func Bind(path string, v interface{}, format Format) (*Binding, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("bind %s: need pointer to struct, got %T", path, v)
+	}
+	if len(format) <= 0 {
+		format = FormatJSON
+	}
+	b := &Binding{
+		path:   fullPath(path),
+		typ:    rv.Elem().Type(),
+		format: format,
+		stopCh: make(chan struct{}),
+	}
+	kvpairs, err := b.list()
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	cur, err := b.decode(kvpairs)
+	if err != nil {
+		return nil, err
+	}
+	rv.Elem().Set(cur.Elem())
+	b.value.Store(cur.Interface())
+	go b.watch(kvpairs)
+	return b, nil
+}
+
+// Load 返回当前配置, 类型与Bind传入的指针相同, 调用方不能修改
+func (b *Binding) Load() interface{} {
+	return b.value.Load()
+}
+
+// OnChange 注册配置变化回调, old和cur为新旧配置
+func (b *Binding) OnChange(fn func(old, cur interface{})) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.callbacks = append(b.callbacks, fn)
+}
+
+// Close 停止watch
+func (b *Binding) Close() {
+	b.closeOnce.Do(func() {
+		close(b.stopCh)
+	})
+}
+
+// list 配置中心不可用时从本地快照读取
+func (b *Binding) list() ([]*store.KVPair, error) {
+	s, err := getStore()
+	if err == nil {
+		var kvpairs []*store.KVPair
+		if kvpairs, err = s.List(b.path); err == nil {
+			snapshotStore(kvpairs...)
+			return kvpairs, nil
+		}
+		if err == store.ErrKeyNotFound {
+			return nil, err
+		}
+	}
+	if kvpairs := snapshotList(b.path); len(kvpairs) > 0 {
+		return kvpairs, nil
+	}
+	return nil, err
+}
+
+// watch 子树变化时重新解码, watch中断以后重新建立
+// zookeeper的WatchTree只在子节点增删时通知, 每个子key另外watch值的变化
+func (b *Binding) watch(last []*store.KVPair) {
+	changed := make(chan struct{}, 1)
+	defer b.watchKeys(nil, changed)
+	b.watchKeys(last, changed)
+	refresh := func(kvpairs []*store.KVPair) {
+		b.watchKeys(kvpairs, changed)
+		if !equalKVPairs(last, kvpairs) {
+			last = kvpairs
+			b.update(kvpairs)
+		}
+	}
+	for {
+		var (
+			tree  <-chan []*store.KVPair
+			retry <-chan time.Time
+		)
+		if s, err := getStore(); err == nil {
+			if ch, err := s.WatchTree(b.path, b.stopCh); err == nil {
+				tree = ch
+			}
+		}
+		if tree == nil {
+			retry = time.After(BindRetryInterval)
+		}
+	loop:
+		for {
+			select {
+			case kvpairs, ok := <-tree:
+				if !ok {
+					tree, retry = nil, time.After(BindRetryInterval)
+					continue
+				}
+				snapshotStore(kvpairs...)
+				refresh(kvpairs)
+			case <-changed:
+				if kvpairs, err := b.list(); err == nil || err == store.ErrKeyNotFound {
+					refresh(kvpairs)
+				}
+			case <-retry:
+				// 期间子树可能被删除
+				if kvpairs, err := b.list(); err == nil || err == store.ErrKeyNotFound {
+					refresh(kvpairs)
+				}
+				break loop
+			case <-b.stopCh:
+				return
+			}
+		}
+	}
+}
+
+// watchKeys 与当前的子key保持一致, 新增的key建立watch, 删除的key取消watch, 任意key变化时通知changed
+func (b *Binding) watchKeys(kvpairs []*store.KVPair, changed chan<- struct{}) {
+	cur := make(map[string]bool, len(kvpairs))
+	for _, kvpair := range kvpairs {
+		cur[kvpair.Key] = true
+	}
+	for key, cancel := range b.keys {
+		if !cur[key] {
+			cancel()
+			delete(b.keys, key)
+		}
+	}
+	if b.keys == nil {
+		b.keys = make(map[string]context.CancelFunc)
+	}
+	for key := range cur {
+		if _, ok := b.keys[key]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := WatchContext(ctx, key)
+		if err != nil {
+			cancel()
+			continue
+		}
+		b.keys[key] = cancel
+		go func() {
+			for range ch {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+}
+
+func (b *Binding) update(kvpairs []*store.KVPair) {
+	cur, err := b.decode(kvpairs)
+	if err != nil {
+		log.ErrorRaw("[Binding.update] keep old config of %s. err=%s", b.path, err.Error())
+		return
+	}
+	old := b.value.Load()
+	b.value.Store(cur.Interface())
+	b.mutex.Lock()
+	callbacks := append([]func(old, cur interface{}){}, b.callbacks...)
+	b.mutex.Unlock()
+	for _, fn := range callbacks {
+		fn(old, cur.Interface())
+	}
+}
+
+// decode 按字段解析配置, 返回新的结构体指针
+func (b *Binding) decode(kvpairs []*store.KVPair) (reflect.Value, error) {
+	var (
+		values = make(map[string]string, len(kvpairs))
+		cur    = reflect.New(b.typ)
+	)
+	for _, kvpair := range kvpairs {
+		value, ok := reveal(kvpair.Key, string(kvpair.Value))
+		if !ok {
+			return cur, fmt.Errorf("decrypt %s failed", kvpair.Key)
+		}
+		values[path.Base(kvpair.Key)] = value
+	}
+	for i := 0; i < b.typ.NumField(); i++ {
+		field := b.typ.Field(i)
+		key := fieldKey(field)
+		if len(field.PkgPath) > 0 || key == "-" {
+			continue
+		}
+		raw, ok := values[key]
+		if !ok {
+			raw, ok = field.Tag.Lookup("default")
+		}
+		if ok {
+			if err := decodeValue(b.format, raw, cur.Elem().Field(i)); err != nil {
+				// strconv的错误包含原始值, 只保留原因
+				if numErr, ok := err.(*strconv.NumError); ok {
+					err = numErr.Err
+				}
+				return cur, fmt.Errorf("decode %s/%s: %s", b.path, key, err.Error())
+			}
+		}
+		if err := validateValue(field.Tag.Get("validate"), cur.Elem().Field(i)); err != nil {
+			return cur, fmt.Errorf("validate %s/%s: %s", b.path, key, err.Error())
+		}
+	}
+	return cur, nil
+}
+
+func fieldKey(field reflect.StructField) string {
+	if key := field.Tag.Get("kv"); len(key) > 0 {
+		return key
+	}
+	if key := strings.Split(field.Tag.Get("json"), ",")[0]; len(key) > 0 {
+		return key
+	}
+	return field.Name
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func decodeValue(format Format, raw string, v reflect.Value) error {
+	raw = strings.TrimSpace(raw)
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return unmarshal(format, []byte(raw), v.Addr().Interface())
+	}
+	return nil
+}
+
+func unmarshal(format Format, data []byte, v interface{}) error {
+	switch format {
+	case FormatJSON:
+		return json.Unmarshal(data, v)
+	case FormatYAML:
+		return yaml.Unmarshal(data, v)
+	case FormatTOML:
+		return toml.Unmarshal(data, v)
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+// validateValue 支持required, min, max, oneof, 数值比较大小, 其他类型比较长度
+// 配置可能是敏感信息, 错误中不包含配置的值
+func validateValue(rules string, v reflect.Value) error {
+	if len(rules) <= 0 {
+		return nil
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg := rule, ""
+		if idx := strings.Index(rule, "="); idx >= 0 {
+			name, arg = rule[:idx], rule[idx+1:]
+		}
+		switch name {
+		case "required":
+			if v.IsZero() {
+				return fmt.Errorf("required")
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Errorf("invalid rule %s", rule)
+			}
+			n, ok := measure(v)
+			if !ok {
+				return fmt.Errorf("rule %s not supported by %s", rule, v.Kind())
+			}
+			if (name == "min" && n < limit) || (name == "max" && n > limit) {
+				return fmt.Errorf("not satisfy %s", rule)
+			}
+		case "oneof":
+			value := fmt.Sprint(v.Interface())
+			found := false
+			for _, opt := range strings.Split(arg, "|") {
+				found = found || opt == value
+			}
+			if !found {
+				return fmt.Errorf("not in %s", arg)
+			}
+		default:
+			return fmt.Errorf("unknown rule %s", rule)
+		}
+	}
+	return nil
+}
+
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/microsvs/base/pkg/secret"
+	"github.com/microsvs/libkv/store"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMysqlConfig struct {
+	Master  string            `kv:"master" validate:"required"`
+	MaxConn int               `kv:"max_conn" default:"100" validate:"min=1,max=1000"`
+	Timeout time.Duration     `kv:"timeout" default:"3s"`
+	Slaves  []string          `kv:"slaves"`
+	Extra   map[string]string `json:"extra"`
+}
+
+func TestBind(t *testing.T) {
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	var cfg testMysqlConfig
+	_, err := Bind("/bind/mysql", &cfg, FormatYAML)
+	assert.NotNil(t, err) // master required
+
+	assert.Nil(t, KVWrite("/bind/mysql/master", "root@tcp(127.0.0.1)"))
+	assert.Nil(t, KVWrite("/bind/mysql/slaves", "[root@tcp(127.0.0.2), root@tcp(127.0.0.3)]"))
+	assert.Nil(t, KVWrite("/bind/mysql/extra", "charset: utf8"))
+	b, err := Bind("/bind/mysql", &cfg, FormatYAML)
+	assert.Nil(t, err)
+	defer b.Close()
+	assert.Equal(t, testMysqlConfig{
+		Master:  "root@tcp(127.0.0.1)",
+		MaxConn: 100,
+		Timeout: 3 * time.Second,
+		Slaves:  []string{"root@tcp(127.0.0.2)", "root@tcp(127.0.0.3)"},
+		Extra:   map[string]string{"charset": "utf8"},
+	}, cfg)
+
+	changed := make(chan [2]*testMysqlConfig, 1)
+	b.OnChange(func(old, cur interface{}) {
+		changed <- [2]*testMysqlConfig{old.(*testMysqlConfig), cur.(*testMysqlConfig)}
+	})
+	// 校验失败时保留旧值
+	assert.Nil(t, KVWrite("/bind/mysql/max_conn", "0"))
+	assert.Nil(t, KVWrite("/bind/mysql/max_conn", "200"))
+	select {
+	case v := <-changed:
+		assert.Equal(t, 100, v[0].MaxConn)
+		assert.Equal(t, 200, v[1].MaxConn)
+	case <-time.After(time.Second):
+		t.Fatal("OnChange timeout")
+	}
+	assert.Equal(t, 200, b.Load().(*testMysqlConfig).MaxConn)
+	assert.Equal(t, 100, cfg.MaxConn)
+}
+
+// childrenWatchStore 与zookeeper一样, WatchTree只返回当前值, 子key的值变化时不通知
+type childrenWatchStore struct {
+	store.Store
+}
+
+func (s childrenWatchStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	kvpairs, err := s.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []*store.KVPair, 1)
+	ch <- kvpairs
+	go func() {
+		<-stopCh
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func TestBindWatchKeys(t *testing.T) {
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	mutex.Lock()
+	kv = childrenWatchStore{kv}
+	mutex.Unlock()
+	assert.Nil(t, KVWrite("/bind/redis/master", "127.0.0.1:6379"))
+	var cfg struct {
+		Master string `kv:"master"`
+	}
+	b, err := Bind("/bind/redis", &cfg, FormatJSON)
+	assert.Nil(t, err)
+	defer b.Close()
+	assert.Nil(t, KVWrite("/bind/redis/master", "127.0.0.2:6379"))
+	assert.Eventually(t, func() bool {
+		return reflect.ValueOf(b.Load()).Elem().FieldByName("Master").String() == "127.0.0.2:6379"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestValidateValue(t *testing.T) {
+	var s = "debug"
+	assert.Nil(t, validateValue("oneof=debug|info", reflect.ValueOf(s)))
+	assert.NotNil(t, validateValue("oneof=info|warn", reflect.ValueOf(s)))
+	assert.Nil(t, validateValue("min=1,max=5", reflect.ValueOf(s)))
+	assert.NotNil(t, validateValue("max=3", reflect.ValueOf(s)))
+	// 错误中不包含配置的值
+	err := validateValue("oneof=info|warn", reflect.ValueOf(s))
+	assert.NotContains(t, err.Error(), s)
+	err = validateValue("min=10", reflect.ValueOf(3))
+	assert.Equal(t, "not satisfy min=10", err.Error())
+}
+
+func TestBindSecret(t *testing.T) {
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	key, _ := secret.GenerateKey()
+	masters, _ := secret.ParseKeys(key)
+	keyring, _ := secret.NewKeyring(masters...)
+	secret.SetDefault(keyring)
+	defer secret.SetDefault(nil)
+
+	assert.Nil(t, KVWriteSecret("/secret/redis/password", "redis-password"))
+	raw, _ := KVGet("/secret/redis/password")
+	assert.True(t, secret.IsSecret(raw))
+	assert.Equal(t, "redis-password", KVRead("/secret/redis/password", ""))
+
+	var cfg struct {
+		Password secret.String `kv:"password"`
+	}
+	b, err := Bind("/secret/redis", &cfg, FormatJSON)
+	assert.Nil(t, err)
+	b.Close()
+	assert.Equal(t, "redis-password", cfg.Password.Value())
+}
@@ -1,131 +1,453 @@
 package discovery
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/microsvs/base/pkg/env"
 	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/secret"
 	"github.com/microsvs/libkv"
 	"github.com/microsvs/libkv/store"
+	"github.com/microsvs/libkv/store/consul"
+	"github.com/microsvs/libkv/store/etcd"
 	"github.com/microsvs/libkv/store/zookeeper"
 )
 
+// State 配置中心连接状态
+type State string
+
+const (
+	StateNotInitialized State = "not initialized"
+	StateConnected      State = "connected"
+	StateDisconnected   State = "disconnected" // 连接断开, 正在重连
+	StateFailed         State = "failed"       // 初始化失败
+)
+
+// Status 配置中心当前状态, 不可用时KVRead返回快照中的值或者默认值, 其他操作返回错误
+// Stale表示不可用期间使用过本地快照, 配置可能已经过期
+type Status struct {
+	State     State
+	Backend   store.Backend
+	Endpoints []string
+	Err       error
+	Stale     bool
+}
+
+func (s Status) String() string {
+	str := fmt.Sprintf("%s %s %s", s.Backend, strings.Join(s.Endpoints, ","), s.State)
+	if s.Stale {
+		str += " (stale snapshot)"
+	}
+	if s.Err != nil {
+		str += ". err=" + s.Err.Error()
+	}
+	return strings.TrimSpace(str)
+}
+
+func (s Status) error() error {
+	if s.Err != nil {
+		return fmt.Errorf("discovery %s. err=%s", s.State, s.Err.Error())
+	}
+	return ErrNotInitialized
+}
+
+var ErrNotInitialized = errors.New("discovery not initialized")
+
+// DefaultTimeout 连接配置中心的默认超时时间
+var DefaultTimeout = 10 * time.Second
+
+var (
+	// ReconnectInterval 重连失败以后等待的默认间隔, 每次失败增加一个间隔
+	ReconnectInterval = 3 * time.Second
+	// ReconnectMaxInterval 重连等待的最大间隔
+	ReconnectMaxInterval = 30 * time.Second
+)
+
+// Options Init的参数, Config为空时使用环境变量APP_ZK, go test中未设置APP_ZK时使用内存
+// 间隔为0时使用对应的包级默认值, 单元测试通过Options缩短间隔, 不要修改包级变量
+type Options struct {
+	Config  string
+	Timeout time.Duration
+	// ReconnectInterval 重连失败以后等待的间隔
+	ReconnectInterval time.Duration
+	// WatchRetryInterval key不存在或者watch中断以后重新watch的间隔
+	WatchRetryInterval time.Duration
+	// FileWatchInterval 本地目录配置中心watch的轮询间隔
+	FileWatchInterval time.Duration
+}
+
 //ZKConn 所有的配置入口
 var (
-	zkInitDone = make(chan struct{})
-	kv         store.Store
-	kvType     store.Backend = store.ZK // default zk config
+	mutex    sync.RWMutex
+	kv       store.Store
+	status   = Status{State: StateNotInitialized}
+	lazyInit sync.Once
+	gen      int     // Init的次数, 被替换的连接不再重连
+	options  Options // 最近一次Init的参数
 )
 
 var memAtomic sync.Map
 
-//Read 统一的配置中心维护机制，适用于简单变量
+// Init 连接配置中心, 断开以后自动重连, 重复调用时替换之前的连接
+// 连接失败时返回错误, 同时在后台重连, 连接成功以后状态变为StateConnected
+// 未调用Init时第一次读写配置会按默认参数初始化
+/* example
+if err := discovery.Init(discovery.Options{Timeout: 5 * time.Second}); err != nil {
+	log.ErrorRaw("init discovery failed. err=%s", err.Error())
+}
+discovery.Init(discovery.Options{Config: "memory://"}) // 单元测试
+*/
+func Init(opts Options) error {
+	var err error
+	if len(opts.Config) <= 0 {
+		opts.Config = defaultConfig()
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.ReconnectInterval <= 0 {
+		opts.ReconnectInterval = ReconnectInterval
+	}
+	if opts.WatchRetryInterval <= 0 {
+		opts.WatchRetryInterval = WatchRetryInterval
+	}
+	if opts.FileWatchInterval <= 0 {
+		opts.FileWatchInterval = FileWatchInterval
+	}
+	mutex.Lock()
+	gen++
+	g := gen
+	options = opts
+	mutex.Unlock()
+	cur := Status{State: StateFailed}
+	if cur.Backend, cur.Endpoints, err = parseKVConfig(opts.Config); err != nil {
+		cur.Err = err
+		setStore(g, nil, cur)
+		return err
+	}
+	s, err := newStore(cur.Backend, cur.Endpoints, opts)
+	if err != nil {
+		cur.Err = err
+		setStore(g, nil, cur)
+		go func() {
+			if s := reconnect(g, cur, opts); s != nil {
+				maintainKV(g, s, cur, opts)
+			}
+		}()
+		return cur.error()
+	}
+	cur.State = StateConnected
+	setStore(g, s, cur)
+	go maintainKV(g, s, cur, opts)
+	return nil
+}
+
+// GetStatus 返回配置中心当前状态
+func GetStatus() Status {
+	mutex.RLock()
+	cur := status
+	mutex.RUnlock()
+	cur.Stale = cur.State != StateConnected && snapshotUsed()
+	return cur
+}
+
+func defaultConfig() string {
+	if len(os.Getenv(string(env.KVConfig))) <= 0 && flag.Lookup("test.v") != nil {
+		return string(MEMORY) + "://"
+	}
+	config, _ := env.Get(env.KVConfig)
+	return config
+}
+
+// newStore 创建连接, 超过opts.Timeout返回错误
+func newStore(backend store.Backend, endpoints []string, opts Options) (store.Store, error) {
+	type result struct {
+		s   store.Store
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		s, err := libkv.NewStore(backend, endpoints, &store.Config{ConnectionTimeout: opts.Timeout})
+		if fs, ok := s.(*fileStore); ok {
+			fs.interval = opts.FileWatchInterval
+		}
+		ch <- result{s, err}
+	}()
+	select {
+	case ret := <-ch:
+		return ret.s, ret.err
+	case <-time.After(opts.Timeout):
+		return nil, fmt.Errorf("connect %s %s timeout", backend, strings.Join(endpoints, ","))
+	}
+}
+
+// setStore 替换连接时清空KVRead的缓存, 连接已经被之后的Init替换时返回false
+func setStore(g int, s store.Store, cur Status) bool {
+	mutex.Lock()
+	if g != gen {
+		mutex.Unlock()
+		return false
+	}
+	old := kv
+	kv, status = s, cur
+	mutex.Unlock()
+	if cur.State == StateConnected {
+		snapshotReset()
+	}
+	if old != s {
+		if old != nil {
+			old.Close()
+		}
+		memAtomic.Range(func(key, value interface{}) bool {
+			memAtomic.Delete(key)
+			return true
+		})
+		notifyStoreChanged()
+	}
+	return true
+}
+
+// getStore 未初始化时按默认参数初始化, 不可用时返回状态对应的错误
+func getStore() (store.Store, error) {
+	lazyInit.Do(func() {
+		if GetStatus().State != StateNotInitialized {
+			return
+		}
+		if err := Init(Options{}); err != nil {
+			log.ErrorRaw("[discovery] init with default options failed. err=%s", err.Error())
+		}
+	})
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if kv == nil {
+		return nil, status.error()
+	}
+	return kv, nil
+}
+
+//Read 统一的配置中心维护机制，适用于简单变量, 配置中心不可用时返回快照中的值, 快照中也没有时返回def
 func KVRead(path string, def string) string {
 	var (
 		ret    string = def
 		kvpair *store.KVPair
+		s      store.Store
 		err    error
 	)
-	if path[:1] != "/" {
-		serviceEnv, _ := env.Get(env.ServiceENV)
-		serviceName, _ := env.Get(env.ServiceName)
-		serviceVer, _ := env.Get(env.ServiceVer)
-		path = fmt.Sprintf("/%s/%s/%s/%s", serviceName, serviceVer, serviceEnv, path)
-	}
+	path = fullPath(path)
 	if value, ok := memAtomic.Load(path); !ok {
-		if kvpair, err = kv.Get(path); err != nil {
+		if s, err = getStore(); err != nil {
+			return snapshotRead(path, def)
+		}
+		if kvpair, err = s.Get(path); err != nil {
+			if err != store.ErrKeyNotFound {
+				return snapshotRead(path, def)
+			}
+			return def
+		}
+		var ok bool
+		if ret, ok = reveal(path, string(kvpair.Value)); !ok {
 			return def
 		}
-		ret = string(kvpair.Value)
 		memAtomic.Store(path, ret)
-		go watch(path) // watch key-value change
+		snapshotStore(kvpair)
+		go cacheWatch(path) // watch key-value change
 	} else {
 		ret = value.(string)
 	}
 	return ret
 }
 
-func watch(key string) {
-	var (
-		stopCh   = make(chan struct{})
-		err      error
-		kvpairCh <-chan *store.KVPair
-	)
-	if kvpairCh, err = kv.Watch(key, stopCh); err != nil {
-		close(stopCh) // stop watch interval goroutine
-		memAtomic.Delete(key)
-		log.ErrorRaw("watch %s failed. err=%s", key, err.Error())
-		return
+//KVGet 直接读取配置中心, 不使用缓存和快照, key不存在时返回store.ErrKeyNotFound
+func KVGet(path string) (string, error) {
+	s, err := getStore()
+	if err != nil {
+		return "", err
 	}
-	for {
-		kvpair := <-kvpairCh
-		memAtomic.Store(key, string(kvpair.Value))
+	kvpair, err := s.Get(fullPath(path))
+	if err != nil {
+		return "", err
+	}
+	return string(kvpair.Value), nil
+}
+
+//KVWrite 写入配置, path规则与KVRead一致
+func KVWrite(path string, value string) error {
+	s, err := getStore()
+	if err != nil {
+		return err
 	}
-	return
+	return s.Put(fullPath(path), []byte(value), nil)
 }
 
-func Watch(path string) (kvpairCh <-chan *store.KVPair, err error) {
-	var stopCh = make(chan struct{})
-	if path[:1] != "/" {
-		serviceEnv, _ := env.Get(env.ServiceENV)
-		serviceName, _ := env.Get(env.ServiceName)
-		serviceVer, _ := env.Get(env.ServiceVer)
-		path = fmt.Sprintf("/%s/%s/%s/%s", serviceName, serviceVer, serviceEnv, path)
+//KVWriteSecret 加密以后写入配置, KVRead和Bind读取时自动解密
+/* example
+discovery.KVWriteSecret("cache/user/master", "password@127.0.0.1:6379")
+*/
+func KVWriteSecret(path string, value string) error {
+	encrypted, err := secret.Encrypt(value)
+	if err != nil {
+		return err
 	}
-	if kvpairCh, err = kv.Watch(path, stopCh); err != nil {
-		close(stopCh) // stop watch interval goroutine
-		log.ErrorRaw("watch %s failed. err=%s", path, err.Error())
+	return KVWrite(path, encrypted)
+}
+
+// reveal 解密secret, 失败时只记录path, 不记录值
+func reveal(path string, value string) (string, bool) {
+	plain, err := secret.Decrypt(value)
+	if err != nil {
+		log.ErrorRaw("[discovery] decrypt %s failed. err=%s", path, err.Error())
+		return "", false
+	}
+	return plain, true
+}
+
+//KVDelete 删除配置, path规则与KVRead一致
+func KVDelete(path string) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	return s.Delete(fullPath(path))
+}
+
+//KVList 列出目录下的所有配置, 目录不存在时返回store.ErrKeyNotFound
+func KVList(path string) ([]*store.KVPair, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.List(fullPath(path))
+}
+
+// 相对路径补全为: /APP_NAME/APP_VERSION/APP_ENV/path
+func fullPath(path string) string {
+	if path[:1] == "/" {
+		return path
+	}
+	serviceEnv, _ := env.Get(env.ServiceENV)
+	serviceName, _ := env.Get(env.ServiceName)
+	serviceVer, _ := env.Get(env.ServiceVer)
+	return ServicePath(serviceName, serviceVer, serviceEnv, path)
+}
+
+// ServicePath 指定服务的配置路径: /service/version/env/path, 用于读写其他服务或者其他环境的配置
+func ServicePath(service, version, env, path string) string {
+	return strings.TrimRight(fmt.Sprintf("/%s/%s/%s/%s", service, version, env, strings.TrimLeft(path, "/")), "/")
+}
+
+var cacheWatches sync.Map
+
+// cacheWatch 每个path只watch一次, 配置变化时更新KVRead的缓存
+func cacheWatch(path string) {
+	if _, loaded := cacheWatches.LoadOrStore(path, true); loaded {
 		return
 	}
-	return kvpairCh, nil
+	ch, err := WatchContext(context.Background(), path)
+	if err != nil {
+		cacheWatches.Delete(path)
+		return
+	}
+	for kvpair := range ch {
+		if value, ok := reveal(path, string(kvpair.Value)); ok {
+			memAtomic.Store(path, value)
+		} else {
+			memAtomic.Delete(path)
+		}
+		snapshotStore(kvpair)
+	}
 }
 
-func maintainKV() {
+// maintainKV 连接断开时重连, 连接被Init替换以后退出, 本地目录和内存不会断开连接
+func maintainKV(g int, s store.Store, cur Status, opts Options) {
+	if cur.Backend == FILE || cur.Backend == MEMORY {
+		return
+	}
 	var (
-		err      error
-		waiting  = make(chan struct{})
-		stop     = make(chan struct{})
-		retryMax = 3
-		curTimes int
-		interval time.Duration
+		waiting = make(chan struct{})
+		stop    = make(chan struct{})
 	)
-	endpointStr, _ := env.Get(env.KVConfig)
-	endpoints := strings.Split(endpointStr, ",")
-	if kv, err = libkv.NewStore(kvType, endpoints, &store.Config{} /*nil*/); err != nil {
-		panic(err)
-	}
-
-	//首次初始化
-	zkInitDone <- struct{}{}
 	for {
-		kv.WaitingConnCloseState(waiting, stop)
+		s.WaitingConnCloseState(waiting, stop)
 		select {
 		case <-stop:
 			close(stop)
 			close(waiting)
 			return
 		case <-waiting:
-			// reconnect
-			for curTimes <= retryMax {
-				kv, err = libkv.NewStore(kvType, endpoints, &store.Config{} /*nil*/)
-				if err != nil {
-					interval += 3 * time.Second
-					time.Sleep(interval)
-					curTimes += 1
-				} else {
-					break
-				}
+			cur.State = StateDisconnected
+			if !setStore(g, s, cur) {
+				return
+			}
+			if s = reconnect(g, cur, opts); s == nil {
+				return
 			}
+			cur.State, cur.Err = StateConnected, nil
 		}
 	}
-	return
+}
+
+// reconnect 重连直到成功, 连接被Init替换以后返回nil
+func reconnect(g int, cur Status, opts Options) store.Store {
+	var interval time.Duration
+	for {
+		if interval < ReconnectMaxInterval {
+			interval += opts.ReconnectInterval
+		}
+		time.Sleep(interval)
+		mutex.RLock()
+		replaced := g != gen
+		mutex.RUnlock()
+		if replaced {
+			return nil
+		}
+		s, err := newStore(cur.Backend, cur.Endpoints, opts)
+		if err != nil {
+			log.ErrorRaw("[discovery] reconnect %s failed. err=%s", cur.Backend, err.Error())
+			continue
+		}
+		cur.State, cur.Err = StateConnected, nil
+		if !setStore(g, s, cur) {
+			s.Close()
+			return nil
+		}
+		return s
+	}
+}
+
+// parseKVConfig 解析配置中心地址, 没有scheme时为zookeeper
+/* example
+APP_ZK=127.0.0.1:2181,127.0.0.2:2181
+APP_ZK=zk://127.0.0.1:2181
+APP_ZK=etcd://127.0.0.1:2379
+APP_ZK=consul://127.0.0.1:8500
+APP_ZK=file:///tmp/kv
+APP_ZK=memory://
+*/
+func parseKVConfig(config string) (store.Backend, []string, error) {
+	var backend, addrs = store.ZK, config
+	if idx := strings.Index(config, "://"); idx >= 0 {
+		backend, addrs = store.Backend(config[:idx]), config[idx+3:]
+	}
+	switch backend {
+	case store.ZK, store.ETCD, store.CONSUL:
+		return backend, strings.Split(addrs, ","), nil
+	case FILE, MEMORY:
+		return backend, []string{addrs}, nil
+	}
+	return backend, nil, fmt.Errorf("%s %s", store.ErrBackendNotSupported.Error(), backend)
 }
 
 func init() {
 	zookeeper.Register()
-	go maintainKV() // 初始化ZK连接，如果断开或者超时自动重连
-	<-zkInitDone
+	etcd.Register()
+	consul.Register()
+	libkv.AddStore(FILE, newFileStore)
+	libkv.AddStore(MEMORY, newMemoryStore)
 }
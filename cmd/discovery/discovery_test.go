@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInit(t *testing.T) {
+	// 初始化失败时读取配置返回默认值
+	assert.NotNil(t, Init(Options{Config: "redis://127.0.0.1:6379"}))
+	assert.Equal(t, StateFailed, GetStatus().State)
+	assert.Equal(t, "def", KVRead("db/user/master", "def"))
+	assert.NotNil(t, KVWrite("db/user/master", "root@tcp(127.0.0.1)"))
+	_, err := Watch("db/user/master")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, Init(Options{Config: "memory://", Timeout: time.Second}))
+	assert.Equal(t, StateConnected, GetStatus().State)
+	assert.Equal(t, "def", KVRead("db/user/master", "def"))
+	assert.Nil(t, KVWrite("db/user/master", "root@tcp(127.0.0.1)"))
+	assert.Equal(t, "root@tcp(127.0.0.1)", KVRead("db/user/master", "def"))
+
+	// KVRead缓存的值随配置变化
+	assert.Nil(t, KVWrite("db/user/master", "root@tcp(127.0.0.2)"))
+	assert.Eventually(t, func() bool {
+		return KVRead("db/user/master", "def") == "root@tcp(127.0.0.2)"
+	}, time.Second, 10*time.Millisecond)
+
+	kvpairs, err := KVList("db/user")
+	assert.Nil(t, err)
+	assert.Len(t, kvpairs, 1)
+	assert.Nil(t, KVDelete("db/user/master"))
+}
+
+// 启动时配置中心不可用, 恢复以后后台重连成功
+func TestInitReconnect(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "kv-")
+	defer os.RemoveAll(dir)
+	// 根目录的上级是文件, 无法创建根目录
+	blocker := filepath.Join(dir, "blocker")
+	assert.Nil(t, ioutil.WriteFile(blocker, nil, 0644))
+	assert.NotNil(t, Init(Options{
+		Config:            "file://" + filepath.Join(blocker, "kv"),
+		Timeout:           time.Second,
+		ReconnectInterval: 10 * time.Millisecond,
+	}))
+	assert.Equal(t, StateFailed, GetStatus().State)
+
+	assert.Nil(t, os.Remove(blocker))
+	assert.Eventually(t, func() bool {
+		return GetStatus().State == StateConnected
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, KVWrite("db/user/master", "root@tcp(127.0.0.1)"))
+	assert.Equal(t, "root@tcp(127.0.0.1)", KVRead("db/user/master", "def"))
+}
+
+func TestKVTree(t *testing.T) {
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	assert.Nil(t, KVWrite(ServicePath("user", "v1.0", "dev", "db/user/master"), "root@tcp(127.0.0.1)"))
+	assert.Nil(t, KVWrite(ServicePath("user", "v1.0", "dev", "cache/redis"), "127.0.0.1:6379"))
+	kvpairs, err := KVTree(ServicePath("user", "v1.0", "dev", ""))
+	assert.Nil(t, err)
+	assert.Len(t, kvpairs, 2)
+	assert.Equal(t, "/user/v1.0/dev/cache/redis", kvpairs[0].Key)
+	value, err := KVGet("/user/v1.0/dev/db/user/master")
+	assert.Nil(t, err)
+	assert.Equal(t, "root@tcp(127.0.0.1)", value)
+}
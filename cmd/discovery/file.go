@@ -0,0 +1,294 @@
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsvs/libkv/store"
+)
+
+// FILE 本地目录作为配置中心, 每个key对应目录下的一个文件, 用于本地开发和测试
+const FILE store.Backend = "file"
+
+// FileWatchInterval 文件配置中心watch的默认轮询间隔
+var FileWatchInterval = time.Second
+
+// ErrInvalidKey key中的..超出了根目录
+var ErrInvalidKey = errors.New("key out of root directory")
+
+type fileStore struct {
+	mutex    sync.Mutex
+	root     string
+	interval time.Duration // watch的轮询间隔
+}
+
+// newFileStore addrs[0]为根目录, 不存在时自动创建
+func newFileStore(addrs []string, options *store.Config) (store.Store, error) {
+	if len(addrs) <= 0 || len(addrs[0]) <= 0 {
+		return nil, store.ErrNotReachable
+	}
+	if err := os.MkdirAll(addrs[0], 0755); err != nil {
+		return nil, err
+	}
+	return &fileStore{root: addrs[0], interval: FileWatchInterval}, nil
+}
+
+// path key对应的文件, 清理以后不在根目录下时返回ErrInvalidKey
+func (s *fileStore) path(key string) (string, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(strings.Trim(key, "/")))
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+	return path, nil
+}
+
+func (s *fileStore) get(key string) (*store.KVPair, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return nil, store.ErrKeyNotFound
+	}
+	value, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &store.KVPair{Key: key, Value: value, LastIndex: uint64(info.ModTime().UnixNano())}, nil
+}
+
+// put 先写临时文件再改名, watch不会读到写了一半的内容
+func (s *fileStore) put(key string, value []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(value); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *fileStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if options != nil && options.IsDir {
+		path, err := s.path(key)
+		if err != nil {
+			return err
+		}
+		return os.MkdirAll(path, 0755)
+	}
+	return s.put(key, value)
+}
+
+func (s *fileStore) Get(key string) (*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.get(key)
+}
+
+func (s *fileStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return store.ErrKeyNotFound
+	}
+	return err
+}
+
+func (s *fileStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Watch 轮询文件内容, 首先返回当前值, 之后内容变化时返回新值
+func (s *fileStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	kvpair, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *store.KVPair, 1)
+	ch <- kvpair
+	go func() {
+		defer close(ch)
+		last := kvpair.Value
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(s.interval):
+			}
+			if kvpair, err = s.Get(key); err != nil || bytes.Equal(last, kvpair.Value) {
+				continue
+			}
+			last = kvpair.Value
+			select {
+			case ch <- kvpair:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// WatchTree 轮询目录, 目录下任意文件变化时返回所有文件
+func (s *fileStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	kvpairs, err := s.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []*store.KVPair, 1)
+	ch <- kvpairs
+	go func() {
+		defer close(ch)
+		last := kvpairs
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(s.interval):
+			}
+			if kvpairs, err = s.List(directory); err != nil || equalKVPairs(last, kvpairs) {
+				continue
+			}
+			last = kvpairs
+			select {
+			case ch <- kvpairs:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func equalKVPairs(a, b []*store.KVPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || !bytes.Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *fileStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// List 返回目录下的文件, 不包含子目录, 按名称排序
+func (s *fileStore) List(directory string) ([]*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path, err := s.path(directory)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, store.ErrKeyNotFound
+	}
+	var kvpairs []*store.KVPair
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			continue
+		}
+		kvpair, err := s.get(strings.TrimRight(directory, "/") + "/" + info.Name())
+		if err != nil {
+			continue
+		}
+		kvpairs = append(kvpairs, kvpair)
+	}
+	return kvpairs, nil
+}
+
+func (s *fileStore) DeleteTree(directory string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path, err := s.path(directory)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// AtomicPut previous为空时要求key不存在, 否则要求LastIndex一致, 只在本进程内保证原子性
+func (s *fileStore) AtomicPut(key string, value []byte, previous *store.KVPair,
+	options *store.WriteOptions) (bool, *store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, err := s.get(key)
+	switch {
+	case previous == nil && err == nil:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && err != nil:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && current.LastIndex != previous.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+	if err = s.put(key, value); err != nil {
+		return false, nil, err
+	}
+	kvpair, err := s.get(key)
+	return err == nil, kvpair, err
+}
+
+func (s *fileStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, err := s.get(key)
+	if err != nil {
+		return false, store.ErrKeyNotFound
+	}
+	if current.LastIndex != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	return true, os.Remove(path)
+}
+
+func (s *fileStore) Close() {}
+
+// WaitingConnCloseState 本地目录不会断开连接
+func (s *fileStore) WaitingConnCloseState(waiting chan struct{}, stop chan struct{}) {}
@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsvs/libkv/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKVConfig(t *testing.T) {
+	for config, want := range map[string][]string{
+		"127.0.0.1:2181,127.0.0.2:2181":   {"zk", "127.0.0.1:2181", "127.0.0.2:2181"},
+		"etcd://127.0.0.1:2379":           {"etcd", "127.0.0.1:2379"},
+		"consul://127.0.0.1:8500":         {"consul", "127.0.0.1:8500"},
+		"file:///tmp/kv":                  {"file", "/tmp/kv"},
+		"zk://127.0.0.1:2181,127.0.0.2:1": {"zk", "127.0.0.1:2181", "127.0.0.2:1"},
+	} {
+		backend, endpoints, err := parseKVConfig(config)
+		assert.Nil(t, err)
+		assert.Equal(t, want, append([]string{string(backend)}, endpoints...))
+	}
+	_, _, err := parseKVConfig("redis://127.0.0.1:6379")
+	assert.NotNil(t, err)
+}
+
+func TestFileStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "kv-")
+	defer os.RemoveAll(dir)
+	s, err := newFileStore([]string{dir}, nil)
+	assert.Nil(t, err)
+	s.(*fileStore).interval = 10 * time.Millisecond
+
+	_, err = s.Get("/user/v1.0/dev/mysql")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+	assert.Nil(t, s.Put("/user/v1.0/dev/mysql", []byte("root@tcp(127.0.0.1)"), nil))
+	assert.Nil(t, s.Put("/user/v1.0/dev/redis", []byte("127.0.0.1:6379"), nil))
+	kvpair, err := s.Get("/user/v1.0/dev/mysql")
+	assert.Nil(t, err)
+	assert.Equal(t, "root@tcp(127.0.0.1)", string(kvpair.Value))
+
+	kvpairs, err := s.List("/user/v1.0/dev")
+	assert.Nil(t, err)
+	assert.Len(t, kvpairs, 2)
+	assert.Equal(t, "/user/v1.0/dev/redis", kvpairs[1].Key)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ch, err := s.Watch("/user/v1.0/dev/mysql", stopCh)
+	assert.Nil(t, err)
+	assert.Equal(t, "root@tcp(127.0.0.1)", string((<-ch).Value))
+	assert.Nil(t, s.Put("/user/v1.0/dev/mysql", []byte("root@tcp(127.0.0.2)"), nil))
+	select {
+	case kvpair = <-ch:
+		assert.Equal(t, "root@tcp(127.0.0.2)", string(kvpair.Value))
+	case <-time.After(time.Second):
+		t.Fatal("watch timeout")
+	}
+
+	_, _, err = s.AtomicPut("/user/v1.0/dev/mysql", []byte("x"), nil, nil)
+	assert.Equal(t, store.ErrKeyExists, err)
+	ok, _, err := s.AtomicPut("/user/v1.0/dev/mysql", []byte("x"), kvpair, nil)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Delete("/user/v1.0/dev/mysql"))
+	exist, _ := s.Exists("/user/v1.0/dev/mysql")
+	assert.False(t, exist)
+
+	// key不能超出根目录
+	for _, key := range []string{"../escape", "/user/../../escape", ".."} {
+		assert.Equal(t, ErrInvalidKey, s.Put(key, []byte("x"), nil), key)
+		_, err = s.Get(key)
+		assert.Equal(t, ErrInvalidKey, err, key)
+		_, err = s.List(key)
+		assert.Equal(t, ErrInvalidKey, err, key)
+		assert.Equal(t, ErrInvalidKey, s.DeleteTree(key), key)
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escape"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, s.Put("/user/../user/v1.0/dev/mysql", []byte("x"), nil))
+}
@@ -0,0 +1,351 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/libkv/store"
+)
+
+var (
+	// DefaultLockTTL 租约锁的默认有效期, 持有期间每TTL/3续约一次
+	DefaultLockTTL = 15 * time.Second
+	// LockRetryInterval 锁被占用时重试的间隔
+	LockRetryInterval = 500 * time.Millisecond
+
+	ErrLockNotHeld = errors.New("lock not held")
+)
+
+// Mutex 基于配置中心的分布式锁
+// 配置中心支持NewLock时(zookeeper为临时顺序节点)使用原生锁, 其他后端使用AtomicPut实现的租约锁
+// 每次加锁成功返回单调递增的fencing token, 下游用token拒绝已经失去锁的旧持有者
+type Mutex struct {
+	path  string
+	ttl   time.Duration
+	owner string
+
+	mutex  sync.Mutex
+	held   bool
+	token  uint64
+	stopCh chan struct{}
+	lostCh chan struct{}
+	locker store.Locker  // 原生锁
+	lease  *store.KVPair // 租约锁
+}
+
+// lease 租约锁的值, expire为unix纳秒, 依赖各实例的时钟大致同步
+type lease struct {
+	Owner  string `json:"owner"`
+	Expire int64  `json:"expire"`
+}
+
+// NewMutex 创建分布式锁, path规则与KVRead一致, ttl<=0时使用DefaultLockTTL
+/* example
+m := discovery.NewMutex("locks/settle", 0)
+token, lost, err := m.Lock(ctx)
+if err != nil {
+	return err
+}
+defer m.Unlock()
+select {
+case <-lost: // 锁已经失效, 停止写入
+default:
+	db.Exec("UPDATE ... WHERE fence < ?", token)
+}
+*/
+func NewMutex(path string, ttl time.Duration) *Mutex {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	host, _ := os.Hostname()
+	return &Mutex{
+		path:  fullPath(path),
+		ttl:   ttl,
+		owner: fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+// Lock 阻塞直到加锁成功或者ctx结束, 返回fencing token和锁失效时关闭的channel
+func (m *Mutex) Lock(ctx context.Context) (uint64, <-chan struct{}, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.held {
+		return 0, nil, fmt.Errorf("lock %s already held", m.path)
+	}
+	s, err := getStore()
+	if err != nil {
+		return 0, nil, err
+	}
+	m.stopCh, m.lostCh = make(chan struct{}), make(chan struct{})
+	locker, err := s.NewLock(m.path, &store.LockOptions{Value: []byte(m.owner), TTL: m.ttl})
+	switch err {
+	case nil:
+		err = m.lockNative(ctx, locker)
+	case store.ErrCallNotSupported:
+		err = m.lockLease(ctx, s)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if m.token, err = nextFence(s, m.path+".fence"); err != nil {
+		m.unlock(s)
+		return 0, nil, err
+	}
+	m.held = true
+	return m.token, m.lostCh, nil
+}
+
+// lockNative 原生锁的Lock不一定响应stopChan, ctx结束以后拿到的锁直接释放
+func (m *Mutex) lockNative(ctx context.Context, locker store.Locker) error {
+	type result struct {
+		lost <-chan struct{}
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		lost, err := locker.Lock(m.stopCh)
+		ch <- result{lost, err}
+	}()
+	select {
+	case ret := <-ch:
+		if ret.err != nil {
+			return ret.err
+		}
+		m.locker = locker
+		go watchLost(ret.lost, m.stopCh, m.lostCh)
+		return nil
+	case <-ctx.Done():
+		close(m.stopCh)
+		go func() {
+			if ret := <-ch; ret.err == nil {
+				locker.Unlock()
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+func watchLost(lost <-chan struct{}, stopCh, lostCh chan struct{}) {
+	select {
+	case <-lost:
+		close(lostCh)
+	case <-stopCh:
+	}
+}
+
+// lockLease key不存在或者租约过期时用AtomicPut抢占
+func (m *Mutex) lockLease(ctx context.Context, s store.Store) error {
+	for {
+		kvpair, err := s.Get(m.path)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		var cur lease
+		if kvpair != nil {
+			json.Unmarshal(kvpair.Value, &cur)
+		}
+		if kvpair == nil || cur.Expire < time.Now().UnixNano() {
+			bts, _ := json.Marshal(lease{Owner: m.owner, Expire: time.Now().Add(m.ttl).UnixNano()})
+			ok, next, err := s.AtomicPut(m.path, bts, kvpair, nil)
+			if ok && err == nil {
+				m.lease = next
+				go m.renew(s, m.stopCh, m.lostCh)
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(LockRetryInterval):
+		}
+	}
+}
+
+// renew 每TTL/3续约一次, 续约失败或者被抢占时关闭lostCh
+func (m *Mutex) renew(s store.Store, stopCh, lostCh chan struct{}) {
+	ticker := time.NewTicker(m.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		m.mutex.Lock()
+		// 等锁期间可能已经Unlock
+		select {
+		case <-stopCh:
+			m.mutex.Unlock()
+			return
+		default:
+		}
+		bts, _ := json.Marshal(lease{Owner: m.owner, Expire: time.Now().Add(m.ttl).UnixNano()})
+		ok, next, err := s.AtomicPut(m.path, bts, m.lease, nil)
+		if ok && err == nil {
+			m.lease = next
+			m.mutex.Unlock()
+			continue
+		}
+		m.mutex.Unlock()
+		if err == nil {
+			err = store.ErrKeyModified
+		}
+		log.ErrorRaw("[Mutex.renew] lost lock %s. err=%s", m.path, err.Error())
+		close(lostCh)
+		return
+	}
+}
+
+// Unlock 释放锁, 锁已经失效时同样返回nil
+func (m *Mutex) Unlock() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.held {
+		return ErrLockNotHeld
+	}
+	m.held = false
+	s, err := getStore()
+	if err != nil {
+		close(m.stopCh)
+		return err
+	}
+	return m.unlock(s)
+}
+
+// unlock 需要持有m.mutex
+func (m *Mutex) unlock(s store.Store) (err error) {
+	close(m.stopCh)
+	if m.locker != nil {
+		err = m.locker.Unlock()
+	} else if m.lease != nil {
+		if _, err = s.AtomicDelete(m.path, m.lease); err == store.ErrKeyModified || err == store.ErrKeyNotFound {
+			err = nil // 已经被其他实例抢占
+		}
+	}
+	m.locker, m.lease = nil, nil
+	return err
+}
+
+// Token 当前持有锁的fencing token, 未持有时返回0
+func (m *Mutex) Token() uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.held {
+		return 0
+	}
+	return m.token
+}
+
+// nextFence 用AtomicPut递增计数器
+func nextFence(s store.Store, key string) (uint64, error) {
+	for {
+		var (
+			token  uint64 = 1
+			kvpair *store.KVPair
+			err    error
+		)
+		if kvpair, err = s.Get(key); err == nil {
+			if token, err = strconv.ParseUint(string(kvpair.Value), 10, 64); err != nil {
+				return 0, fmt.Errorf("invalid fencing token %s: %s", key, err.Error())
+			}
+			token++
+		} else if err != store.ErrKeyNotFound {
+			return 0, err
+		}
+		ok, _, err := s.AtomicPut(key, []byte(strconv.FormatUint(token, 10)), kvpair, nil)
+		switch {
+		case ok && err == nil:
+			return token, nil
+		case err == store.ErrKeyModified || err == store.ErrKeyExists || err == nil:
+			continue
+		default:
+			return 0, err
+		}
+	}
+}
+
+// Election 基于Mutex的选主, 当选时调用OnElected, 失去leader时调用OnRevoked, 之后重新参选
+type Election struct {
+	OnElected func(token uint64)
+	OnRevoked func()
+
+	mutex  *Mutex
+	leader sync.Mutex
+	token  uint64
+}
+
+// NewElection 创建选主, path规则与KVRead一致
+/* example
+e := discovery.NewElection("election/settle", 0)
+e.OnElected = func(token uint64) { log.InfoRaw("elected, token=%d", token) }
+go e.Run(ctx)
+// cron中
+if e.IsLeader() {
+	settle()
+}
+*/
+func NewElection(path string, ttl time.Duration) *Election {
+	return &Election{mutex: NewMutex(path, ttl)}
+}
+
+// Run 阻塞参选直到ctx结束, 结束时如果是leader则释放
+func (e *Election) Run(ctx context.Context) {
+	for {
+		token, lost, err := e.mutex.Lock(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.ErrorRaw("[Election.Run] campaign %s failed. err=%s", e.mutex.path, err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(LockRetryInterval):
+			}
+			continue
+		}
+		e.setToken(token)
+		if e.OnElected != nil {
+			e.OnElected(token)
+		}
+		select {
+		case <-lost:
+		case <-ctx.Done():
+		}
+		e.setToken(0)
+		e.mutex.Unlock()
+		if e.OnRevoked != nil {
+			e.OnRevoked()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (e *Election) setToken(token uint64) {
+	e.leader.Lock()
+	defer e.leader.Unlock()
+	e.token = token
+}
+
+// IsLeader 当前实例是否为leader
+func (e *Election) IsLeader() bool {
+	e.leader.Lock()
+	defer e.leader.Unlock()
+	return e.token > 0
+}
+
+// Token 当选时的fencing token, 不是leader时返回0
+func (e *Election) Token() uint64 {
+	e.leader.Lock()
+	defer e.leader.Unlock()
+	return e.token
+}
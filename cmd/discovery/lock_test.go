@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMutex(t *testing.T) {
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	LockRetryInterval = 10 * time.Millisecond
+	m1 := NewMutex("/locks/settle", time.Second)
+	m2 := NewMutex("/locks/settle", time.Second)
+
+	token1, lost, err := m1.Lock(context.Background())
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = m2.Lock(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 持有期间续约, 不会被抢占
+	time.Sleep(1500 * time.Millisecond)
+	select {
+	case <-lost:
+		t.Fatal("lock lost")
+	default:
+	}
+	assert.Nil(t, m1.Unlock())
+	assert.Equal(t, ErrLockNotHeld, m1.Unlock())
+
+	token2, _, err := m2.Lock(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, token2 > token1)
+	assert.Equal(t, token2, m2.Token())
+	assert.Nil(t, m2.Unlock())
+}
+
+func TestElection(t *testing.T) {
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	LockRetryInterval = 10 * time.Millisecond
+	var (
+		elected       = make(chan uint64, 2)
+		revoked       = make(chan struct{}, 2)
+		ctx1, cancel1 = context.WithCancel(context.Background())
+		ctx2, cancel2 = context.WithCancel(context.Background())
+	)
+	defer cancel2()
+	e1, e2 := NewElection("/election/settle", time.Second), NewElection("/election/settle", time.Second)
+	for _, e := range []*Election{e1, e2} {
+		e.OnElected = func(token uint64) { elected <- token }
+		e.OnRevoked = func() { revoked <- struct{}{} }
+	}
+	go e1.Run(ctx1)
+	<-elected
+	assert.True(t, e1.IsLeader())
+	go e2.Run(ctx2)
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, e2.IsLeader())
+
+	// leader退出以后重新选主
+	cancel1()
+	<-revoked
+	select {
+	case token := <-elected:
+		assert.Equal(t, token, e2.Token())
+	case <-time.After(time.Second):
+		t.Fatal("election timeout")
+	}
+	assert.False(t, e1.IsLeader())
+}
@@ -0,0 +1,244 @@
+package discovery
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/microsvs/libkv/store"
+)
+
+// MEMORY 进程内的配置中心, 用于单元测试, go test中未设置APP_ZK时默认使用
+const MEMORY store.Backend = "memory"
+
+type memoryStore struct {
+	mutex   sync.Mutex
+	index   uint64
+	data    map[string]*store.KVPair
+	changed chan struct{} // 每次修改后关闭并重新创建, 通知所有watch
+	closed  chan struct{}
+}
+
+func newMemoryStore(addrs []string, options *store.Config) (store.Store, error) {
+	return &memoryStore{
+		data:    make(map[string]*store.KVPair),
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+func normalizeKey(key string) string {
+	return "/" + strings.Trim(key, "/")
+}
+
+// set 需要持有锁, value为nil时删除
+func (s *memoryStore) set(key string, value []byte) *store.KVPair {
+	s.index++
+	var kvpair *store.KVPair
+	if value == nil {
+		delete(s.data, key)
+	} else {
+		kvpair = &store.KVPair{Key: key, Value: value, LastIndex: s.index}
+		s.data[key] = kvpair
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return kvpair
+}
+
+func (s *memoryStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if value == nil {
+		value = []byte{}
+	}
+	s.set(normalizeKey(key), value)
+	return nil
+}
+
+func (s *memoryStore) Get(key string) (*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if kvpair, ok := s.data[normalizeKey(key)]; ok {
+		return kvpair, nil
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key = normalizeKey(key)
+	if _, ok := s.data[key]; !ok {
+		return store.ErrKeyNotFound
+	}
+	s.set(key, nil)
+	return nil
+}
+
+func (s *memoryStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// wait 返回下一次修改时关闭的channel
+func (s *memoryStore) wait() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.changed
+}
+
+func (s *memoryStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	kvpair, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *store.KVPair, 1)
+	ch <- kvpair
+	go func() {
+		defer close(ch)
+		last := kvpair
+		for {
+			// 先取得通知channel再读取, 不会错过两者之间的修改
+			changed := s.wait()
+			if kvpair, err = s.Get(key); err == nil && kvpair.LastIndex != last.LastIndex {
+				last = kvpair
+				select {
+				case ch <- kvpair:
+				case <-stopCh:
+					return
+				}
+			}
+			select {
+			case <-stopCh:
+				return
+			case <-s.closed:
+				return
+			case <-changed:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (s *memoryStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	kvpairs, err := s.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []*store.KVPair, 1)
+	ch <- kvpairs
+	go func() {
+		defer close(ch)
+		last := kvpairs
+		for {
+			changed := s.wait()
+			if kvpairs, err = s.List(directory); err == nil && !equalKVPairs(last, kvpairs) {
+				last = kvpairs
+				select {
+				case ch <- kvpairs:
+				case <-stopCh:
+					return
+				}
+			}
+			select {
+			case <-stopCh:
+				return
+			case <-s.closed:
+				return
+			case <-changed:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (s *memoryStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// List 返回目录下一级的配置, 按名称排序
+func (s *memoryStore) List(directory string) ([]*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	prefix := strings.TrimRight(normalizeKey(directory), "/") + "/"
+	var kvpairs []*store.KVPair
+	for key, kvpair := range s.data {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") {
+			kvpairs = append(kvpairs, kvpair)
+		}
+	}
+	if len(kvpairs) <= 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Slice(kvpairs, func(i, j int) bool {
+		return kvpairs[i].Key < kvpairs[j].Key
+	})
+	return kvpairs, nil
+}
+
+func (s *memoryStore) DeleteTree(directory string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	prefix := strings.TrimRight(normalizeKey(directory), "/") + "/"
+	for key := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			s.set(key, nil)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) AtomicPut(key string, value []byte, previous *store.KVPair,
+	options *store.WriteOptions) (bool, *store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key = normalizeKey(key)
+	current, ok := s.data[key]
+	switch {
+	case previous == nil && ok:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && !ok:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && current.LastIndex != previous.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return true, s.set(key, value), nil
+}
+
+func (s *memoryStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key = normalizeKey(key)
+	current, ok := s.data[key]
+	if !ok {
+		return false, store.ErrKeyNotFound
+	}
+	if current.LastIndex != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	s.set(key, nil)
+	return true, nil
+}
+
+// Close 结束所有watch
+func (s *memoryStore) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+}
+
+func (s *memoryStore) WaitingConnCloseState(waiting chan struct{}, stop chan struct{}) {}
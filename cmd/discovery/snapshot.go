@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/microsvs/base/pkg/env"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/libkv/store"
+)
+
+// SnapshotFile 本地快照文件, 保存最近一次从配置中心读取成功的值, 为空时使用环境变量APP_ZK_SNAPSHOT
+// 配置中心不可用时KVRead和Bind使用快照中的值, Status.Stale为true直到重新连接
+var SnapshotFile string
+
+type snapshot struct {
+	mutex  sync.Mutex
+	file   string
+	values map[string]string
+	used   bool // 配置中心不可用期间是否读取过快照
+}
+
+var snap snapshot
+
+func snapshotFile() string {
+	if len(SnapshotFile) > 0 {
+		return SnapshotFile
+	}
+	// go test默认不写快照
+	if len(os.Getenv(string(env.KVSnapshot))) <= 0 && flag.Lookup("test.v") != nil {
+		return ""
+	}
+	file, _ := env.Get(env.KVSnapshot)
+	return file
+}
+
+// load 需要持有锁, SnapshotFile变化时重新读取
+func (s *snapshot) load() {
+	file := snapshotFile()
+	if s.values != nil && s.file == file {
+		return
+	}
+	s.file, s.values = file, make(map[string]string)
+	if len(s.file) <= 0 {
+		return
+	}
+	bts, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.ErrorRaw("[snapshot.load] read %s failed. err=%s", s.file, err.Error())
+		}
+		return
+	}
+	if err = json.Unmarshal(bts, &s.values); err != nil {
+		log.ErrorRaw("[snapshot.load] parse %s failed. err=%s", s.file, err.Error())
+	}
+}
+
+// save 需要持有锁, 先写临时文件再rename, 避免进程退出时写坏快照
+func (s *snapshot) save() error {
+	bts, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, bts, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// snapshotStore 记录从配置中心读取成功的值
+func snapshotStore(kvpairs ...*store.KVPair) {
+	snap.mutex.Lock()
+	defer snap.mutex.Unlock()
+	snap.load()
+	if len(snap.file) <= 0 {
+		return
+	}
+	changed := false
+	for _, kvpair := range kvpairs {
+		if value, ok := snap.values[kvpair.Key]; !ok || value != string(kvpair.Value) {
+			snap.values[kvpair.Key] = string(kvpair.Value)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := snap.save(); err != nil {
+		log.ErrorRaw("[snapshotStore] write %s failed. err=%s", snap.file, err.Error())
+	}
+}
+
+// snapshotRead 配置中心不可用时读取快照, 快照中没有时返回def
+func snapshotRead(path string, def string) string {
+	snap.mutex.Lock()
+	defer snap.mutex.Unlock()
+	snap.load()
+	if value, ok := snap.values[path]; ok {
+		if plain, ok := reveal(path, value); ok {
+			snap.used = true
+			return plain
+		}
+	}
+	return def
+}
+
+// snapshotList 配置中心不可用时从快照读取目录下一级的配置
+func snapshotList(dir string) []*store.KVPair {
+	snap.mutex.Lock()
+	defer snap.mutex.Unlock()
+	snap.load()
+	var (
+		kvpairs []*store.KVPair
+		prefix  = strings.TrimRight(dir, "/") + "/"
+	)
+	for key, value := range snap.values {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") {
+			kvpairs = append(kvpairs, &store.KVPair{Key: key, Value: []byte(value)})
+		}
+	}
+	if len(kvpairs) > 0 {
+		snap.used = true
+	}
+	sort.Slice(kvpairs, func(i, j int) bool {
+		return kvpairs[i].Key < kvpairs[j].Key
+	})
+	return kvpairs
+}
+
+func snapshotUsed() bool {
+	snap.mutex.Lock()
+	defer snap.mutex.Unlock()
+	return snap.used
+}
+
+// snapshotReset 重新连接以后不再是过期配置
+func snapshotReset() {
+	snap.mutex.Lock()
+	defer snap.mutex.Unlock()
+	snap.used = false
+}
@@ -0,0 +1,43 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "snapshot-")
+	defer os.RemoveAll(dir)
+	SnapshotFile = filepath.Join(dir, "user.kv.snapshot")
+	defer func() { SnapshotFile = "" }()
+
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	assert.Nil(t, KVWrite("/snapshot/mysql/master", "root@tcp(127.0.0.1)"))
+	assert.Nil(t, KVWrite("/snapshot/mysql/max_conn", "200"))
+	assert.Equal(t, "root@tcp(127.0.0.1)", KVRead("/snapshot/mysql/master", ""))
+	var cfg testMysqlConfig
+	b, err := Bind("/snapshot/mysql", &cfg, FormatJSON)
+	assert.Nil(t, err)
+	b.Close()
+	_, err = os.Stat(SnapshotFile)
+	assert.Nil(t, err)
+
+	// 配置中心不可用时使用快照
+	assert.NotNil(t, Init(Options{Config: "redis://127.0.0.1:6379"}))
+	assert.False(t, GetStatus().Stale)
+	snap.values = nil // 模拟重启
+	assert.Equal(t, "root@tcp(127.0.0.1)", KVRead("/snapshot/mysql/master", ""))
+	assert.Equal(t, "def", KVRead("/snapshot/mysql/slave", "def"))
+	assert.True(t, GetStatus().Stale)
+	b, err = Bind("/snapshot/mysql", &cfg, FormatJSON)
+	assert.Nil(t, err)
+	b.Close()
+	assert.Equal(t, 200, cfg.MaxConn)
+
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	assert.False(t, GetStatus().Stale)
+}
@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/microsvs/libkv/store"
+)
+
+// treeLister 可以一次列出整个子树的后端, memory和file的List只返回下一级的配置
+type treeLister interface {
+	ListTree(directory string) ([]*store.KVPair, error)
+}
+
+// KVTree 递归列出目录下的所有配置, 按key排序, path规则与KVRead一致
+func KVTree(path string) ([]*store.KVPair, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	path = fullPath(path)
+	if lister, ok := s.(treeLister); ok {
+		return lister.ListTree(path)
+	}
+	var (
+		kvpairs []*store.KVPair
+		seen    = make(map[string]bool)
+	)
+	if err = walkTree(s, path, seen, &kvpairs); err != nil {
+		return nil, err
+	}
+	if len(kvpairs) <= 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sortKVPairs(kvpairs)
+	return kvpairs, nil
+}
+
+// walkTree zookeeper的节点同时可以有值和子节点, 每个节点都继续List
+func walkTree(s store.Store, dir string, seen map[string]bool, kvpairs *[]*store.KVPair) error {
+	children, err := s.List(dir)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, kvpair := range children {
+		key := "/" + strings.Trim(kvpair.Key, "/")
+		if seen[key] || key == dir {
+			continue
+		}
+		seen[key] = true
+		*kvpairs = append(*kvpairs, kvpair)
+		if err = walkTree(s, key, seen, kvpairs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortKVPairs(kvpairs []*store.KVPair) {
+	sort.Slice(kvpairs, func(i, j int) bool {
+		return kvpairs[i].Key < kvpairs[j].Key
+	})
+}
+
+// ListTree 返回目录下所有层级的配置
+func (s *memoryStore) ListTree(directory string) ([]*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	prefix := strings.TrimRight(normalizeKey(directory), "/") + "/"
+	var kvpairs []*store.KVPair
+	for key, kvpair := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			kvpairs = append(kvpairs, kvpair)
+		}
+	}
+	if len(kvpairs) <= 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sortKVPairs(kvpairs)
+	return kvpairs, nil
+}
+
+// ListTree 递归读取目录下的所有文件
+func (s *fileStore) ListTree(directory string) ([]*store.KVPair, error) {
+	kvpairs, err := s.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	dir, _ := s.path(directory) // List已经校验过
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		children, err := s.ListTree(strings.TrimRight(directory, "/") + "/" + info.Name())
+		if err != nil && err != store.ErrKeyNotFound {
+			return nil, err
+		}
+		kvpairs = append(kvpairs, children...)
+	}
+	if len(kvpairs) <= 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sortKVPairs(kvpairs)
+	return kvpairs, nil
+}
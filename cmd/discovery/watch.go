@@ -0,0 +1,226 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/libkv/store"
+)
+
+// WatchRetryInterval key不存在或者watch中断以后重新watch的默认间隔, 配置中心重连以后立即重新watch
+var WatchRetryInterval = 5 * time.Second
+
+// watchRetryInterval 最近一次Init设置的间隔
+func watchRetryInterval() time.Duration {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if options.WatchRetryInterval > 0 {
+		return options.WatchRetryInterval
+	}
+	return WatchRetryInterval
+}
+
+// WatchStat 单个path的watch状态
+type WatchStat struct {
+	Path        string    `json:"path"`
+	Subscribers int       `json:"subscribers"`
+	Watching    bool      `json:"watching"` // 是否已经在配置中心上建立watch
+	Events      int64     `json:"events"`
+	LastEvent   time.Time `json:"last_event"`
+}
+
+// watcher 同一个path只在配置中心上建立一个watch, 分发给所有订阅者
+type watcher struct {
+	path      string
+	subs      map[chan *store.KVPair]struct{}
+	last      *store.KVPair
+	watching  bool
+	events    int64
+	lastEvent time.Time
+	done      chan struct{}
+}
+
+var (
+	watchMutex   sync.Mutex
+	watchers     = make(map[string]*watcher)
+	storeChanged = make(chan struct{}) // setStore时关闭并重新创建, 通知watcher重新watch
+)
+
+// notifyStoreChanged 连接被替换或者重连以后调用
+func notifyStoreChanged() {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	close(storeChanged)
+	storeChanged = make(chan struct{})
+}
+
+// WatchContext 监听配置变化, 建立时先返回当前值, ctx结束时关闭channel
+// 同一个path共用配置中心上的watch, 配置中心重连以后自动重新watch
+// 订阅者处理不及时只保留最新的值
+/* example
+ctx, cancel := context.WithCancel(context.Background())
+defer cancel()
+ch, err := discovery.WatchContext(ctx, "mq/user/master")
+for kvpair := range ch {
+	reconnect(string(kvpair.Value))
+}
+*/
+func WatchContext(ctx context.Context, path string) (<-chan *store.KVPair, error) {
+	if _, err := getStore(); err != nil {
+		return nil, err
+	}
+	path = fullPath(path)
+	ch := make(chan *store.KVPair, 1)
+	watchMutex.Lock()
+	w, ok := watchers[path]
+	if !ok {
+		w = &watcher{
+			path: path,
+			subs: make(map[chan *store.KVPair]struct{}),
+			done: make(chan struct{}),
+		}
+		watchers[path] = w
+		go w.run()
+	}
+	w.subs[ch] = struct{}{}
+	if w.last != nil {
+		ch <- w.last
+	}
+	watchMutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		watchMutex.Lock()
+		defer watchMutex.Unlock()
+		delete(w.subs, ch)
+		close(ch)
+		if len(w.subs) <= 0 {
+			close(w.done)
+			delete(watchers, path)
+		}
+	}()
+	return ch, nil
+}
+
+// Watch 等同于WatchContext(context.Background(), path), channel不会关闭
+func Watch(path string) (<-chan *store.KVPair, error) {
+	return WatchContext(context.Background(), path)
+}
+
+func (w *watcher) run() {
+	for {
+		watchMutex.Lock()
+		changed := storeChanged
+		watchMutex.Unlock()
+		if s, err := getStore(); err == nil {
+			w.watch(s, changed)
+		}
+		select {
+		case <-w.done:
+			return
+		case <-changed:
+		case <-time.After(watchRetryInterval()):
+		}
+	}
+}
+
+// watch 转发配置中心的变化, channel关闭、连接变化或者没有订阅者时返回
+func (w *watcher) watch(s store.Store, changed chan struct{}) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ch, err := s.Watch(w.path, stopCh)
+	if err != nil {
+		if err != store.ErrKeyNotFound {
+			log.ErrorRaw("[watcher.watch] watch %s failed. err=%s", w.path, err.Error())
+		}
+		return
+	}
+	w.setWatching(true)
+	defer w.setWatching(false)
+	for {
+		select {
+		case kvpair, ok := <-ch:
+			if !ok {
+				return
+			}
+			// 重连以后可能返回空值
+			if kvpair != nil {
+				w.dispatch(kvpair)
+			}
+		case <-changed:
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *watcher) setWatching(watching bool) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	w.watching = watching
+}
+
+// dispatch 重新watch时会再次收到当前值, 与上一次相同时忽略
+// 不同连接的LastIndex没有可比性, 只比较值
+func (w *watcher) dispatch(kvpair *store.KVPair) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	if w.last != nil && string(w.last.Value) == string(kvpair.Value) {
+		return
+	}
+	w.last, w.events, w.lastEvent = kvpair, w.events+1, time.Now()
+	for ch := range w.subs {
+		select {
+		case ch <- kvpair:
+		default:
+			// 丢弃未处理的旧值, 只有这里写channel, 不会阻塞
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- kvpair
+		}
+	}
+}
+
+// WatchStats 当前所有watch的状态, 按path排序
+func WatchStats() []WatchStat {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	stats := make([]WatchStat, 0, len(watchers))
+	for _, w := range watchers {
+		stats = append(stats, WatchStat{
+			Path:        w.path,
+			Subscribers: len(w.subs),
+			Watching:    w.watching,
+			Events:      w.events,
+			LastEvent:   w.lastEvent,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Path < stats[j].Path
+	})
+	return stats
+}
+
+// DebugHandler 返回配置中心状态和watch状态, 包含配置中心地址, 不能暴露到外网
+/* example
+mux.Handle("/debug/discovery", discovery.DebugHandler)
+curl localhost:8085/debug/discovery
+*/
+var DebugHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	stats := WatchStats()
+	bts, _ := json.Marshal(map[string]interface{}{
+		"status":  GetStatus().String(),
+		"active":  len(stats),
+		"watches": stats,
+	})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(bts)
+})
@@ -0,0 +1,60 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/microsvs/libkv/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, ch <-chan *store.KVPair) string {
+	select {
+	case kvpair := <-ch:
+		return string(kvpair.Value)
+	case <-time.After(time.Second):
+		t.Fatal("watch timeout")
+	}
+	return ""
+}
+
+func watchStat(path string) *WatchStat {
+	for _, stat := range WatchStats() {
+		if stat.Path == path {
+			return &stat
+		}
+	}
+	return nil
+}
+
+func TestWatchContext(t *testing.T) {
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ch1, err := WatchContext(ctx1, "/watch/mq/master")
+	assert.Nil(t, err)
+	ch2, err := WatchContext(ctx2, "/watch/mq/master")
+	assert.Nil(t, err)
+
+	// key不存在时等待创建
+	assert.Nil(t, KVWrite("/watch/mq/master", "amqp://127.0.0.1"))
+	assert.Equal(t, "amqp://127.0.0.1", receive(t, ch1))
+	assert.Equal(t, "amqp://127.0.0.1", receive(t, ch2))
+	assert.Equal(t, 2, watchStat("/watch/mq/master").Subscribers)
+
+	cancel1()
+	_, ok := <-ch1
+	assert.False(t, ok)
+	assert.Equal(t, 1, watchStat("/watch/mq/master").Subscribers)
+
+	// 重连以后重新watch
+	assert.Nil(t, Init(Options{Config: "memory://"}))
+	assert.Nil(t, KVWrite("/watch/mq/master", "amqp://127.0.0.2"))
+	assert.Equal(t, "amqp://127.0.0.2", receive(t, ch2))
+
+	cancel2()
+	_, ok = <-ch2
+	assert.False(t, ok)
+	assert.Nil(t, watchStat("/watch/mq/master"))
+}
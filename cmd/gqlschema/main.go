@@ -0,0 +1,70 @@
+// gqlschema 输出服务schema的SDL, 或者与之前的SDL比较, 存在不兼容的变更时返回1
+/* example
+gqlschema -dns 127.0.0.1:8085 > user.graphql
+gqlschema -dns 127.0.0.1:8085 -diff user.graphql
+gqlschema -sdl user.new.graphql -diff user.graphql
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/microsvs/base/pkg/introspection"
+)
+
+func main() {
+	var (
+		dns    = flag.String("dns", "", "服务地址, 通过/graphql接口获取schema, 比如: 127.0.0.1:8085")
+		sdl    = flag.String("sdl", "", "SDL文件路径, 与-dns二选一")
+		diff   = flag.String("diff", "", "旧的SDL文件路径, 设置时输出与当前schema的差异")
+		safe   = flag.Bool("safe", false, "diff时同时输出兼容的变更")
+		schema *introspection.Schema
+		err    error
+	)
+	flag.Parse()
+	if schema, err = load(*dns, *sdl); err != nil {
+		exit(2, "load schema failed. err=%s", err.Error())
+	}
+	if len(*diff) <= 0 {
+		os.Stdout.WriteString(schema.SDL())
+		return
+	}
+	old, err := load("", *diff)
+	if err != nil {
+		exit(2, "load %s failed. err=%s", *diff, err.Error())
+	}
+	changes := introspection.Diff(old, schema)
+	for _, change := range changes {
+		if change.Level != introspection.Safe || *safe {
+			fmt.Println(change)
+		}
+	}
+	if introspection.HasBreaking(changes) {
+		exit(1, "breaking changes found")
+	}
+}
+
+func load(dns, sdl string) (*introspection.Schema, error) {
+	switch {
+	case len(dns) > 0:
+		return introspection.Fetch(context.Background(), dns)
+	case len(sdl) > 0:
+		bts, err := ioutil.ReadFile(sdl)
+		if err != nil {
+			return nil, err
+		}
+		return introspection.FromSDL(string(bts))
+	}
+	flag.Usage()
+	os.Exit(2)
+	return nil, nil
+}
+
+func exit(code int, format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	os.Exit(code)
+}
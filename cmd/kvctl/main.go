@@ -0,0 +1,360 @@
+// kvctl 按照KVRead的路径规则(/APP_NAME/APP_VERSION/APP_ENV/path)查看和修改服务配置
+// 相对路径补全为-service, -version, -env对应的目录, 以/开头时为绝对路径
+/* example
+kvctl -service user -env dev list
+kvctl -service user -env dev get db/user/master
+kvctl -service user -env dev set db/user/master "root@tcp(127.0.0.1)"
+echo -n "127.0.0.1:6379" | kvctl -service user -env dev set cache/redis -
+kvctl -service user -env dev delete db/user/slave
+kvctl -service user -env dev export > user.dev.json
+kvctl -service user -env prod import user.dev.json
+kvctl -service user -env dev export db | kvctl -service user -env prod import - db
+kvctl -service user -env dev diff prod
+kvctl keygen > /etc/user/secret.key
+APP_SECRET_KEY_FILE=/etc/user/secret.key kvctl -service user -env dev set-secret cache/user/master "password@127.0.0.1:6379"
+APP_SECRET_KEY_FILE=/etc/user/secret.key kvctl -service user -env dev rotate // 新密钥放在密钥文件第一行
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/env"
+	"github.com/microsvs/base/pkg/secret"
+	"github.com/microsvs/libkv/store"
+)
+
+var (
+	zk      = flag.String("zk", "", "配置中心地址, 默认使用环境变量APP_ZK")
+	service = flag.String("service", envOr(env.ServiceName), "服务名称")
+	version = flag.String("version", envOr(env.ServiceVer), "服务版本")
+	stage   = flag.String("env", envOr(env.ServiceENV), "服务环境")
+	show    = flag.Bool("reveal", false, "get时输出secret解密以后的值")
+)
+
+func envOr(key env.ENV_NAME) string {
+	value, _ := env.Get(key)
+	return value
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: kvctl [flags] command [args]
+
+commands:
+  list [path]            递归列出配置
+  get <path>             读取配置, secret输出******, 除非指定-reveal
+  set <path> <value|->   写入配置, value为-时从标准输入读取
+  delete <path>          删除配置
+  export [path]          以JSON导出配置, key为相对路径
+  import [file|-] [path] 导入export的JSON, 默认从标准输入读取, key为相对于path的路径
+  diff <env> [path]      比较当前环境与另一个环境的配置, 存在差异时返回1
+  set-secret <path> <value|->  加密以后写入配置
+  rotate [path]          用当前主密钥重新加密目录下的secret
+  keygen                 生成随机主密钥
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) <= 0 {
+		usage()
+		os.Exit(2)
+	}
+	if args[0] == "keygen" {
+		key, err := secret.GenerateKey()
+		if err != nil {
+			exit(1, "keygen failed. err=%s", err.Error())
+		}
+		fmt.Println(key)
+		return
+	}
+	if err := discovery.Init(discovery.Options{Config: *zk}); err != nil {
+		exit(2, "init discovery failed. err=%s", err.Error())
+	}
+	var err error
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "list":
+		err = list(arg(args, 0, ""))
+	case "get":
+		err = get(need(args, 1)[0])
+	case "set":
+		args = need(args, 2)
+		err = set(args[0], args[1])
+	case "set-secret":
+		args = need(args, 2)
+		err = setSecret(args[0], args[1])
+	case "rotate":
+		err = rotate(arg(args, 0, ""))
+	case "delete":
+		err = discovery.KVDelete(abs(need(args, 1)[0]))
+	case "export":
+		err = export(arg(args, 0, ""))
+	case "import":
+		err = load(arg(args, 0, "-"), arg(args, 1, ""))
+	case "diff":
+		err = diff(need(args, 1)[0], arg(args, 1, ""))
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		exit(1, "%s failed. err=%s", args[0], err.Error())
+	}
+}
+
+func arg(args []string, idx int, def string) string {
+	if idx < len(args) {
+		return args[idx]
+	}
+	return def
+}
+
+func need(args []string, n int) []string {
+	if len(args) < n {
+		usage()
+		os.Exit(2)
+	}
+	return args
+}
+
+// abs 相对路径补全为当前服务环境的绝对路径
+func abs(path string) string {
+	return absIn(*stage, path)
+}
+
+// join 拼接目录和相对于目录的key
+func join(dir, key string) string {
+	if len(dir) <= 0 {
+		return key
+	}
+	return strings.TrimRight(dir, "/") + "/" + strings.TrimLeft(key, "/")
+}
+
+func absIn(stage, path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	return discovery.ServicePath(*service, *version, stage, path)
+}
+
+// tree 读取目录下的所有配置, key为相对于目录的路径
+func tree(dir string) (map[string]string, error) {
+	kvpairs, err := discovery.KVTree(dir)
+	if err == store.ErrKeyNotFound {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(kvpairs))
+	for _, kvpair := range kvpairs {
+		key := strings.TrimPrefix("/"+strings.Trim(kvpair.Key, "/"), dir)
+		values[strings.TrimLeft(key, "/")] = string(kvpair.Value)
+	}
+	return values, nil
+}
+
+func sortedKeys(values ...map[string]string) []string {
+	var (
+		keys []string
+		seen = make(map[string]bool)
+	)
+	for _, m := range values {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func list(path string) error {
+	dir := abs(path)
+	values, err := tree(dir)
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(values) {
+		fmt.Printf("%s/%s\t%s\n", dir, key, values[key])
+	}
+	return nil
+}
+
+func get(path string) error {
+	value, err := discovery.KVGet(abs(path))
+	if err != nil {
+		return err
+	}
+	if secret.IsSecret(value) && !*show {
+		value = maskSecret(value)
+	} else if value, err = secret.Decrypt(value); err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+func stdin(value string) (string, error) {
+	if value != "-" {
+		return value, nil
+	}
+	bts, err := ioutil.ReadAll(os.Stdin)
+	return string(bts), err
+}
+
+func set(path, value string) error {
+	value, err := stdin(value)
+	if err != nil {
+		return err
+	}
+	return discovery.KVWrite(abs(path), value)
+}
+
+func setSecret(path, value string) error {
+	value, err := stdin(value)
+	if err != nil {
+		return err
+	}
+	return discovery.KVWriteSecret(abs(path), value)
+}
+
+// rotate 旧主密钥加密的secret用当前主密钥重新加密, 需要同时提供新旧主密钥
+func rotate(path string) error {
+	keyring, err := secret.Default()
+	if err != nil {
+		return err
+	}
+	dir := abs(path)
+	values, err := tree(dir)
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(values) {
+		value, changed, err := keyring.Rotate(values[key])
+		if err != nil {
+			return fmt.Errorf("rotate %s: %s", key, err.Error())
+		}
+		if !changed {
+			continue
+		}
+		if err = discovery.KVWrite(dir+"/"+key, value); err != nil {
+			return fmt.Errorf("write %s: %s", key, err.Error())
+		}
+		fmt.Printf("rotated %s/%s\n", dir, key)
+	}
+	return nil
+}
+
+func export(path string) error {
+	values, err := tree(abs(path))
+	if err != nil {
+		return err
+	}
+	bts, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bts))
+	return nil
+}
+
+// load 导入export的JSON, key为相对于path的路径, 与export一致, 已有但JSON中没有的配置保持不变
+func load(file, path string) error {
+	var (
+		bts    []byte
+		err    error
+		values map[string]string
+	)
+	if file == "-" {
+		bts, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		bts, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(bts, &values); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(values) {
+		full := abs(join(path, key))
+		if err = discovery.KVWrite(full, values[key]); err != nil {
+			return fmt.Errorf("write %s: %s", full, err.Error())
+		}
+		fmt.Printf("set %s\n", full)
+	}
+	return nil
+}
+
+// diff 输出: - 只在当前环境, + 只在另一个环境, ~ 两个环境的值不同
+func diff(other, path string) error {
+	cur, err := tree(absIn(*stage, path))
+	if err != nil {
+		return err
+	}
+	that, err := tree(absIn(other, path))
+	if err != nil {
+		return err
+	}
+	changed := 0
+	for _, key := range sortedKeys(cur, that) {
+		a, aok := cur[key]
+		b, bok := that[key]
+		switch {
+		case !bok:
+			fmt.Printf("- %s\t%s\n", key, maskSecret(a))
+		case !aok:
+			fmt.Printf("+ %s\t%s\n", key, maskSecret(b))
+		case !sameValue(a, b):
+			fmt.Printf("~ %s\t%s -> %s\n", key, maskSecret(a), maskSecret(b))
+		default:
+			continue
+		}
+		changed++
+	}
+	if changed > 0 {
+		exit(1, "%d differences between %s and %s", changed, *stage, other)
+	}
+	return nil
+}
+
+// sameValue secret每次加密的结果不同, 比较解密以后的值, 解密失败时认为不同
+func sameValue(a, b string) bool {
+	if !secret.IsSecret(a) && !secret.IsSecret(b) {
+		return a == b
+	}
+	pa, err := secret.Decrypt(a)
+	if err != nil {
+		return false
+	}
+	pb, err := secret.Decrypt(b)
+	return err == nil && pa == pb
+}
+
+// maskSecret 只用于输出, secret输出******
+func maskSecret(value string) string {
+	if secret.IsSecret(value) {
+		return "******"
+	}
+	return value
+}
+
+func exit(code int, format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	os.Exit(code)
+}
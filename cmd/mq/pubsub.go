@@ -0,0 +1,92 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/pubsub"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/segmentio/ksuid"
+	"github.com/streadway/amqp"
+)
+
+// rabbitPubSub 基于topic exchange的发布订阅, routing key为topic
+// 每个订阅使用独立的临时队列, 多实例部署时所有实例都能收到消息
+type rabbitPubSub struct {
+	service  rpc.FGService
+	exchange string
+}
+
+//NewPubSub 返回RabbitMQ实现的pubsub.PubSub, 需要先调用InitMQ
+/* example
+mq.InitMQ(rpc.FGSGateway)
+ps, err := mq.NewPubSub(rpc.FGSGateway, "graphql.subscriptions")
+*/
+func NewPubSub(service rpc.FGService, exchange string) (pubsub.PubSub, error) {
+	cs, ok := channelmap[service]
+	if !ok || cs.channel == nil {
+		return nil, fmt.Errorf("mq channel of %s not initialized, call InitMQ first", service)
+	}
+	cs.Mutex.Lock()
+	defer cs.Mutex.Unlock()
+	if err := cs.channel.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		log.ErrorRaw("[NewPubSub] declare exchange %s failed. err=%s", exchange, err.Error())
+		return nil, err
+	}
+	return &rabbitPubSub{service: service, exchange: exchange}, nil
+}
+
+func (ps *rabbitPubSub) Publish(topic string, payload []byte) error {
+	cs := channelmap[ps.service]
+	cs.Mutex.Lock()
+	defer cs.Mutex.Unlock()
+	return cs.channel.Publish(ps.exchange, topic, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        payload,
+	})
+}
+
+func (ps *rabbitPubSub) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	var (
+		cs         = channelmap[ps.service]
+		consumer   = fmt.Sprintf("%s-%s", ps.service, ksuid.New().String())
+		deliveries <-chan amqp.Delivery
+		err        error
+	)
+	cs.Mutex.Lock()
+	q, err := cs.channel.QueueDeclare("", false, true, true, false, nil)
+	if err == nil {
+		err = cs.channel.QueueBind(q.Name, topic, ps.exchange, false, nil)
+	}
+	if err == nil {
+		deliveries, err = cs.channel.Consume(q.Name, consumer, true, true, false, false, nil)
+	}
+	cs.Mutex.Unlock()
+	if err != nil {
+		log.ErrorRaw("[Subscribe] subscribe %s on %s failed. err=%s", topic, ps.exchange, err.Error())
+		return nil, err
+	}
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				cs.Mutex.Lock()
+				cs.channel.Cancel(consumer, false)
+				cs.Mutex.Unlock()
+				return
+			case d, ok := <-deliveries:
+				if !ok {
+					return
+				}
+				select {
+				case ch <- d.Body:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,73 @@
+// rpcgen 根据服务的schema生成类型化的rpc客户端
+/* example
+rpcgen -dns 127.0.0.1:8085 -service user -package userrpc -o userrpc/client.go
+rpcgen -sdl user.graphql -service user -package userrpc -o userrpc/client.go
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/microsvs/base/pkg/introspection"
+	"github.com/microsvs/base/pkg/rpcgen"
+)
+
+func main() {
+	var (
+		dns     = flag.String("dns", "", "服务地址, 通过/graphql接口获取schema, 比如: 127.0.0.1:8085")
+		sdl     = flag.String("sdl", "", "SDL文件路径, 与-dns二选一")
+		pkg     = flag.String("package", "", "生成代码的package名称")
+		service = flag.String("service", "", "服务名称")
+		output  = flag.String("o", "", "输出文件路径, 默认输出到标准输出")
+		depth   = flag.Int("depth", 3, "返回对象的最大嵌套深度")
+		schema  *introspection.Schema
+		code    []byte
+		err     error
+	)
+	flag.Parse()
+	switch {
+	case len(*dns) > 0:
+		schema, err = introspection.Fetch(context.Background(), *dns)
+	case len(*sdl) > 0:
+		var bts []byte
+		if bts, err = ioutil.ReadFile(*sdl); err == nil {
+			schema, err = introspection.FromSDL(string(bts))
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		exit("load schema failed. err=%s", err.Error())
+	}
+	if len(*service) <= 0 {
+		*service = *pkg
+	}
+	if code, err = rpcgen.Generate(schema, rpcgen.Options{
+		Package:  *pkg,
+		Service:  *service,
+		MaxDepth: *depth,
+	}); err != nil {
+		exit("generate code failed. err=%s", err.Error())
+	}
+	if len(*output) <= 0 {
+		os.Stdout.Write(code)
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(*output), os.ModePerm); err != nil {
+		exit("create output dir failed. err=%s", err.Error())
+	}
+	if err = ioutil.WriteFile(*output, code, 0644); err != nil {
+		exit("write %s failed. err=%s", *output, err.Error())
+	}
+}
+
+func exit(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	os.Exit(1)
+}
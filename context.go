@@ -11,9 +11,13 @@ import (
 
 // external http context
 func buildContext(r *http.Request) (context.Context, error) {
+	return buildContextWithToken(r, getTokenFromRequest(r))
+}
+
+// buildContextWithToken 使用指定的token构建context, websocket连接的token来自connection_init
+func buildContextWithToken(r *http.Request, token string) (context.Context, error) {
 	var (
 		ctx      context.Context = context.Background()
-		token    string
 		user     *types.User
 		retToken *types.Token
 		err      error
@@ -21,8 +25,18 @@ func buildContext(r *http.Request) (context.Context, error) {
 	ctx = context.WithValue(ctx, rpc.KeyRawRequest, r)
 	ctx = context.WithValue(ctx, rpc.KeyTraceID, getTraceIdFromRequest(r))
 	ctx = context.WithValue(ctx, rpc.KeyRPCID, getRPCIdFromRequest(r))
+	// 客户端属性, 只有appid经过签名校验
+	if appid, ok := r.Context().Value(verifiedAppIDKey{}).(string); ok && len(appid) > 0 {
+		ctx = context.WithValue(ctx, rpc.KeyAppID, appid)
+	}
+	if version := r.Header.Get("X-App-Version"); len(version) > 0 {
+		ctx = context.WithValue(ctx, rpc.KeyAppVersion, version)
+	}
+	if device := r.Header.Get("X-Device"); len(device) > 0 {
+		ctx = context.WithValue(ctx, rpc.KeyDevice, device)
+	}
 	// token
-	if token = getTokenFromRequest(r); len(token) > 0 {
+	if len(token) > 0 {
 		if retToken, err = rpc.GetUserIdFromTokenRPC(ctx, Service2Url(rpc.FGSToken), token); err != nil {
 			return nil, err
 		}
@@ -34,6 +48,9 @@ func buildContext(r *http.Request) (context.Context, error) {
 	return ctx, nil
 }
 
+// verifiedAppIDKey FilterSignHandler校验通过以后把appid写入请求的context, 客户端无法伪造
+type verifiedAppIDKey struct{}
+
 func getTraceIdFromRequest(r *http.Request) string {
 	var traceid string
 	// first: url params from nonce
@@ -0,0 +1,28 @@
+package base
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// 只有签名校验通过的appid写入context, url参数和header中的appid不会使用
+func TestBuildContextAttributes(t *testing.T) {
+	r := httptest.NewRequest("POST", "/graphql?appid=ops", nil)
+	r.Header.Set("X-App-ID", "ops")
+	r.Header.Set("X-App-Version", "2.4.1")
+	r.Header.Set("X-Device", "d1")
+	ctx, err := buildContext(r)
+	assert.Nil(t, err)
+	assert.Nil(t, ctx.Value(rpc.KeyAppID))
+	assert.Equal(t, "2.4.1", ctx.Value(rpc.KeyAppVersion))
+	assert.Equal(t, "d1", ctx.Value(rpc.KeyDevice))
+
+	r = r.WithContext(context.WithValue(r.Context(), verifiedAppIDKey{}, "ios"))
+	ctx, err = buildContext(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "ios", ctx.Value(rpc.KeyAppID))
+}
@@ -0,0 +1,146 @@
+package base
+
+import (
+	"github.com/graphql-go/graphql"
+)
+
+// DeriveOption 修改派生类型的字段, 只作用于字段的副本
+type DeriveOption func(fields graphql.Fields)
+
+// DeriveGLObject 从已有的对象类型派生一个新的类型, 原类型不受影响
+// 派生类型与原类型同名时不能在同一个schema中同时使用, 需要使用新的名称
+/* example
+var GLPublicUser = base.DeriveGLObject(types.GLUser, "PublicUser",
+	base.Omit("mobile", "permissions"),
+	base.Rename("nickname", "name"),
+	base.NonNull("id"),
+)
+*/
+func DeriveGLObject(obj *graphql.Object, name string, opts ...DeriveOption) *graphql.Object {
+	fields := copyGLFields(obj.Fields())
+	for _, opt := range opts {
+		opt(fields)
+	}
+	var interfaces []*graphql.Interface
+	for _, iface := range obj.Interfaces() {
+		if hasGLFields(fields, iface.Fields()) {
+			interfaces = append(interfaces, iface)
+		}
+	}
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        name,
+		Interfaces:  interfaces,
+		Fields:      fields,
+		IsTypeOf:    obj.IsTypeOf,
+		Description: obj.Description(),
+	})
+}
+
+// Omit 去掉指定的字段
+func Omit(names ...string) DeriveOption {
+	return func(fields graphql.Fields) {
+		for _, name := range names {
+			delete(fields, name)
+		}
+	}
+}
+
+// Pick 只保留指定的字段
+func Pick(names ...string) DeriveOption {
+	return func(fields graphql.Fields) {
+		keep := make(map[string]bool, len(names))
+		for _, name := range names {
+			keep[name] = true
+		}
+		for name := range fields {
+			if !keep[name] {
+				delete(fields, name)
+			}
+		}
+	}
+}
+
+// Rename 字段改名, 仍然按照原来的字段名从source中读取数据
+func Rename(from, to string) DeriveOption {
+	return func(fields graphql.Fields) {
+		field, ok := fields[from]
+		if !ok {
+			return
+		}
+		delete(fields, from)
+		next := field.Resolve
+		if next == nil {
+			next = graphql.DefaultResolveFn
+		}
+		field.Name = to
+		field.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+			p.Info.FieldName = from
+			return next(p)
+		}
+		fields[to] = field
+	}
+}
+
+// Nullable 去掉字段的非空限制
+func Nullable(names ...string) DeriveOption {
+	return func(fields graphql.Fields) {
+		for _, name := range names {
+			if field, ok := fields[name]; ok {
+				if nonNull, ok := field.Type.(*graphql.NonNull); ok {
+					field.Type = nonNull.OfType
+				}
+			}
+		}
+	}
+}
+
+// NonNull 字段设置为非空
+func NonNull(names ...string) DeriveOption {
+	return func(fields graphql.Fields) {
+		for _, name := range names {
+			if field, ok := fields[name]; ok {
+				if _, ok := field.Type.(*graphql.NonNull); !ok {
+					field.Type = graphql.NewNonNull(field.Type)
+				}
+			}
+		}
+	}
+}
+
+func copyGLFields(defs graphql.FieldDefinitionMap) graphql.Fields {
+	fields := make(graphql.Fields, len(defs))
+	for name, def := range defs {
+		var args graphql.FieldConfigArgument
+		if len(def.Args) > 0 {
+			args = make(graphql.FieldConfigArgument, len(def.Args))
+			for _, arg := range def.Args {
+				args[arg.Name()] = &graphql.ArgumentConfig{
+					Type:         arg.Type,
+					DefaultValue: arg.DefaultValue,
+					Description:  arg.Description(),
+				}
+			}
+		}
+		// 原类型可能已经安装到Daemon, 使用包装(鉴权, 脱敏等)之前的resolver
+		resolve, subscribe := unwrappedResolvers(def)
+		fields[name] = &graphql.Field{
+			Name:              def.Name,
+			Type:              def.Type,
+			Args:              args,
+			Resolve:           resolve,
+			Subscribe:         subscribe,
+			DeprecationReason: def.DeprecationReason,
+			Description:       def.Description,
+		}
+	}
+	return fields
+}
+
+func hasGLFields(fields graphql.Fields, defs graphql.FieldDefinitionMap) bool {
+	for name := range defs {
+		if _, ok := fields[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveGLObject(t *testing.T) {
+	obj := DeriveGLObject(types.GLUser, "PublicUser",
+		Omit("mobile", "permissions"),
+		Rename("nickname", "name"),
+		NonNull("id"),
+	)
+	// 原类型不受影响
+	assert.Contains(t, types.GLUser.Fields(), "mobile")
+	assert.Contains(t, types.GLUser.Fields(), "nickname")
+	assert.NotContains(t, obj.Fields(), "mobile")
+	assert.NotContains(t, obj.Fields(), "nickname")
+	assert.IsType(t, &graphql.NonNull{}, obj.Fields()["id"].Type)
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"user": &graphql.Field{
+					Type: obj,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return &types.User{ID: "1001", Name: "xhj", Mobile: "13812345678"}, nil
+					},
+				},
+				"raw": &graphql.Field{Type: DeriveGLObject(types.GLToken, "RawToken", Omit("token"))},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ user { id name } }"})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"user": map[string]interface{}{"id": "1001", "name": "xhj"}}, result.Data)
+
+	assert.Contains(t, types.GLToken.Fields(), "token")
+	fields := graphql.Fields{"id": {}, "name": {}}
+	Pick("id")(fields)
+	assert.NotContains(t, fields, "name")
+
+	// HideGLFields返回同名的派生类型, 共用的类型不受影响
+	hidden := HideGLFields(types.GLUser, "mobile")
+	assert.Equal(t, types.GLUser.Name(), hidden.Name())
+	assert.NotContains(t, hidden.Fields(), "mobile")
+	assert.Contains(t, types.GLUser.Fields(), "mobile")
+}
+
+// 原类型安装到Daemon以后, 派生类型不继承鉴权等包装
+func TestDeriveInstalledGLObject(t *testing.T) {
+	company := graphql.NewObject(graphql.ObjectConfig{
+		Name: "DeriveCompany",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return "xhj", nil
+				},
+			},
+		},
+	})
+	newSchema := func(typ *graphql.Object) graphql.Schema {
+		schema, err := graphql.NewSchema(graphql.SchemaConfig{
+			Query: graphql.NewObject(graphql.ObjectConfig{
+				Name: "Query",
+				Fields: graphql.Fields{
+					"company": &graphql.Field{
+						Type: typ,
+						Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+							return struct{}{}, nil
+						},
+					},
+				},
+			}),
+		})
+		assert.Nil(t, err)
+		return schema
+	}
+	schema := newSchema(company)
+	d := &Daemon{schema: &schema}
+	d.installResolvers(&schema)
+	d.Authorize("DeriveCompany.name", AuthRule{Roles: []string{"admin"}})
+	result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ company { name } }"})
+	assert.NotEmpty(t, result.Errors)
+
+	derived := newSchema(DeriveGLObject(company, "PublicCompany"))
+	result = graphql.Do(graphql.Params{Schema: derived, RequestString: "{ company { name } }"})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"company": map[string]interface{}{"name": "xhj"}}, result.Data)
+}
@@ -0,0 +1,473 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/introspection"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/rpc"
+)
+
+// watch失败(比如下游服务还未发布schema)以后的重试间隔
+var gatewayWatchRetry = 30 * time.Second
+
+// SchemaConflicts 合并下游服务schema时发现的冲突
+type SchemaConflicts []string
+
+func (c SchemaConflicts) Error() string {
+	return "schema conflicts: " + strings.Join(c, "; ")
+}
+
+// gateway 合并多个下游服务的schema, 根字段自动转发到提供该字段的服务
+type gateway struct {
+	mutex    sync.Mutex
+	daemon   *Daemon
+	services []rpc.FGService
+	hashes   map[rpc.FGService]string
+}
+
+// NewGatewayDaemon 创建gateway模式的Daemon
+// 启动时通过introspection获取并合并下游服务的schema, 类型冲突时返回SchemaConflicts
+// 下游服务schema发生变化时自动更新合并后的schema
+/* example
+d, err := base.NewGatewayDaemon(rpc.FGSGateway, rpc.FGSToken, rpc.FGSUser, rpc.FGSAddress)
+*/
+func NewGatewayDaemon(service rpc.FGService, services ...rpc.FGService) (*Daemon, error) {
+	var (
+		gw = &gateway{
+			services: services,
+			hashes:   make(map[rpc.FGService]string),
+		}
+		schema *graphql.Schema
+		d      *Daemon
+		err    error
+	)
+	if schema, err = gw.build(); err != nil {
+		log.ErrorRaw("[NewGatewayDaemon] build gateway schema failed. err=%s", err.Error())
+		return nil, err
+	}
+	if d, err = NewGLDaemon(service, schema); err != nil {
+		return nil, err
+	}
+	gw.daemon = d
+	for _, svc := range services {
+		go gw.watch(svc)
+	}
+	return d, nil
+}
+
+func (gw *gateway) build() (*graphql.Schema, error) {
+	var (
+		schemas = make(map[rpc.FGService]*introspection.Schema)
+		hashes  = make(map[rpc.FGService]string)
+		s       *introspection.Schema
+		err     error
+	)
+	for _, svc := range gw.services {
+		if s, err = introspection.Fetch(context.Background(), Service2Url(svc)); err != nil {
+			return nil, fmt.Errorf("fetch schema of %s failed. err=%s", svc, err.Error())
+		}
+		hashes[svc] = s.Hash()
+		schemas[svc] = s
+	}
+	merged, owners, err := mergeSchemas(gw.services, schemas)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := newStitcher(merged, owners).build()
+	if err != nil {
+		return nil, err
+	}
+	gw.hashes = hashes
+	return schema, nil
+}
+
+// watch 下游服务启动时发布schema摘要(/schema/<env>/<service>/hash), 摘要变化时重新合并schema
+func (gw *gateway) watch(service rpc.FGService) {
+	for {
+		ch, err := discovery.Watch(schemaKey(service, "hash"))
+		if err != nil {
+			time.Sleep(gatewayWatchRetry)
+			continue
+		}
+		for kvpair := range ch {
+			if kvpair == nil {
+				break
+			}
+			gw.refresh(service, string(kvpair.Value))
+		}
+		time.Sleep(gatewayWatchRetry)
+	}
+}
+
+func (gw *gateway) refresh(service rpc.FGService, hash string) {
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+	if gw.hashes[service] == hash {
+		return
+	}
+	schema, err := gw.build()
+	if err != nil {
+		log.ErrorRaw("[gateway] refresh schema after %s changed failed, keep the old one. err=%s",
+			service, err.Error())
+		return
+	}
+	if err = gw.daemon.UpdateSchema(schema); err != nil {
+		log.ErrorRaw("[gateway] update schema failed. err=%s", err.Error())
+		return
+	}
+	log.InfoRaw("[gateway] schema refreshed after %s changed", service)
+}
+
+// mergeSchemas 合并下游服务的schema
+// 同名类型的定义必须一致, 同一个根字段只能由一个服务提供
+func mergeSchemas(
+	services []rpc.FGService,
+	schemas map[rpc.FGService]*introspection.Schema,
+) (*introspection.Schema, map[string]rpc.FGService, error) {
+	var (
+		merged = &introspection.Schema{
+			QueryType:    &introspection.TypeName{Name: "Query"},
+			MutationType: &introspection.TypeName{Name: "Mutation"},
+		}
+		query     = &introspection.Type{Kind: introspection.KindObject, Name: "Query"}
+		mutation  = &introspection.Type{Kind: introspection.KindObject, Name: "Mutation"}
+		owners    = make(map[string]rpc.FGService)
+		typeOwner = make(map[string]rpc.FGService)
+		types     = make(map[string]*introspection.Type)
+		conflicts SchemaConflicts
+	)
+	mergeRoot := func(svc rpc.FGService, root *introspection.Type, target *introspection.Type) {
+		for _, field := range root.Fields {
+			key := rootFieldKey(target.Name, field.Name)
+			if owner, ok := owners[key]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("field %s is provided by both %s and %s", key, owner, svc))
+				continue
+			}
+			owners[key] = svc
+			target.Fields = append(target.Fields, field)
+		}
+	}
+	for _, svc := range services {
+		s := schemas[svc]
+		for _, typ := range s.Types {
+			if introspection.IsBuiltin(typ.Name) {
+				continue
+			}
+			switch {
+			case s.QueryType != nil && typ.Name == s.QueryType.Name:
+				mergeRoot(svc, typ, query)
+				continue
+			case s.MutationType != nil && typ.Name == s.MutationType.Name:
+				mergeRoot(svc, typ, mutation)
+				continue
+			case s.SubscriptionType != nil && typ.Name == s.SubscriptionType.Name:
+				continue
+			}
+			if exist, ok := types[typ.Name]; ok {
+				if typeSignature(exist) != typeSignature(typ) {
+					conflicts = append(conflicts, fmt.Sprintf("type %s is defined differently by %s and %s",
+						typ.Name, typeOwner[typ.Name], svc))
+				}
+				continue
+			}
+			types[typ.Name] = typ
+			typeOwner[typ.Name] = svc
+			merged.Types = append(merged.Types, typ)
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, nil, conflicts
+	}
+	merged.Types = append(merged.Types, query)
+	if len(mutation.Fields) > 0 {
+		merged.Types = append(merged.Types, mutation)
+	} else {
+		merged.MutationType = nil
+	}
+	return merged, owners, nil
+}
+
+func rootFieldKey(root string, field string) string {
+	return root + "." + field
+}
+
+// typeSignature 类型结构的描述, 忽略description
+func typeSignature(typ *introspection.Type) string {
+	var items []string
+	for _, field := range typ.Fields {
+		var args []string
+		for _, arg := range field.Args {
+			args = append(args, arg.Name+":"+arg.Type.String())
+		}
+		sort.Strings(args)
+		items = append(items, fmt.Sprintf("%s(%s):%s", field.Name, strings.Join(args, ","), field.Type.String()))
+	}
+	for _, field := range typ.InputFields {
+		items = append(items, field.Name+":"+field.Type.String())
+	}
+	for _, value := range typ.EnumValues {
+		items = append(items, value.Name)
+	}
+	for _, ref := range typ.Interfaces {
+		items = append(items, "implements "+ref.Name)
+	}
+	for _, ref := range typ.PossibleTypes {
+		items = append(items, "member "+ref.Name)
+	}
+	sort.Strings(items)
+	return typ.Kind + " " + strings.Join(items, " ")
+}
+
+// stitcher 根据合并以后的schema描述创建可执行的graphql.Schema
+type stitcher struct {
+	schema *introspection.Schema
+	owners map[string]rpc.FGService
+	types  map[string]graphql.Type
+}
+
+func newStitcher(schema *introspection.Schema, owners map[string]rpc.FGService) *stitcher {
+	return &stitcher{
+		schema: schema,
+		owners: owners,
+		types: map[string]graphql.Type{
+			"String":  graphql.String,
+			"Int":     graphql.Int,
+			"Float":   graphql.Float,
+			"Boolean": graphql.Boolean,
+			"ID":      graphql.ID,
+		},
+	}
+}
+
+func (st *stitcher) build() (*graphql.Schema, error) {
+	var (
+		config graphql.SchemaConfig
+		types  []graphql.Type
+	)
+	for _, typ := range st.schema.Types {
+		if typ.Name == st.schema.QueryType.Name ||
+			(st.schema.MutationType != nil && typ.Name == st.schema.MutationType.Name) {
+			continue
+		}
+		types = append(types, st.namedType(typ.Name))
+	}
+	config.Types = types
+	config.Query = st.root(st.schema.Type(st.schema.QueryType.Name))
+	if st.schema.MutationType != nil {
+		config.Mutation = st.root(st.schema.Type(st.schema.MutationType.Name))
+	}
+	schema, err := graphql.NewSchema(config)
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// root 根字段转发到提供该字段的服务
+func (st *stitcher) root(typ *introspection.Type) *graphql.Object {
+	fields := graphql.Fields{}
+	for _, field := range typ.Fields {
+		owner := st.owners[rootFieldKey(typ.Name, field.Name)]
+		def := st.field(field)
+		def.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+			return RedirectRequest(p, owner)
+		}
+		fields[field.Name] = def
+	}
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:   typ.Name,
+		Fields: fields,
+	})
+}
+
+func (st *stitcher) field(field *introspection.Field) *graphql.Field {
+	args := graphql.FieldConfigArgument{}
+	for _, arg := range field.Args {
+		args[arg.Name] = &graphql.ArgumentConfig{
+			Type:        st.inputType(arg.Type),
+			Description: arg.Description,
+		}
+	}
+	return &graphql.Field{
+		Type:              st.outputType(field.Type),
+		Args:              args,
+		Description:       field.Description,
+		DeprecationReason: field.DeprecationReason,
+	}
+}
+
+func (st *stitcher) outputType(ref *introspection.TypeRef) graphql.Output {
+	switch ref.Kind {
+	case introspection.KindNonNull:
+		return graphql.NewNonNull(st.outputType(ref.OfType))
+	case introspection.KindList:
+		return graphql.NewList(st.outputType(ref.OfType))
+	}
+	return st.namedType(ref.Name)
+}
+
+func (st *stitcher) inputType(ref *introspection.TypeRef) graphql.Input {
+	switch ref.Kind {
+	case introspection.KindNonNull:
+		return graphql.NewNonNull(st.inputType(ref.OfType))
+	case introspection.KindList:
+		return graphql.NewList(st.inputType(ref.OfType))
+	}
+	return st.namedType(ref.Name)
+}
+
+// namedType 按需创建类型, 对象字段使用thunk以支持类型间的循环引用
+func (st *stitcher) namedType(name string) graphql.Type {
+	if typ, ok := st.types[name]; ok {
+		return typ
+	}
+	def := st.schema.Type(name)
+	if def == nil {
+		return nil
+	}
+	switch def.Kind {
+	case introspection.KindScalar:
+		st.types[name] = passThroughScalar(def)
+	case introspection.KindEnum:
+		values := graphql.EnumValueConfigMap{}
+		for _, value := range def.EnumValues {
+			values[value.Name] = &graphql.EnumValueConfig{
+				Value:             value.Name,
+				Description:       value.Description,
+				DeprecationReason: value.DeprecationReason,
+			}
+		}
+		st.types[name] = graphql.NewEnum(graphql.EnumConfig{
+			Name:        name,
+			Description: def.Description,
+			Values:      values,
+		})
+	case introspection.KindObject:
+		st.types[name] = graphql.NewObject(graphql.ObjectConfig{
+			Name:        name,
+			Description: def.Description,
+			Fields:      graphql.FieldsThunk(func() graphql.Fields { return st.fields(def) }),
+			Interfaces: graphql.InterfacesThunk(func() []*graphql.Interface {
+				var ifaces []*graphql.Interface
+				for _, ref := range def.Interfaces {
+					if iface, ok := st.namedType(ref.Name).(*graphql.Interface); ok {
+						ifaces = append(ifaces, iface)
+					}
+				}
+				return ifaces
+			}),
+		})
+	case introspection.KindInterface:
+		st.types[name] = graphql.NewInterface(graphql.InterfaceConfig{
+			Name:        name,
+			Description: def.Description,
+			Fields:      graphql.FieldsThunk(func() graphql.Fields { return st.fields(def) }),
+			ResolveType: st.resolveType,
+		})
+	case introspection.KindUnion:
+		st.types[name] = graphql.NewUnion(graphql.UnionConfig{
+			Name:        name,
+			Description: def.Description,
+			Types: graphql.UnionTypesThunk(func() []*graphql.Object {
+				var objs []*graphql.Object
+				for _, ref := range def.PossibleTypes {
+					if obj, ok := st.namedType(ref.Name).(*graphql.Object); ok {
+						objs = append(objs, obj)
+					}
+				}
+				return objs
+			}),
+			ResolveType: st.resolveType,
+		})
+	case introspection.KindInputObject:
+		st.types[name] = graphql.NewInputObject(graphql.InputObjectConfig{
+			Name:        name,
+			Description: def.Description,
+			Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+				fields := graphql.InputObjectConfigFieldMap{}
+				for _, field := range def.InputFields {
+					fields[field.Name] = &graphql.InputObjectFieldConfig{
+						Type:        st.inputType(field.Type),
+						Description: field.Description,
+					}
+				}
+				return fields
+			}),
+		})
+	}
+	return st.types[name]
+}
+
+func (st *stitcher) fields(def *introspection.Type) graphql.Fields {
+	fields := graphql.Fields{}
+	for _, field := range def.Fields {
+		fields[field.Name] = st.field(field)
+	}
+	return fields
+}
+
+// resolveType 转发结果中包含__typename, 根据它确定具体类型
+func (st *stitcher) resolveType(p graphql.ResolveTypeParams) *graphql.Object {
+	var name string
+	if value, ok := RedirectResultMap(p.Value); ok {
+		name, _ = value["__typename"].(string)
+	}
+	obj, _ := st.types[name].(*graphql.Object)
+	return obj
+}
+
+// passThroughScalar 下游服务自定义的标量(比如DateTime)由下游服务负责序列化, gateway原样透传
+func passThroughScalar(def *introspection.Type) *graphql.Scalar {
+	identity := func(value interface{}) interface{} {
+		return value
+	}
+	return graphql.NewScalar(graphql.ScalarConfig{
+		Name:         def.Name,
+		Description:  def.Description,
+		Serialize:    identity,
+		ParseValue:   identity,
+		ParseLiteral: valueFromAST,
+	})
+}
+
+func valueFromAST(v ast.Value) interface{} {
+	switch val := v.(type) {
+	case *ast.StringValue:
+		return val.Value
+	case *ast.BooleanValue:
+		return val.Value
+	case *ast.EnumValue:
+		return val.Value
+	case *ast.IntValue:
+		if i, err := strconv.ParseInt(val.Value, 10, 64); err == nil {
+			return i
+		}
+	case *ast.FloatValue:
+		if f, err := strconv.ParseFloat(val.Value, 64); err == nil {
+			return f
+		}
+	case *ast.ListValue:
+		items := make([]interface{}, 0, len(val.Values))
+		for _, item := range val.Values {
+			items = append(items, valueFromAST(item))
+		}
+		return items
+	case *ast.ObjectValue:
+		imap := make(map[string]interface{}, len(val.Fields))
+		for _, field := range val.Fields {
+			imap[field.Name.Value] = valueFromAST(field.Value)
+		}
+		return imap
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package base
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/introspection"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+const userServiceSDL = `
+enum BasicUserStatus { Normal Delete }
+type BasicUser { id: String nickname: String status: BasicUserStatus }
+type Query { user(user_id: String!): BasicUser }
+type Mutation { updateNickname(user_id: String!, nickname: String!): BasicUser }
+`
+
+const tokenServiceSDL = `
+scalar DateTime
+type Token { user_id: String token: String token_expire: DateTime }
+enum BasicUserStatus { Normal Delete }
+type Query { token(token: String!): Token }
+`
+
+func mustSDL(t *testing.T, sdl string) *introspection.Schema {
+	s, err := introspection.FromSDL(sdl)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return s
+}
+
+func TestMergeSchemas(t *testing.T) {
+	services := []rpc.FGService{rpc.FGSUser, rpc.FGSToken}
+	merged, owners, err := mergeSchemas(services, map[rpc.FGService]*introspection.Schema{
+		rpc.FGSUser:  mustSDL(t, userServiceSDL),
+		rpc.FGSToken: mustSDL(t, tokenServiceSDL),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, rpc.FGSUser, owners["Query.user"])
+	assert.Equal(t, rpc.FGSToken, owners["Query.token"])
+	assert.Equal(t, rpc.FGSUser, owners["Mutation.updateNickname"])
+
+	schema, err := newStitcher(merged, owners).build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.NotNil(t, schema.QueryType().Fields()["user"])
+	assert.NotNil(t, schema.QueryType().Fields()["token"])
+	assert.NotNil(t, schema.MutationType().Fields()["updateNickname"])
+	_, ok := schema.Type("DateTime").(*graphql.Scalar)
+	assert.True(t, ok)
+}
+
+func TestMergeSchemasConflict(t *testing.T) {
+	services := []rpc.FGService{rpc.FGSUser, rpc.FGSToken}
+	_, _, err := mergeSchemas(services, map[rpc.FGService]*introspection.Schema{
+		rpc.FGSUser:  mustSDL(t, userServiceSDL),
+		rpc.FGSToken: mustSDL(t, `enum BasicUserStatus { Normal } type Query { user: String }`),
+	})
+	conflicts, ok := err.(SchemaConflicts)
+	assert.True(t, ok)
+	assert.Len(t, conflicts, 2)
+}
+
+// 下游服务以自己的APP_NAME发布schema, gateway能够watch到摘要的变化
+func TestGatewayWatchSchemaHash(t *testing.T) {
+	if os.Getenv("BASE_TEST_PUBLISH_SCHEMA") == "1" {
+		assert.Nil(t, discovery.Init(discovery.Options{}))
+		(&Daemon{service: rpc.FGSUser}).publishSchema(mustSDL(t, userServiceSDL))
+		return
+	}
+	dir, err := ioutil.TempDir("", "gateway")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	// 发布之前key不存在, 缩短重新watch的间隔
+	assert.Nil(t, discovery.Init(discovery.Options{
+		Config:             "file://" + dir,
+		WatchRetryInterval: 50 * time.Millisecond,
+		FileWatchInterval:  50 * time.Millisecond,
+	}))
+	ch, err := discovery.Watch(schemaKey(rpc.FGSUser, "hash"))
+	assert.Nil(t, err)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGatewayWatchSchemaHash$")
+	cmd.Env = append(os.Environ(), "BASE_TEST_PUBLISH_SCHEMA=1", "APP_NAME=user", "APP_ZK=file://"+dir)
+	out, err := cmd.CombinedOutput()
+	assert.Nil(t, err, string(out))
+	for {
+		select {
+		case kvpair := <-ch:
+			if kvpair != nil && string(kvpair.Value) == mustSDL(t, userServiceSDL).Hash() {
+				return
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("schema hash published by user not received")
+		}
+	}
+}
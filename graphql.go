@@ -3,6 +3,7 @@ package base
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/graphql-go/graphql"
@@ -132,24 +133,26 @@ func getSchemeArgs(
 	}
 	output = defArgs.Type
 	// build args: a: a-value, b: b-value, ...
-	for i, arg := range defArgs.Args {
-		if _, ok := p.Args[arg.PrivateName]; ok {
-			if i == 0 {
-				params = fmt.Sprintf("%s:%v", arg.PrivateName,
-					FixTypeFromGoToGraphql(p.Args[arg.PrivateName], arg.Type))
-			} else {
-				params = fmt.Sprintf("%s, %s:%v", params, arg.PrivateName,
-					FixTypeFromGoToGraphql(p.Args[arg.PrivateName], defArgs.Args[i].Type))
+	// 参数定义的顺序不固定, 按照请求中的顺序输出, 未出现的默认值按名称排序
+	for _, arg := range sortedArgs(defArgs.Args, p.Info.FieldASTs[0].Arguments) {
+		if value, ok := p.Args[arg.PrivateName]; ok {
+			if len(params) > 0 {
+				params += ", "
 			}
+			params += fmt.Sprintf("%s:%s", arg.PrivateName, printGLValue(value, arg.Type))
 		}
 	}
-	// expand
-	for arg, value := range exArgs {
+	// expand, 按名称排序保证转发的请求稳定
+	exNames := make([]string, 0, len(exArgs))
+	for arg := range exArgs {
+		exNames = append(exNames, arg)
+	}
+	sort.Strings(exNames)
+	for _, arg := range exNames {
 		if len(params) > 0 {
-			params = fmt.Sprintf("%s, %s:%v", params, arg, FixTypeFromGoToGraphql(value, excommon[arg]))
-		} else {
-			params = fmt.Sprintf("%s:%v", arg, FixTypeFromGoToGraphql(value, excommon[arg]))
+			params += ", "
 		}
+		params += fmt.Sprintf("%s:%s", arg, printGLValue(exArgs[arg], excommon[arg]))
 	}
 	if len(params) > 0 {
 		params = fmt.Sprintf("(%s)", params)
@@ -157,48 +160,39 @@ func getSchemeArgs(
 	return
 }
 
-// 获取返回参数列表
-func getSchemaSelections(p graphql.ResolveParams) string {
-	var ss *ast.SelectionSet
-	if ss = p.Info.FieldASTs[0].GetSelectionSet(); ss == nil {
-		return ""
+func sortedArgs(defs []*graphql.Argument, asts []*ast.Argument) []*graphql.Argument {
+	var (
+		ret   = make([]*graphql.Argument, 0, len(defs))
+		order = make(map[string]int, len(asts))
+	)
+	for idx, arg := range asts {
+		order[arg.Name.Value] = idx
 	}
-	return reverseSelection(ss.Selections)
-}
-
-func reverseSelection(sset []ast.Selection) string {
-	var result []string
-	for _, item := range sset {
-		switch f := item.(type) {
-		case *ast.Field:
-			if f.Name != nil {
-				result = append(result, f.Name.Value)
-			}
+	ret = append(ret, defs...)
+	sort.SliceStable(ret, func(i, j int) bool {
+		oi, iok := order[ret[i].PrivateName]
+		oj, jok := order[ret[j].PrivateName]
+		if iok != jok {
+			return iok
 		}
-		temp := item.GetSelectionSet()
-		if temp != nil && len(temp.Selections) > 0 {
-			if str := reverseSelection(temp.Selections); len(str) > 0 {
-				result = append(result, str)
-			}
+		if iok {
+			return oi < oj
 		}
-	}
-	if len(result) > 0 {
-		return fmt.Sprintf("{%s}", strings.Join(result, " "))
-	}
-	return ""
+		return ret[i].PrivateName < ret[j].PrivateName
+	})
+	return ret
 }
 
-func RedirectRequestEx(
+// buildRedirectQuery 根据当前resolve的AST重新拼装转发到目标服务的graphql请求
+func buildRedirectQuery(
 	p graphql.ResolveParams,
 	exArgs map[string]interface{},
 	excommon map[string]graphql.Input,
-	targetService rpc.FGService,
-	targetObj interface{}) (interface{}, error) {
+) (query string, method string, output graphql.Output) {
 	var (
 		params     string // 参数列表
 		selections string // 返回列表
-		method     string //方法名
-		output     graphql.Output
+		fragments  string // 引用的fragment定义
 	)
 	// 看看自己对schema的AST了解有多深
 	// get method name
@@ -206,22 +200,48 @@ func RedirectRequestEx(
 	// build args
 	params, output = getSchemeArgs(p, exArgs, excommon)
 	// build selections
-	selections = getSchemaSelections(p)
+	sp := newSelectionPrinter(p)
+	selections = sp.selectionSet(p.Info.FieldASTs[0].GetSelectionSet(), p.Info.ReturnType)
+	fragments = sp.fragmentDefinitions()
 
 	// composite schema
-	schema := fmt.Sprintf("%s{%s%s%s}",
+	query = fmt.Sprintf("%s%s{%s%s%s}%s",
 		p.Info.Operation.GetOperation(),
+		uploadVariableDefinitions(collectUploads(p.Args, exArgs)),
 		method,
 		params,
 		selections,
+		fragments,
 	)
+	return
+}
+
+// RedirectRequestEx 转发请求并附加额外的参数, 字段有别名时key为别名
+// 对象数据为转发专用的map类型, 使用RedirectResultMap转化为map[string]interface{}
+func RedirectRequestEx(
+	p graphql.ResolveParams,
+	exArgs map[string]interface{},
+	excommon map[string]graphql.Input,
+	targetService rpc.FGService,
+	targetObj interface{}) (interface{}, error) {
+	var (
+		data    map[string]interface{}
+		err     error
+		uploads = collectUploads(p.Args, exArgs)
+	)
+	query, method, output := buildRedirectQuery(p, exArgs, excommon)
 	// rpc call service
-	data, err := rpc.CallService(p.Context, Service2Url(targetService), schema)
+	if len(uploads) > 0 {
+		data, err = rpc.CallServiceWithUploads(p.Context, Service2Url(targetService), query, nil, uploads)
+	} else {
+		data, err = rpc.CallService(p.Context, Service2Url(targetService), query)
+	}
 	if err != nil {
 		return nil, err
 	}
 	// parse result
-	return FixTypeFromGraphqlToGo(data[method], output), nil
+	return fixRedirectResult(data[method], output,
+		p.Info.FieldASTs[0].GetSelectionSet(), p.Info.Fragments, p.Info.Schema), nil
 }
 
 //RedirectRequest redirect request from one resolve function to another service
@@ -242,10 +262,8 @@ func GLObjectFields(obj *graphql.Object) string {
 	return "{" + strings.Join(keys, "\n") + "}"
 }
 
-// 隐藏某些不愿意暴露的字段
+// 隐藏某些不愿意暴露的字段, 返回同名的派生类型, 不修改obj
+// 同一个schema中不能同时使用obj和返回的类型, 需要同时使用时用DeriveGLObject指定新的名称
 func HideGLFields(obj *graphql.Object, v ...string) *graphql.Object {
-	for _, item := range v {
-		delete(obj.Fields(), item)
-	}
-	return obj
+	return DeriveGLObject(obj, obj.Name(), Omit(v...))
 }
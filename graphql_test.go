@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
 )
 
 type Person struct {
@@ -187,3 +188,127 @@ func TestFixTypeFromGoToGraphqlComplex(t *testing.T) {
 	}
 	fmt.Println(FixTypeFromGoToGraphql(person, GLPerson))
 }
+
+var GLCompany = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name:        "Company",
+		Description: "公司",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type:        graphql.String,
+				Description: "公司名称",
+			},
+			"workers": &graphql.Field{
+				Type:        graphql.NewList(GLWorker),
+				Description: "员工列表",
+				Args: graphql.FieldConfigArgument{
+					"position": &graphql.ArgumentConfig{
+						Type: GLPositionEnum,
+					},
+					"first": &graphql.ArgumentConfig{
+						Type: graphql.Int,
+					},
+				},
+			},
+		},
+	},
+)
+
+func newRedirectSchema(resolve graphql.FieldResolveFn) graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"company": &graphql.Field{
+					Type: GLCompany,
+					Args: graphql.FieldConfigArgument{
+						"name": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+					},
+					Resolve: resolve,
+				},
+			},
+		}),
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+	installRedirectResolvers(&schema)
+	return schema
+}
+
+// aliases, fragments, directives and nested arguments are kept when redirecting
+func TestBuildRedirectQuery(t *testing.T) {
+	var query string
+	schema := newRedirectSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		query, _, _ = buildRedirectQuery(p, nil, nil)
+		return nil, nil
+	})
+	result := graphql.Do(graphql.Params{
+		Schema: schema,
+		RequestString: `query Q($pos: PositionEnum, $detail: Boolean!) {
+			company(name: "alibaba") {
+				title: name
+				staff: workers(position: $pos, first: 10) @include(if: $detail) { ...workerFields }
+				... on Company { name }
+			}
+		}
+		fragment workerFields on Worker { company position }`,
+		VariableValues: map[string]interface{}{
+			"pos":    "CEO",
+			"detail": true,
+		},
+	})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t,
+		`query{company(name:"alibaba"){title:name staff:workers(position:CEO, first:10) @include(if:true){...workerFields __typename} ... on Company{name} __typename}} fragment workerFields on Worker{company position}`,
+		query,
+	)
+}
+
+// redirect result keyed by alias is resolved by the local executor
+func TestFixRedirectResult(t *testing.T) {
+	remote := map[string]interface{}{
+		"title": "alibaba",
+		"staff": []interface{}{
+			map[string]interface{}{"company": "alibaba", "role": "CEO"},
+		},
+	}
+	schema := newRedirectSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		return fixRedirectResult(remote, p.Info.ReturnType,
+			p.Info.FieldASTs[0].GetSelectionSet(), p.Info.Fragments, p.Info.Schema), nil
+	})
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: `{company{title: name staff: workers{company role: position}}}`,
+	})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"company": map[string]interface{}{
+			"title": "alibaba",
+			"staff": []interface{}{
+				map[string]interface{}{"company": "alibaba", "role": "CEO"},
+			},
+		},
+	}, result.Data)
+	// 调用方使用RedirectResultMap转化为map[string]interface{}
+	imap, ok := RedirectResultMap(fixRedirectResult(remote, schema.QueryType().Fields()["company"].Type, nil, nil, schema))
+	assert.True(t, ok)
+	assert.Equal(t, "alibaba", imap["title"])
+}
+
+// 本地resolve返回的map按字段名取值, 别名与其他字段同名时不会读错
+func TestLocalResultAlias(t *testing.T) {
+	schema := newRedirectSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		return map[string]interface{}{"name": "alibaba", "workers": []interface{}{}}, nil
+	})
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: `{company{workers: name}}`,
+	})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"company": map[string]interface{}{"workers": "alibaba"},
+	}, result.Data)
+}
@@ -7,10 +7,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/graphql/gqlerrors"
 	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/dataloader"
 	"github.com/microsvs/base/pkg/env"
 	"github.com/microsvs/base/pkg/errors"
 	"github.com/microsvs/base/pkg/log"
@@ -42,12 +45,25 @@ const (
 )
 
 type Daemon struct {
+	mutex       sync.RWMutex
 	service     rpc.FGService
 	extHandlers map[string]http.Handler
 	schema      *graphql.Schema
 	handler     *handler.Handler
 	middlewares *negroni.Negroni
 	phasesMap   map[PHASES][]http.HandlerFunc
+	limits      QueryLimits
+	costs       map[string]FieldCost
+	queries     QueryStore
+	allowList   bool
+	apqCache    *queryLRU
+	origins     []string
+	sse         sseBroker
+	uploads     UploadLimits
+	authRules   map[string]AuthRule
+	maskRules   map[string]MaskRule
+	schemaCheck SchemaCheckMode
+	usage       *usageTracker
 }
 
 // example: "FGError:40011:invalid user"
@@ -84,14 +100,11 @@ func NewGLDaemon(service rpc.FGService, schema *graphql.Schema) (*Daemon, error)
 	if schema == nil {
 		return nil, errors.GraphqlObjectIsNull
 	}
-	config := handler.NewConfig()
-	config.Schema = schema
-	config.HandlerErrorResp = customErrorFormat
 	d = &Daemon{
 		service:     service,
 		extHandlers: make(map[string]http.Handler),
 		schema:      schema,
-		handler:     handler.New(config),
+		handler:     newSchemaHandler(schema, true),
 		middlewares: negroni.New(func() *negroni.Recovery {
 			recovery := negroni.NewRecovery()
 			recovery.PrintStack = false
@@ -100,6 +113,7 @@ func NewGLDaemon(service rpc.FGService, schema *graphql.Schema) (*Daemon, error)
 		}()),
 		phasesMap: make(map[PHASES][]http.HandlerFunc),
 	}
+	d.installResolvers(schema)
 
 	// init global tracer
 	tracer := tracing.Init(
@@ -114,6 +128,53 @@ func NewGLDaemon(service rpc.FGService, schema *graphql.Schema) (*Daemon, error)
 	return d, nil
 }
 
+// installResolvers 包装schema中所有字段的resolver, 每次都从原始的resolver开始包装
+func (d *Daemon) installResolvers(schema *graphql.Schema) {
+	installRedirectResolvers(schema)
+	d.installAuthorization(schema)
+	d.installMasking(schema)
+	d.installUsageTracking(schema)
+}
+
+func newSchemaHandler(schema *graphql.Schema, graphiql bool) *handler.Handler {
+	config := handler.NewConfig()
+	config.Schema = schema
+	config.GraphiQL = graphiql
+	config.HandlerErrorResp = customErrorFormat
+	return handler.New(config)
+}
+
+// UpdateSchema 替换Daemon使用的schema, 已经在处理的请求不受影响
+func (d *Daemon) UpdateSchema(schema *graphql.Schema) error {
+	if schema == nil {
+		return errors.GraphqlObjectIsNull
+	}
+	d.installResolvers(schema)
+	d.mutex.Lock()
+	old := d.schema
+	d.schema = schema
+	d.handler = newSchemaHandler(schema, !d.allowList)
+	d.mutex.Unlock()
+	// gateway每次刷新都创建新的类型, 释放旧schema记录的原始resolver
+	if old != nil {
+		releaseResolvers(old)
+	}
+	return nil
+}
+
+// Schema 返回Daemon当前使用的schema
+func (d *Daemon) Schema() *graphql.Schema {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.schema
+}
+
+func (d *Daemon) currentHandler() *handler.Handler {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.handler
+}
+
 func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var (
 		ctx context.Context
@@ -124,7 +185,13 @@ func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			GLReturnError(err, w)
 		}
 	}()
-	switch getProtocalType(r) {
+	// subscription与查询使用同一个路径
+	if websocket.IsWebSocketUpgrade(r) {
+		d.serveSubscription(w, r)
+		return
+	}
+	protocal := getProtocalType(r)
+	switch protocal {
 	case rpc.HTTP: // external request
 		if ctx, err = buildContext(r); err != nil {
 			// error handler
@@ -135,8 +202,39 @@ func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if ctx, err = rpc.ContextFromHTTPRequest(ctx, r); err != nil {
 			return
 		}
+		ctx = context.WithValue(ctx, rpc.KeyService, d.service.String())
+	default:
+		log.ErrorRaw("[ServeHTTP] unknown protocal %s", protocal)
+		err = errors.FGEInvalidRequestParam
+		return
 	}
-	d.handler.ContextHandler(ctx, w, r)
+	// 文件上传在serveMultipart中解析
+	if !isMultipartRequest(r) {
+		// 持久化查询和白名单对所有请求生效, 协议头由客户端设置, 不能据此跳过
+		var req *graphqlRequest
+		if req, err = readGraphqlRequest(r); err != nil {
+			return
+		}
+		if err = d.resolvePersistedQuery(r, req); err != nil {
+			return
+		}
+		// 查询限制同样对所有请求生效
+		if err = d.checkQueryLimits(req); err != nil {
+			return
+		}
+		if protocal == rpc.HTTP && acceptEventStream(r) {
+			err = d.serveSSE(ctx, w, r, req)
+			return
+		}
+	}
+	// 请求级别的DataLoader, 合并本次请求内对下游服务的调用
+	ctx = dataloader.WithRegistry(ctx)
+	// handler只支持json和application/graphql
+	if isMultipartRequest(r) {
+		err = d.serveMultipart(ctx, w, r)
+		return
+	}
+	d.currentHandler().ContextHandler(ctx, w, r)
 	return
 }
 
@@ -184,7 +282,13 @@ func (d *Daemon) AfterRouter(middleware ...http.HandlerFunc) {
 func (d *Daemon) Listen() {
 	mux := tracing.NewServeMux(opentracing.GlobalTracer())
 	mux.Handle("/graphql", d)
-	mux.Handle("/", http.FileServer(assetFS()))
+	// 白名单模式不提供GraphiQL
+	if !d.allowList {
+		mux.Handle("/", http.FileServer(assetFS()))
+	}
+	if _, ok := d.extHandlers["/health"]; !ok {
+		mux.Handle("/health", http.HandlerFunc(healthHandler))
+	}
 	for url, handler := range d.extHandlers {
 		mux.Handle(url, handler)
 	}
@@ -201,6 +305,9 @@ func (d *Daemon) Listen() {
 		d.middlewares.UseHandlerFunc(fn)
 	}
 
+	if err := d.registerSchema(); err != nil {
+		panic("register schema of " + d.service.String() + " failed, err: " + err.Error())
+	}
 	log.InfoRaw("service %s start at %d", d.service.String(), d.service)
 	http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", d.service), d.middlewares)
 	return
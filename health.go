@@ -0,0 +1,55 @@
+package base
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/microsvs/base/cmd/discovery"
+)
+
+// HealthStatus /health接口的返回值
+// 配置中心不可用但使用本地快照启动时Status为stale, 仍然返回200, 避免配置中心故障时所有实例被摘除
+type HealthStatus struct {
+	Status    string `json:"status"` // ok, stale, degraded
+	Discovery string `json:"discovery"`
+}
+
+const (
+	HealthOK       = "ok"
+	HealthStale    = "stale"
+	HealthDegraded = "degraded"
+)
+
+// Health 服务当前的健康状态
+func Health() HealthStatus {
+	st := discovery.GetStatus()
+	ret := HealthStatus{Status: HealthOK, Discovery: st.String()}
+	switch {
+	case st.Stale:
+		ret.Status = HealthStale
+	case st.State != discovery.StateConnected:
+		ret.Status = HealthDegraded
+	}
+	return ret
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	bts, _ := json.Marshal(Health())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(bts)
+}
+
+// EnableDiscoveryDebug 提供/debug/discovery接口, 返回配置中心地址和所有watch的path, 需要在Listen之前调用
+// 接口没有鉴权, 只在服务端口不对外暴露时开启
+/* example
+d.EnableDiscoveryDebug()
+curl localhost:8085/debug/discovery
+*/
+func (d *Daemon) EnableDiscoveryDebug() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.extHandlers == nil {
+		d.extHandlers = make(map[string]http.Handler)
+	}
+	d.extHandlers["/debug/discovery"] = discovery.DebugHandler
+}
@@ -0,0 +1,315 @@
+package base
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/log"
+)
+
+// QueryLimits 查询限制, 值为0表示不限制
+type QueryLimits struct {
+	MaxDepth   int // 最大嵌套深度
+	MaxFields  int // 最大字段数
+	MaxAliases int // 最大别名数
+	MaxCost    int // 最大计算代价
+	// 列表字段未通过参数指定数量时使用的乘数
+	DefaultListSize int
+}
+
+// FieldCost 字段代价, Multipliers为决定返回数量的参数名, 子字段代价乘以参数值
+/* example
+d.SetFieldCost("Company", "workers", FieldCost{Cost: 2, Multipliers: []string{"first"}})
+*/
+type FieldCost struct {
+	Cost        int
+	Multipliers []string
+}
+
+// maxQueryCost 代价的上限, 超过时按上限计算, 防止溢出
+const maxQueryCost = math.MaxInt32
+
+// queryComplexity 一次查询的统计结果
+type queryComplexity struct {
+	Depth   int
+	Fields  int
+	Aliases int
+	Cost    int
+}
+
+// SetQueryLimits 设置查询限制, 在执行前校验
+func (d *Daemon) SetQueryLimits(limits QueryLimits) {
+	d.mutex.Lock()
+	d.limits = limits
+	d.mutex.Unlock()
+}
+
+// SetFieldCost 设置字段代价, 未设置的字段代价为1
+func (d *Daemon) SetFieldCost(typeName, fieldName string, cost FieldCost) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.costs == nil {
+		d.costs = make(map[string]FieldCost)
+	}
+	d.costs[typeName+"."+fieldName] = cost
+}
+
+// checkQueryLimits 校验请求是否超过限制, 超过时记录metrics并返回FGEQueryTooComplex
+func (d *Daemon) checkQueryLimits(req *graphqlRequest) error {
+	d.mutex.RLock()
+	limits, costs, schema := d.limits, d.costs, d.schema
+	d.mutex.RUnlock()
+	if limits == (QueryLimits{}) {
+		return nil
+	}
+	c, err := analyzeQuery(schema, req, limits, costs)
+	if err != nil || c == nil {
+		// 语法错误交给handler处理
+		return nil
+	}
+	reason, err := limits.check(c)
+	if err != nil {
+		metricsFactory.Counter("graphql_rejected", map[string]string{"reason": reason}).Inc(1)
+		log.ErrorRaw("[checkQueryLimits] reject query. operation=%s, err=%s", req.OperationName, err.Error())
+	}
+	return err
+}
+
+// check 返回超出的限制名称及错误
+func (l QueryLimits) check(c *queryComplexity) (string, error) {
+	var items = []struct {
+		reason     string
+		value, max int
+	}{
+		{"depth", c.Depth, l.MaxDepth},
+		{"fields", c.Fields, l.MaxFields},
+		{"aliases", c.Aliases, l.MaxAliases},
+		{"cost", c.Cost, l.MaxCost},
+	}
+	for _, item := range items {
+		if item.max > 0 && item.value > item.max {
+			return item.reason, errors.FGEQueryTooComplex.Errorf(
+				"query %s %d exceeds limit %d", item.reason, item.value, item.max)
+		}
+	}
+	return "", nil
+}
+
+// analyzeQuery 统计查询的深度, 字段数, 别名数和代价, 展开fragment, 忽略内省字段
+func analyzeQuery(schema *graphql.Schema, req *graphqlRequest, limits QueryLimits,
+	costs map[string]FieldCost) (*queryComplexity, error) {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		op        *ast.OperationDefinition
+		fragments = make(map[string]*ast.FragmentDefinition)
+	)
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.OperationDefinition:
+			if op == nil && (req.OperationName == "" ||
+				(def.Name != nil && def.Name.Value == req.OperationName)) {
+				op = def
+			}
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		}
+	}
+	if op == nil {
+		return nil, nil
+	}
+	a := &queryAnalyzer{
+		schema:    schema,
+		variables: req.Variables,
+		fragments: fragments,
+		limits:    limits,
+		costs:     costs,
+		result:    new(queryComplexity),
+	}
+	var root *graphql.Object
+	if schema != nil {
+		switch op.Operation {
+		case ast.OperationTypeMutation:
+			root = schema.MutationType()
+		case ast.OperationTypeSubscription:
+			root = schema.SubscriptionType()
+		default:
+			root = schema.QueryType()
+		}
+	}
+	var parent graphql.Type
+	if root != nil {
+		parent = root
+	}
+	a.result.Cost = a.selectionSet(op.SelectionSet, parent, 1, map[string]bool{})
+	return a.result, nil
+}
+
+type queryAnalyzer struct {
+	schema    *graphql.Schema
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+	limits    QueryLimits
+	costs     map[string]FieldCost
+	result    *queryComplexity
+}
+
+// selectionSet 返回该层的代价, visited防止fragment循环引用
+func (a *queryAnalyzer) selectionSet(ss *ast.SelectionSet, parent graphql.Type,
+	depth int, visited map[string]bool) int {
+	var cost int
+	if ss == nil {
+		return 0
+	}
+	for _, sel := range ss.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			cost = addCost(cost, a.field(sel, parent, depth, visited))
+		case *ast.InlineFragment:
+			cond := parent
+			if sel.TypeCondition != nil {
+				cond = a.namedType(sel.TypeCondition.Name.Value, parent)
+			}
+			cost = addCost(cost, a.selectionSet(sel.SelectionSet, cond, depth, visited))
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			frag, ok := a.fragments[name]
+			if !ok || visited[name] {
+				continue
+			}
+			visited[name] = true
+			cost = addCost(cost, a.selectionSet(frag.SelectionSet,
+				a.namedType(frag.TypeCondition.Name.Value, parent), depth, visited))
+			delete(visited, name)
+		}
+	}
+	return cost
+}
+
+func (a *queryAnalyzer) field(f *ast.Field, parent graphql.Type, depth int, visited map[string]bool) int {
+	name := f.Name.Value
+	if name == "__typename" || name == "__schema" || name == "__type" {
+		return 0
+	}
+	a.result.Fields++
+	if f.Alias != nil && f.Alias.Value != name {
+		a.result.Aliases++
+	}
+	if depth > a.result.Depth {
+		a.result.Depth = depth
+	}
+	var (
+		fieldType graphql.Type
+		typeName  string
+	)
+	if parent != nil {
+		typeName = namedGLType(parent).Name()
+		if def, ok := glTypeFields(parent)[name]; ok {
+			fieldType = def.Type
+		}
+	}
+	fc, ok := a.costs[typeName+"."+name]
+	if !ok {
+		fc = FieldCost{Cost: 1}
+	}
+	var child graphql.Type
+	if fieldType != nil {
+		child = namedGLType(fieldType)
+	}
+	children := a.selectionSet(f.SelectionSet, child, depth+1, visited)
+	if children == 0 {
+		return fc.Cost
+	}
+	return addCost(fc.Cost, mulCost(a.multiplier(f, fc, fieldType), children))
+}
+
+// multiplier 子字段代价的乘数, 优先取参数值, 列表字段默认取DefaultListSize
+// 参数值来自请求, 小于1的值忽略, 超过上限(MaxCost, 未设置时为maxQueryCost)的按上限计算
+func (a *queryAnalyzer) multiplier(f *ast.Field, fc FieldCost, fieldType graphql.Type) int {
+	max := maxQueryCost
+	if a.limits.MaxCost > 0 && a.limits.MaxCost < max {
+		max = a.limits.MaxCost
+	}
+	for _, name := range fc.Multipliers {
+		for _, arg := range f.Arguments {
+			if arg.Name.Value != name {
+				continue
+			}
+			if n, ok := a.numberValue(arg.Value); ok && n >= 1 {
+				if n >= float64(max) {
+					return max
+				}
+				return int(n)
+			}
+		}
+	}
+	if isListGLType(fieldType) && a.limits.DefaultListSize > 0 {
+		return a.limits.DefaultListSize
+	}
+	return 1
+}
+
+// numberValue 参数值转化为float64, 超出int范围的数字不会解析失败
+func (a *queryAnalyzer) numberValue(v ast.Value) (float64, bool) {
+	var s string
+	switch v := v.(type) {
+	case *ast.IntValue:
+		s = v.Value
+	case *ast.Variable:
+		// json解析出的数字为float64
+		s = fmt.Sprint(a.variables[v.Name.Value])
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+// addCost 代价相加, 结果不超过maxQueryCost
+func addCost(a, b int) int {
+	if a > maxQueryCost-b {
+		return maxQueryCost
+	}
+	return a + b
+}
+
+// mulCost 代价相乘, 结果不超过maxQueryCost
+func mulCost(a, b int) int {
+	if a > 0 && b > maxQueryCost/a {
+		return maxQueryCost
+	}
+	return a * b
+}
+
+func (a *queryAnalyzer) namedType(name string, fallback graphql.Type) graphql.Type {
+	if a.schema != nil {
+		if t := a.schema.Type(name); t != nil {
+			return t
+		}
+	}
+	return fallback
+}
+
+func isListGLType(t graphql.Type) bool {
+	for t != nil {
+		switch tt := t.(type) {
+		case *graphql.NonNull:
+			t = tt.OfType
+		case *graphql.List:
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
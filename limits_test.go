@@ -0,0 +1,134 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var limitsQuery = `query company($first: Int) {
+	company(name: "xhj") {
+		name
+		alias: name
+		workers(first: $first) {
+			company
+			...worker
+		}
+		__typename
+	}
+}
+fragment worker on Worker { position }`
+
+func TestAnalyzeQuery(t *testing.T) {
+	schema := newRedirectSchema(nil)
+	req := &graphqlRequest{
+		Query:     limitsQuery,
+		Variables: map[string]interface{}{"first": float64(10)},
+	}
+	c, err := analyzeQuery(&schema, req, QueryLimits{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &queryComplexity{Depth: 3, Fields: 6, Aliases: 1, Cost: 6}, c)
+
+	// 列表字段默认乘数
+	c, _ = analyzeQuery(&schema, req, QueryLimits{DefaultListSize: 5}, nil)
+	assert.Equal(t, 14, c.Cost)
+
+	// 字段代价和参数乘数
+	costs := map[string]FieldCost{"Company.workers": {Cost: 2, Multipliers: []string{"first"}}}
+	c, _ = analyzeQuery(&schema, req, QueryLimits{DefaultListSize: 5}, costs)
+	assert.Equal(t, 25, c.Cost)
+}
+
+func TestCheckQueryLimits(t *testing.T) {
+	schema := newRedirectSchema(nil)
+	d := &Daemon{schema: &schema}
+	req := &graphqlRequest{Query: limitsQuery}
+	assert.Nil(t, d.checkQueryLimits(req))
+
+	d.SetQueryLimits(QueryLimits{MaxDepth: 3, MaxAliases: 1})
+	assert.Nil(t, d.checkQueryLimits(req))
+
+	d.SetQueryLimits(QueryLimits{MaxDepth: 2})
+	err := d.checkQueryLimits(req)
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), errors.FGErrorPrefix))
+	assert.Equal(t, int(errors.FGEQueryTooComplex), customErrorFormat(
+		[]gqlerrors.FormattedError{{Message: err.Error()}}).(*types.CustomError).ErrCode)
+
+	d.SetQueryLimits(QueryLimits{MaxCost: 10})
+	d.SetFieldCost("Company", "workers", FieldCost{Cost: 2, Multipliers: []string{"first"}})
+	req.Variables = map[string]interface{}{"first": 10}
+	assert.NotNil(t, d.checkQueryLimits(req))
+}
+
+// 请求中的乘数不可信, 超大的值按上限计算, 非正数忽略, 代价不会溢出
+func TestQueryCostMultiplier(t *testing.T) {
+	schema := newRedirectSchema(nil)
+	costs := map[string]FieldCost{"Company.workers": {Cost: 2, Multipliers: []string{"first"}}}
+	for first, want := range map[interface{}]int{
+		float64(1e300):         maxQueryCost,
+		"99999999999999999999": maxQueryCost,
+		float64(-10):           15,
+		float64(0):             15,
+		float64(2):             9,
+	} {
+		req := &graphqlRequest{Query: limitsQuery, Variables: map[string]interface{}{"first": first}}
+		c, err := analyzeQuery(&schema, req, QueryLimits{DefaultListSize: 5}, costs)
+		assert.Nil(t, err)
+		assert.Equal(t, want, c.Cost, "first=%v", first)
+	}
+
+	// 设置MaxCost时乘数不超过MaxCost
+	req := &graphqlRequest{Query: `{company{workers(first: 99999999999999999999){company}}}`}
+	c, _ := analyzeQuery(&schema, req, QueryLimits{MaxCost: 100}, costs)
+	assert.Equal(t, 1+2+100, c.Cost)
+}
+
+func TestReadGraphqlRequest(t *testing.T) {
+	body := `{"query":"{ company { name } }","variables":{"first":1},"operationName":"company"}`
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+	req, err := readGraphqlRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "{ company { name } }", req.Query)
+	assert.Equal(t, "company", req.OperationName)
+	assert.Equal(t, float64(1), req.Variables["first"])
+	// body可以被handler再次读取
+	bts, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, body, string(bts))
+
+	// variables为json字符串
+	body = `{"query":"{ company { name } }","variables":"{\"first\":2}"}`
+	r = httptest.NewRequest("POST", "/graphql", bytes.NewBufferString(body))
+	req, err = readGraphqlRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), req.Variables["first"])
+
+	r = httptest.NewRequest("GET", "/graphql?query={company{name}}", nil)
+	req, _ = readGraphqlRequest(r)
+	assert.Equal(t, "{company{name}}", req.Query)
+}
+
+// 客户端设置RPC协议头也不能绕过查询限制
+func TestCheckQueryLimitsRPC(t *testing.T) {
+	schema := newRedirectSchema(nil)
+	d := &Daemon{schema: &schema}
+	d.SetQueryLimits(QueryLimits{MaxDepth: 1})
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ company { name } }"}`))
+	ctx := context.WithValue(context.Background(), rpc.KeyRawRequest, httptest.NewRequest("POST", "/graphql", nil))
+	assert.Nil(t, rpc.ContextToHTTPRequest(ctx, r))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	assert.Contains(t, w.Body.String(), strconv.Itoa(int(errors.FGEQueryTooComplex)))
+}
@@ -0,0 +1,118 @@
+package base
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/mask"
+)
+
+// MaskRule 字段返回值脱敏规则, 用户拥有Permission权限时返回原始值, Permission为空时总是脱敏
+type MaskRule struct {
+	Kind       mask.Kind
+	Permission string
+}
+
+// DefaultMaskRules 默认的脱敏规则, 调用Daemon.UseDefaultMaskRules以后生效, 可以通过Daemon.Mask覆盖
+var DefaultMaskRules = map[string]MaskRule{
+	"BasicUser.mobile": {Kind: mask.Phone, Permission: "user:mobile"},
+}
+
+// Mask 设置字段的脱敏规则, name格式为"Type.field", 字段类型为String或者[String]
+/* example
+d.Mask("Order.mobile", base.MaskRule{Kind: mask.Phone, Permission: "order:mobile"})
+d.Mask("Order.email", base.MaskRule{Kind: mask.Email})
+*/
+func (d *Daemon) Mask(name string, rule MaskRule) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.maskRules == nil {
+		d.maskRules = make(map[string]MaskRule)
+	}
+	d.maskRules[name] = rule
+}
+
+// UseDefaultMaskRules 使用DefaultMaskRules, 已经通过Mask设置的字段不变
+// 只在直接面向客户端的Daemon(比如gateway)上调用, 内部服务之间需要原始值(比如发送短信的手机号)
+/* example
+d, err := base.NewGatewayDaemon(rpc.FGSGateway, rpc.FGSToken, rpc.FGSUser)
+d.UseDefaultMaskRules()
+*/
+func (d *Daemon) UseDefaultMaskRules() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.maskRules == nil {
+		d.maskRules = make(map[string]MaskRule)
+	}
+	for name, rule := range DefaultMaskRules {
+		if _, ok := d.maskRules[name]; !ok {
+			d.maskRules[name] = rule
+		}
+	}
+}
+
+// installMasking 对resolve的结果脱敏, 规则可以在schema安装之后设置
+func (d *Daemon) installMasking(schema *graphql.Schema) {
+	walkSchemaFields(schema, func(obj *graphql.Object, field *graphql.FieldDefinition) {
+		if field.Resolve != nil && isStringGLType(field.Type) {
+			field.Resolve = d.maskResolve(obj.Name()+"."+field.Name, field.Resolve)
+		}
+	})
+}
+
+func (d *Daemon) maskResolve(name string, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		d.mutex.RLock()
+		rule, ok := d.maskRules[name]
+		d.mutex.RUnlock()
+		if !ok {
+			return next(p)
+		}
+		value, err := next(p)
+		if err != nil || value == nil {
+			return value, err
+		}
+		if len(rule.Permission) > 0 {
+			if user := contextUser(p.Context); user != nil && containsAny(user.Permissions, []string{rule.Permission}) {
+				return value, nil
+			}
+		}
+		return maskValue(rule.Kind, value), nil
+	}
+}
+
+// maskValue 支持string, *string和字符串列表, 其他类型原样返回
+func maskValue(kind mask.Kind, value interface{}) interface{} {
+	switch val := value.(type) {
+	case string:
+		return mask.Mask(kind, val)
+	case *string:
+		if val == nil {
+			return val
+		}
+		return mask.Mask(kind, *val)
+	case []string:
+		ret := make([]string, len(val))
+		for idx, item := range val {
+			ret[idx] = mask.Mask(kind, item)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for idx, item := range val {
+			ret[idx] = maskValue(kind, item)
+		}
+		return ret
+	}
+	return value
+}
+
+func isStringGLType(typ graphql.Output) bool {
+	switch t := typ.(type) {
+	case *graphql.NonNull:
+		return isStringGLType(t.OfType)
+	case *graphql.List:
+		return isStringGLType(t.OfType)
+	case *graphql.Scalar:
+		return t == graphql.String || t == graphql.ID
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package base
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/mask"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskResolve(t *testing.T) {
+	schema, _ := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"user": &graphql.Field{
+					Type: graphql.NewObject(graphql.ObjectConfig{
+						Name: "MaskUser",
+						Fields: graphql.Fields{
+							"mobile": &graphql.Field{Type: graphql.String},
+							"emails": &graphql.Field{Type: graphql.NewList(graphql.String)},
+						},
+					}),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return map[string]interface{}{
+							"mobile": "13812345678",
+							"emails": []string{"xhj@example.com"},
+						}, nil
+					},
+				},
+			},
+		}),
+	})
+	installRedirectResolvers(&schema)
+	d := &Daemon{schema: &schema}
+	d.installMasking(&schema)
+	grants := loadUserGrants
+	defer func() { loadUserGrants = grants }()
+	loadUserGrants = func(ctx context.Context, id string) *types.User {
+		return &types.User{ID: id}
+	}
+	d.Mask("MaskUser.mobile", MaskRule{Kind: mask.Phone, Permission: "user:mobile"})
+	d.Mask("MaskUser.emails", MaskRule{Kind: mask.Email})
+
+	do := func(user *types.User) interface{} {
+		ctx := context.WithValue(context.Background(), rpc.KeyUser, user)
+		result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ user { mobile emails } }", Context: ctx})
+		assert.Empty(t, result.Errors)
+		return result.Data
+	}
+	assert.Equal(t, map[string]interface{}{"user": map[string]interface{}{
+		"mobile": "138****5678",
+		"emails": []interface{}{"x***@example.com"},
+	}}, do(&types.User{ID: "1001"}))
+	// 拥有权限时返回原始值
+	assert.Equal(t, map[string]interface{}{"user": map[string]interface{}{
+		"mobile": "13812345678",
+		"emails": []interface{}{"x***@example.com"},
+	}}, do(&types.User{ID: "1001", Permissions: []string{"user:mobile"}}))
+}
+
+// 默认规则需要Daemon主动开启, 内部服务返回原始值
+func TestDefaultMaskRules(t *testing.T) {
+	schema, _ := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"user": &graphql.Field{
+					Type: graphql.NewObject(graphql.ObjectConfig{
+						Name:   "BasicUser",
+						Fields: graphql.Fields{"mobile": &graphql.Field{Type: graphql.String}},
+					}),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return map[string]interface{}{"mobile": "13812345678"}, nil
+					},
+				},
+			},
+		}),
+	})
+	installRedirectResolvers(&schema)
+	d := &Daemon{schema: &schema}
+	d.installMasking(&schema)
+	do := func() interface{} {
+		result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ user { mobile } }"})
+		assert.Empty(t, result.Errors)
+		return result.Data
+	}
+	assert.Equal(t, map[string]interface{}{"user": map[string]interface{}{"mobile": "13812345678"}}, do())
+	d.UseDefaultMaskRules()
+	assert.Equal(t, map[string]interface{}{"user": map[string]interface{}{"mobile": "138****5678"}}, do())
+}
@@ -53,5 +53,7 @@ func FilterSignHandler(w http.ResponseWriter, r *http.Request) {
 		GLReturnError(errors.FGECheckSignFail, w)
 		panic("_HALT_")
 	}
+	// 后续的handler使用同一个*http.Request, 替换context以后buildContext可以读到
+	*r = *r.WithContext(context.WithValue(r.Context(), verifiedAppIDKey{}, values.Get("appid")))
 	return
 }
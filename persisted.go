@@ -0,0 +1,265 @@
+package base
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/cache"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/libkv/store"
+)
+
+// QueryStore 持久化查询的存储, key为查询内容的sha256摘要
+type QueryStore interface {
+	Get(hash string) (string, error)
+	Set(hash, query string) error
+}
+
+var (
+	// PersistedQueryCacheSize 进程内缓存的查询数量
+	PersistedQueryCacheSize = 10000
+	// PersistedQueryMissTTL 不存在的摘要的缓存时间, 随机摘要不会每次访问配置中心
+	PersistedQueryMissTTL = 10 * time.Second
+	// PersistedQueryMaxSize APQ自动注册的查询的最大长度
+	PersistedQueryMaxSize = 16 * 1024
+)
+
+// kvQueryStore 保存在配置中心, 路径为: queries/<service>/<hash>
+// 读取结果缓存在进程内的LRU中, 不建立watch
+type kvQueryStore struct {
+	service rpc.FGService
+	cache   *queryLRU
+}
+
+func NewKVQueryStore(service rpc.FGService) QueryStore {
+	return &kvQueryStore{service: service, cache: newQueryLRU(PersistedQueryCacheSize)}
+}
+
+func (s *kvQueryStore) key(hash string) string {
+	return fmt.Sprintf("queries/%s/%s", s.service, hash)
+}
+
+func (s *kvQueryStore) Get(hash string) (string, error) {
+	if query, ok := s.cache.get(hash); ok {
+		if len(query) <= 0 {
+			return "", errors.FGEPersistedQueryNotFound
+		}
+		return query, nil
+	}
+	query, err := discovery.KVGet(s.key(hash))
+	if err == store.ErrKeyNotFound || (err == nil && len(query) <= 0) {
+		s.cache.set(hash, "", PersistedQueryMissTTL)
+		return "", errors.FGEPersistedQueryNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	s.cache.set(hash, query, 0)
+	return query, nil
+}
+
+func (s *kvQueryStore) Set(hash, query string) error {
+	if err := discovery.KVWrite(s.key(hash), query); err != nil {
+		return err
+	}
+	s.cache.set(hash, query, 0)
+	return nil
+}
+
+// queryLRU 进程内的查询缓存, 超过容量时淘汰最久未使用的查询
+type queryLRU struct {
+	mutex sync.Mutex
+	size  int
+	list  *list.List
+	items map[string]*list.Element
+}
+
+type queryEntry struct {
+	hash   string
+	query  string    // 为空表示摘要不存在
+	expire time.Time // 为零时不过期
+}
+
+func newQueryLRU(size int) *queryLRU {
+	return &queryLRU{size: size, list: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get ok为false表示未缓存
+func (c *queryLRU) get(hash string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.items[hash]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*queryEntry)
+	if !entry.expire.IsZero() && time.Now().After(entry.expire) {
+		c.list.Remove(elem)
+		delete(c.items, hash)
+		return "", false
+	}
+	c.list.MoveToFront(elem)
+	return entry.query, true
+}
+
+// set ttl为0时不过期
+func (c *queryLRU) set(hash, query string, ttl time.Duration) {
+	entry := &queryEntry{hash: hash, query: query}
+	if ttl > 0 {
+		entry.expire = time.Now().Add(ttl)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.items[hash]; ok {
+		elem.Value = entry
+		c.list.MoveToFront(elem)
+		return
+	}
+	c.items[hash] = c.list.PushFront(entry)
+	for c.list.Len() > c.size {
+		elem := c.list.Back()
+		c.list.Remove(elem)
+		delete(c.items, elem.Value.(*queryEntry).hash)
+	}
+}
+
+// cacheQueryStore 保存在缓存中, key为: queries:<service>:<hash>
+type cacheQueryStore struct {
+	conn    cache.Connection
+	service rpc.FGService
+}
+
+func NewCacheQueryStore(conn cache.Connection, service rpc.FGService) QueryStore {
+	return &cacheQueryStore{conn: conn, service: service}
+}
+
+func (s *cacheQueryStore) key(hash string) string {
+	return fmt.Sprintf("queries:%s:%s", s.service, hash)
+}
+
+func (s *cacheQueryStore) Get(hash string) (string, error) {
+	value, err := s.conn.Get(s.key(hash))
+	if err != nil && err != cache.KeyNotExist {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	return "", errors.FGEPersistedQueryNotFound
+}
+
+func (s *cacheQueryStore) Set(hash, query string) error {
+	return s.conn.Set(s.key(hash), query)
+}
+
+// PersistedQueryHash 查询内容的sha256摘要, 与客户端计算方式一致
+func PersistedQueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// RegisterPersistedQuery 预先注册查询, 白名单模式下只有注册过的查询可以执行
+func RegisterPersistedQuery(store QueryStore, query string) (string, error) {
+	hash := PersistedQueryHash(query)
+	return hash, store.Set(hash, query)
+}
+
+// isQueryHash 摘要必须是64位十六进制字符串, 避免拼接到配置中心路径中
+func isQueryHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// SetPersistedQueries 开启持久化查询
+// allowList为true时只允许执行已注册的查询, 同时关闭GraphiQL和未命中时的自动注册, 白名单对所有请求生效
+// APQ自动注册的查询只保存在进程内的LRU中, store只能通过RegisterPersistedQuery写入
+/* example
+d.SetPersistedQueries(base.NewKVQueryStore(rpc.FGSGateway), true)
+*/
+func (d *Daemon) SetPersistedQueries(store QueryStore, allowList bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.queries = store
+	d.allowList = allowList
+	d.apqCache = newQueryLRU(PersistedQueryCacheSize)
+	d.handler = newSchemaHandler(d.schema, !allowList)
+}
+
+// resolvePersistedQuery 根据摘要补全查询内容, 未命中时按APQ协议注册或者拒绝
+// r不为空时把补全后的查询写回r, 供handler解析
+func (d *Daemon) resolvePersistedQuery(r *http.Request, req *graphqlRequest) error {
+	d.mutex.RLock()
+	store, allowList, apq := d.queries, d.allowList, d.apqCache
+	d.mutex.RUnlock()
+	if store == nil {
+		return nil
+	}
+	hash := req.persistedHash()
+	if len(hash) <= 0 {
+		if !allowList {
+			return nil
+		}
+		hash = PersistedQueryHash(req.Query)
+		if _, err := store.Get(hash); err != nil {
+			return rejectPersistedQuery(hash)
+		}
+		return nil
+	}
+	if !isQueryHash(hash) {
+		return errors.FGEInvalidRequestParam
+	}
+	if len(req.Query) <= 0 {
+		query, ok := "", false
+		if !allowList {
+			query, ok = apq.get(hash)
+		}
+		if !ok || len(query) <= 0 {
+			var err error
+			if query, err = store.Get(hash); err != nil {
+				if allowList {
+					return rejectPersistedQuery(hash)
+				}
+				return errors.FGEPersistedQueryNotFound
+			}
+		}
+		req.Query = query
+		if r != nil {
+			req.rewrite(r)
+		}
+		return nil
+	}
+	if PersistedQueryHash(req.Query) != hash {
+		return errors.FGEInvalidRequestParam
+	}
+	if allowList {
+		if _, err := store.Get(hash); err != nil {
+			return rejectPersistedQuery(hash)
+		}
+		return nil
+	}
+	// 客户端注册的查询只保存在进程内的LRU中, 不写入store
+	if len(req.Query) <= PersistedQueryMaxSize {
+		apq.set(hash, req.Query, 0)
+	}
+	return nil
+}
+
+func rejectPersistedQuery(hash string) error {
+	metricsFactory.Counter("graphql_rejected", map[string]string{"reason": "allow_list"}).Inc(1)
+	log.ErrorRaw("[resolvePersistedQuery] query %s not in allow list", hash)
+	return errors.FGEQueryNotAllowed
+}
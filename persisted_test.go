@@ -0,0 +1,138 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsvs/base/pkg/cache"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPersistedDaemon(t *testing.T, allowList bool) (*Daemon, QueryStore) {
+	conn, err := cache.NewMemoryConnection()
+	assert.Nil(t, err)
+	schema := newRedirectSchema(nil)
+	d := &Daemon{schema: &schema}
+	store := NewCacheQueryStore(conn, rpc.FGSGateway)
+	d.SetPersistedQueries(store, allowList)
+	return d, store
+}
+
+func TestAutomaticPersistedQuery(t *testing.T) {
+	d, _ := newPersistedDaemon(t, false)
+	query := "{ company { name } }"
+	hash := PersistedQueryHash(query)
+	extensions := `{"persistedQuery":{"version":1,"sha256Hash":"` + hash + `"}}`
+
+	// 只发送摘要, 未命中
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"extensions":`+extensions+`}`))
+	req, _ := readGraphqlRequest(r)
+	assert.Equal(t, errors.FGEPersistedQueryNotFound, d.resolvePersistedQuery(r, req))
+
+	// 发送完整查询后注册
+	r = httptest.NewRequest("POST", "/graphql",
+		strings.NewReader(`{"query":"`+query+`","extensions":`+extensions+`}`))
+	req, _ = readGraphqlRequest(r)
+	assert.Nil(t, d.resolvePersistedQuery(r, req))
+
+	// 摘要命中, 请求被改写为完整查询
+	r = httptest.NewRequest("GET", "/graphql?extensions="+extensions, nil)
+	req, _ = readGraphqlRequest(r)
+	assert.Nil(t, d.resolvePersistedQuery(r, req))
+	assert.Equal(t, "POST", r.Method)
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, `{"query":"`+query+`","variables":null,"operationName":""}`, string(body))
+
+	// 摘要与查询不一致
+	r = httptest.NewRequest("POST", "/graphql",
+		strings.NewReader(`{"query":"{ company { id } }","extensions":`+extensions+`}`))
+	req, _ = readGraphqlRequest(r)
+	assert.Equal(t, errors.FGEInvalidRequestParam, d.resolvePersistedQuery(r, req))
+}
+
+func TestPersistedQueryAllowList(t *testing.T) {
+	d, store := newPersistedDaemon(t, true)
+	query := "{ company { workers { company } } }"
+
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"`+query+`"}`))
+	req, _ := readGraphqlRequest(r)
+	assert.Equal(t, errors.FGEQueryNotAllowed, d.resolvePersistedQuery(r, req))
+
+	_, err := RegisterPersistedQuery(store, query)
+	assert.Nil(t, err)
+	assert.Nil(t, d.resolvePersistedQuery(r, req))
+}
+
+// APQ只注册到进程内缓存, 客户端请求不会写入store
+func TestPersistedQueryRegister(t *testing.T) {
+	d, store := newPersistedDaemon(t, false)
+	query := "{ company { title } }"
+	hash := PersistedQueryHash(query)
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(
+		`{"query":"`+query+`","extensions":{"persistedQuery":{"version":1,"sha256Hash":"`+hash+`"}}}`))
+	req, _ := readGraphqlRequest(r)
+	assert.Nil(t, d.resolvePersistedQuery(r, req))
+	_, err := store.Get(hash)
+	assert.Equal(t, errors.FGEPersistedQueryNotFound, err)
+	cached, ok := d.apqCache.get(hash)
+	assert.True(t, ok)
+	assert.Equal(t, query, cached)
+
+	// 摘要不合法
+	r = httptest.NewRequest("POST", "/graphql",
+		strings.NewReader(`{"extensions":{"persistedQuery":{"version":1,"sha256Hash":"../../dns"}}}`))
+	req, _ = readGraphqlRequest(r)
+	assert.Equal(t, errors.FGEInvalidRequestParam, d.resolvePersistedQuery(r, req))
+}
+
+func TestQueryLRU(t *testing.T) {
+	c := newQueryLRU(2)
+	c.set("a", "{a}", 0)
+	c.set("b", "{b}", 0)
+	c.get("a")
+	c.set("c", "", time.Millisecond)
+	_, ok := c.get("b")
+	assert.False(t, ok)
+	query, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "{a}", query)
+	time.Sleep(2 * time.Millisecond)
+	_, ok = c.get("c")
+	assert.False(t, ok)
+}
+
+// 客户端设置RPC协议头也不能绕过白名单
+func TestPersistedQueryAllowListRPC(t *testing.T) {
+	d, _ := newPersistedDaemon(t, true)
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ company { name } }"}`))
+	ctx := context.WithValue(context.Background(), rpc.KeyRawRequest, httptest.NewRequest("POST", "/graphql", nil))
+	assert.Nil(t, rpc.ContextToHTTPRequest(ctx, r))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	assert.Contains(t, w.Body.String(), strconv.Itoa(int(errors.FGEQueryNotAllowed)))
+}
+
+// 未知的协议头直接拒绝
+func TestPersistedQueryUnknownProtocal(t *testing.T) {
+	d, _ := newPersistedDaemon(t, false)
+	query := "{ company { name } }"
+	hash := PersistedQueryHash(query)
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(
+		`{"query":"`+query+`","extensions":{"persistedQuery":{"version":1,"sha256Hash":"`+hash+`"}}}`))
+	r.Header.Set(fmt.Sprintf("%d", rpc.KeyProtocalType), "grpc")
+	r.Header.Set("Content-Type", ContentTypeJSON)
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	assert.Contains(t, w.Body.String(), strconv.Itoa(int(errors.FGEInvalidRequestParam)))
+	_, ok := d.apqCache.get(hash)
+	assert.False(t, ok)
+}
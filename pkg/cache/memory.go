@@ -69,10 +69,10 @@ func (m *Memory) Set(key string, value interface{}) error {
 func (m *Memory) Get(key string) (interface{}, error) {
 	m.mutex.RLock()
 	value, ok := m.imap[key]
+	m.mutex.RUnlock()
 	if !ok {
 		return nil, KeyNotExist
 	}
-	m.mutex.RUnlock()
 	return value.elem, nil
 }
 
@@ -0,0 +1,173 @@
+package dataloader
+
+/*
+DataLoader: 合并同一个请求内对下游服务的重复调用
+1. 同一个等待周期(默认1ms)内Load的key合并为一次批量调用;
+2. 结果在请求生命周期内缓存, 同一个key只会加载一次;
+3. Load返回func() (interface{}, error), graphql-go会在同一层字段全部resolve以后再执行它,
+   所以列表中每一项的Load会被合并到同一次批量调用中.
+*/
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	KeyNotFound = errors.New("dataloader: key not found in batch result.")
+)
+
+// BatchFunc 批量加载函数, 返回key对应的结果, 不存在的key不需要返回
+type BatchFunc func(ctx context.Context, keys []string) (map[string]interface{}, error)
+
+// Options loader参数
+type Options struct {
+	// 合并key的等待时间
+	Wait time.Duration
+	// 单次批量调用的最大key数量, 0表示不限制
+	MaxBatch int
+}
+
+const defaultWait = time.Millisecond
+
+type result struct {
+	value interface{}
+	err   error
+	done  chan struct{}
+}
+
+type batch struct {
+	keys    []string
+	results map[string]*result
+	closed  bool
+}
+
+// Loader 按key批量加载并缓存结果
+type Loader struct {
+	fn      BatchFunc
+	options Options
+	mutex   sync.Mutex
+	cache   map[string]*result
+	current *batch
+}
+
+func NewLoader(fn BatchFunc, fns ...func(*Options)) *Loader {
+	var options = Options{Wait: defaultWait}
+	for _, f := range fns {
+		f(&options)
+	}
+	return &Loader{
+		fn:      fn,
+		options: options,
+		cache:   make(map[string]*result),
+	}
+}
+
+// Load 加载key对应的值, 返回的函数阻塞直到所在批次加载完成
+func (l *Loader) Load(ctx context.Context, key string) func() (interface{}, error) {
+	l.mutex.Lock()
+	if ret, ok := l.cache[key]; ok {
+		l.mutex.Unlock()
+		return ret.wait
+	}
+	ret := &result{done: make(chan struct{})}
+	l.cache[key] = ret
+	if l.current == nil {
+		l.current = &batch{results: make(map[string]*result)}
+		go l.dispatchAfter(ctx, l.current, l.options.Wait)
+	}
+	b := l.current
+	b.keys = append(b.keys, key)
+	b.results[key] = ret
+	if l.options.MaxBatch > 0 && len(b.keys) >= l.options.MaxBatch {
+		l.current = nil
+		b.closed = true
+		l.mutex.Unlock()
+		go l.dispatch(ctx, b)
+		return ret.wait
+	}
+	l.mutex.Unlock()
+	return ret.wait
+}
+
+// LoadMany 加载多个key, 按keys的顺序返回
+func (l *Loader) LoadMany(ctx context.Context, keys []string) ([]interface{}, error) {
+	thunks := make([]func() (interface{}, error), 0, len(keys))
+	for _, key := range keys {
+		thunks = append(thunks, l.Load(ctx, key))
+	}
+	values := make([]interface{}, 0, len(keys))
+	for _, thunk := range thunks {
+		value, err := thunk()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Prime 预先写入缓存, 已经存在的key不会被覆盖
+func (l *Loader) Prime(key string, value interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.cache[key]; ok {
+		return
+	}
+	ret := &result{value: value, done: make(chan struct{})}
+	close(ret.done)
+	l.cache[key] = ret
+}
+
+// Clear 删除key的缓存, 比如mutation修改了数据以后
+func (l *Loader) Clear(key string) {
+	l.mutex.Lock()
+	delete(l.cache, key)
+	l.mutex.Unlock()
+}
+
+func (l *Loader) dispatchAfter(ctx context.Context, b *batch, wait time.Duration) {
+	time.Sleep(wait)
+	l.mutex.Lock()
+	if b.closed {
+		l.mutex.Unlock()
+		return
+	}
+	b.closed = true
+	if l.current == b {
+		l.current = nil
+	}
+	l.mutex.Unlock()
+	l.dispatch(ctx, b)
+}
+
+func (l *Loader) dispatch(ctx context.Context, b *batch) {
+	values, err := l.fn(ctx, b.keys)
+	for key, ret := range b.results {
+		if err != nil {
+			ret.err = err
+		} else if value, ok := values[key]; ok {
+			ret.value = value
+		} else {
+			ret.err = KeyNotFound
+		}
+		close(ret.done)
+	}
+	// 失败的结果不缓存, 下次Load重新加载
+	if err != nil {
+		l.mutex.Lock()
+		for key, ret := range b.results {
+			if l.cache[key] == ret {
+				delete(l.cache, key)
+			}
+		}
+		l.mutex.Unlock()
+	}
+}
+
+func (r *result) wait() (interface{}, error) {
+	<-r.done
+	return r.value, r.err
+}
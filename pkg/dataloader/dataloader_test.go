@@ -0,0 +1,88 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoaderBatch(t *testing.T) {
+	var calls int32
+	loader := NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		values := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			if key != "missing" {
+				values[key] = "user-" + key
+			}
+		}
+		return values, nil
+	})
+	var wg sync.WaitGroup
+	for _, key := range []string{"1", "2", "3", "1"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := loader.Load(context.Background(), key)()
+			assert.Nil(t, err)
+			assert.Equal(t, "user-"+key, value)
+		}(key)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// cached
+	value, err := loader.Load(context.Background(), "2")()
+	assert.Nil(t, err)
+	assert.Equal(t, "user-2", value)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	_, err = loader.Load(context.Background(), "missing")()
+	assert.Equal(t, KeyNotFound, err)
+}
+
+func TestLoaderMaxBatch(t *testing.T) {
+	var calls int32
+	loader := NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		values := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			values[key] = key
+		}
+		return values, nil
+	}, func(opt *Options) {
+		opt.MaxBatch = 2
+	})
+	values, err := loader.LoadMany(context.Background(), []string{"a", "b", "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", "b", "c"}, values)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestLoaderErrorNotCached(t *testing.T) {
+	var calls int32
+	loader := NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, errors.New("unavailable")
+		}
+		return map[string]interface{}{"a": 1}, nil
+	})
+	_, err := loader.Load(context.Background(), "a")()
+	assert.NotNil(t, err)
+	value, err := loader.Load(context.Background(), "a")()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, value)
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := WithRegistry(context.Background())
+	fn := func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		return nil, nil
+	}
+	assert.True(t, For(ctx, "user", fn) == For(ctx, "user", fn))
+	assert.False(t, For(context.Background(), "user", fn) == For(context.Background(), "user", fn))
+}
@@ -0,0 +1,50 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+)
+
+type registryKey struct{}
+
+// registry 单个请求内的loader集合, 请求结束后随context释放
+type registry struct {
+	mutex   sync.Mutex
+	loaders map[string]*Loader
+}
+
+// WithRegistry 在context中创建请求级别的loader集合, 由Daemon.ServeHTTP调用
+func WithRegistry(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, registryKey{}, &registry{
+		loaders: make(map[string]*Loader),
+	})
+}
+
+// For 获取当前请求中name对应的loader, 不存在时使用fn创建
+// context中没有loader集合时返回一个新的loader, 只在本次调用内合并
+/* example
+loader := dataloader.For(p.Context, "order", func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	return getOrdersByIds(ctx, keys)
+})
+return loader.Load(p.Context, p.Args["order_id"].(string)), nil
+*/
+func For(ctx context.Context, name string, fn BatchFunc, fns ...func(*Options)) *Loader {
+	var reg *registry
+	if ctx != nil {
+		reg, _ = ctx.Value(registryKey{}).(*registry)
+	}
+	if reg == nil {
+		return NewLoader(fn, fns...)
+	}
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	if loader, ok := reg.loaders[name]; ok {
+		return loader
+	}
+	loader := NewLoader(fn, fns...)
+	reg.loaders[name] = loader
+	return loader
+}
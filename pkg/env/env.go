@@ -9,10 +9,13 @@ import (
 
 // ENV_NAME表示服务所在server相关环境变量
 /*
-APP_ZK = "127.0.0.1:2181" // 配置中心地址
+APP_ZK = "127.0.0.1:2181" // 配置中心地址, 支持zk://, etcd://, consul://, file:///path
 APP_NAME = "xxx"       // 服务名称
 APP_ENV = "ns-xxx-dev" // 开发环境
 APP_LOG = "/var/log/xxx // 日志目录
+APP_ZK_SNAPSHOT = "/var/log/xxx/xxx.kv.snapshot" // 配置中心不可用时使用的本地快照, 默认在日志目录
+APP_SECRET_KEY = "base64..." // 解密配置中心secret的主密钥, 32字节base64
+APP_SECRET_KEY_FILE = "/etc/xxx/secret.key" // 主密钥文件, 每行一个base64密钥, 第一行用于加密, 其他行用于轮换期间解密
 */
 type ENV_NAME string
 
@@ -23,6 +26,9 @@ const (
 	ServiceLog  ENV_NAME = "APP_LOG"
 	ServiceVer  ENV_NAME = "APP_VERSION"
 	TracerAgent ENV_NAME = "APP_TRACER_AGENT"
+	KVSnapshot  ENV_NAME = "APP_ZK_SNAPSHOT"
+	SecretKey   ENV_NAME = "APP_SECRET_KEY"
+	SecretFile  ENV_NAME = "APP_SECRET_KEY_FILE"
 )
 
 var (
@@ -41,6 +47,7 @@ func init() {
 		discovery     string
 		version       string
 		tracerAgent   string
+		snapshot      string
 	)
 	// register service name
 	registerMap(ServiceName, initServiceName())
@@ -72,6 +79,15 @@ func init() {
 		tracerAgent = defaultTracerAgent
 	}
 	registerMap(TracerAgent, tracerAgent)
+
+	if snapshot = os.Getenv(string(KVSnapshot)); len(snapshot) <= 0 {
+		snapshot = strings.TrimRight(logPathPrefix, "/") + "/" + envMap[ServiceName] + ".kv.snapshot"
+	}
+	registerMap(KVSnapshot, snapshot)
+
+	// 主密钥没有默认值
+	registerMap(SecretKey, os.Getenv(string(SecretKey)))
+	registerMap(SecretFile, os.Getenv(string(SecretFile)))
 }
 
 func initServiceName() string {
@@ -11,6 +11,10 @@ var (
 	ParamEmpty          = errors.New("param empty.")
 	EnvVarNotExist      = errors.New("env variable not exists.")
 	TracerIsNull        = errors.New("global tracer is null.")
+	SchemaBreaking      = errors.New("schema breaks fields used by consumers.")
+	SecretKeyNotFound   = errors.New("secret master key not found.")
+	SecretKeyMismatch   = errors.New("secret encrypted by unknown master key.")
+	SecretMalformed     = errors.New("secret value malformed.")
 )
 
 //FGErrorCode All API Errors
@@ -62,6 +66,10 @@ const (
 	FGEVehicleAlreadyBinded
 	FGEInvalidVehicleNo
 	FGEAdNameError
+	FGEQueryTooComplex
+	FGEPersistedQueryNotFound
+	FGEQueryNotAllowed
+	FGEUploadTooLarge
 )
 
 var FGErrorPrefix = "FGError:"
@@ -97,6 +105,11 @@ var evtDesc = map[FGErrorCode]string{
 	FGEExpiredVerifyCode:   "验证码错误或已超时",
 	FGESmsFrequence:        "验证码获取太频繁，请稍后再试",
 	FGEHTTPRPCError:        "内部微服务调用失败",
+	FGEQueryTooComplex:     "查询过于复杂",
+	// 与APQ协议一致, 客户端收到后重新发送完整查询
+	FGEPersistedQueryNotFound: "PersistedQueryNotFound",
+	FGEQueryNotAllowed:        "查询不在白名单中",
+	FGEUploadTooLarge:         "上传文件过大",
 }
 
 // return all errors
@@ -117,3 +130,12 @@ func (f FGErrorCode) Error() string {
 	//file, line := WhereAmI(6)
 	return fmt.Sprintf("%s%d:%s", FGErrorPrefix, int(f), f.String())
 }
+
+// Errorf 使用自定义描述生成错误, 格式与Error()一致
+/* example
+errors.FGEQueryTooComplex.Errorf("depth %d exceeds %d", 12, 10)
+--> "FGError:40023:depth 12 exceeds 10"
+*/
+func (f FGErrorCode) Errorf(format string, v ...interface{}) error {
+	return fmt.Errorf("%s%d:%s", FGErrorPrefix, int(f), fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,232 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/env"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+)
+
+// DefaultPath 默认的开关配置路径, 规则与discovery.KVRead一致, 即/APP_NAME/APP_VERSION/APP_ENV/flags
+// 值为JSON, key为开关名称
+/* example
+{
+	"new_checkout": {"enabled": true, "percentage": 30, "min_version": "2.3.0"},
+	"beta_map":     {"enabled": true, "percentage": 0, "allow": {"user": ["1001"], "appid": ["ops"]}},
+	"debug_trace":  {"enabled": true, "envs": ["developer", "test"]}
+}
+*/
+var DefaultPath = "flags"
+
+// RetryInterval 配置中心不可用时重新watch的间隔
+var RetryInterval = 5 * time.Second
+
+// Flag 单个开关的规则, 按顺序判断:
+//  1. enabled为false时关闭
+//  2. envs不为空且不包含当前环境时关闭
+//  3. allow中任意属性匹配时打开, 只支持经过认证的属性: user(登录用户), appid(签名校验通过)
+//  4. min_version不为空且客户端版本低于min_version时关闭
+//  5. percentage为空时打开, 否则按用户(没有用户时依次为device, appid)分桶, 同一用户结果固定
+type Flag struct {
+	Enabled    bool                `json:"enabled"`
+	Allow      map[string][]string `json:"allow,omitempty"`
+	Envs       []string            `json:"envs,omitempty"`
+	MinVersion string              `json:"min_version,omitempty"`
+	Percentage *float64            `json:"percentage,omitempty"`
+}
+
+// Attributes 判断开关使用的请求属性
+type Attributes struct {
+	UserID     string
+	AppID      string
+	AppVersion string
+	Device     string
+	Env        string
+}
+
+// FromContext 从请求context中读取属性, 由buildContext设置并通过rpc context传递给下游服务
+// appid为签名校验通过的appid, 版本和设备来自header X-App-Version和X-Device
+func FromContext(ctx context.Context) Attributes {
+	attrs := Attributes{}
+	attrs.Env, _ = env.Get(env.ServiceENV)
+	if ctx == nil {
+		return attrs
+	}
+	if user, ok := ctx.Value(rpc.KeyUser).(*types.User); ok && user != nil {
+		attrs.UserID = user.ID
+	}
+	attrs.AppID, _ = ctx.Value(rpc.KeyAppID).(string)
+	attrs.AppVersion, _ = ctx.Value(rpc.KeyAppVersion).(string)
+	attrs.Device, _ = ctx.Value(rpc.KeyDevice).(string)
+	return attrs
+}
+
+// Evaluate 按规则判断开关是否打开
+func (f *Flag) Evaluate(name string, attrs Attributes) bool {
+	if f == nil || !f.Enabled {
+		return false
+	}
+	if len(f.Envs) > 0 && !contains(f.Envs, attrs.Env) {
+		return false
+	}
+	for attr, values := range f.Allow {
+		if value := attrs.allowed(attr); len(value) > 0 && contains(values, value) {
+			return true
+		}
+	}
+	if len(f.MinVersion) > 0 && compareVersion(attrs.AppVersion, f.MinVersion) < 0 {
+		return false
+	}
+	if f.Percentage == nil {
+		return true
+	}
+	return bucket(name, attrs) < *f.Percentage
+}
+
+// allowed allow规则可以使用的属性, 客户端可以随意设置的device, version不能用于allow
+func (attrs Attributes) allowed(attr string) string {
+	switch attr {
+	case "user":
+		return attrs.UserID
+	case "appid":
+		return attrs.AppID
+	}
+	return ""
+}
+
+// bucket 返回[0, 100)的分桶, 不同开关的分桶互不相关
+func bucket(name string, attrs Attributes) float64 {
+	id := attrs.UserID
+	if len(id) <= 0 {
+		id = attrs.Device
+	}
+	if len(id) <= 0 {
+		id = attrs.AppID
+	}
+	return float64(crc32.ChecksumIEEE([]byte(name+":"+id))%10000) / 100
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersion 按点分隔的数字比较版本, 空版本最小
+func compareVersion(a, b string) int {
+	if len(a) <= 0 || len(b) <= 0 {
+		return len(a) - len(b)
+	}
+	as, bs := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// Set 一组开关, 配置变化时整体替换
+type Set struct {
+	path  string
+	flags atomic.Value // map[string]*Flag
+}
+
+// NewSet 读取path下的开关定义并watch变化, path规则与discovery.KVRead一致
+func NewSet(path string) *Set {
+	s := &Set{path: path}
+	s.flags.Store(map[string]*Flag{})
+	if err := s.Update([]byte(discovery.KVRead(path, ""))); err != nil {
+		log.ErrorRaw("[NewSet] parse flags %s failed. err=%s", path, err.Error())
+	}
+	go s.watch()
+	return s
+}
+
+func (s *Set) watch() {
+	for {
+		ch, err := discovery.WatchContext(context.Background(), s.path)
+		if err != nil {
+			time.Sleep(RetryInterval)
+			continue
+		}
+		for kvpair := range ch {
+			if err = s.Update(kvpair.Value); err != nil {
+				log.ErrorRaw("[Set.watch] keep old flags of %s. err=%s", s.path, err.Error())
+			}
+		}
+	}
+}
+
+// Update 替换全部开关, 解析失败时保留旧的开关
+func (s *Set) Update(data []byte) error {
+	flags := map[string]*Flag{}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &flags); err != nil {
+			return err
+		}
+	}
+	s.flags.Store(flags)
+	return nil
+}
+
+// Flags 当前所有开关
+func (s *Set) Flags() map[string]*Flag {
+	return s.flags.Load().(map[string]*Flag)
+}
+
+// Enabled 按请求属性判断开关是否打开, 开关不存在时返回false
+func (s *Set) Enabled(ctx context.Context, name string) bool {
+	return s.EnabledFor(name, FromContext(ctx))
+}
+
+// EnabledFor 按指定属性判断开关是否打开
+func (s *Set) EnabledFor(name string, attrs Attributes) bool {
+	return s.Flags()[name].Evaluate(name, attrs)
+}
+
+var (
+	defaultSet  *Set
+	defaultOnce sync.Once
+)
+
+// Default 使用DefaultPath的开关, 第一次调用时读取
+func Default() *Set {
+	defaultOnce.Do(func() {
+		defaultSet = NewSet(DefaultPath)
+	})
+	return defaultSet
+}
+
+// Enabled 在resolver中判断默认开关
+/* example
+Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+	if flags.Enabled(p.Context, "new_checkout") {
+		return newCheckout(p)
+	}
+	return checkout(p)
+}
+*/
+func Enabled(ctx context.Context, name string) bool {
+	return Default().Enabled(ctx, name)
+}
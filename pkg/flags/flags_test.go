@@ -0,0 +1,89 @@
+package flags
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	var (
+		half = 50.0
+		zero = 0.0
+	)
+	off := &Flag{Enabled: false, Allow: map[string][]string{"user": {"1001"}}}
+	assert.False(t, off.Evaluate("x", Attributes{UserID: "1001"}))
+
+	beta := &Flag{Enabled: true, Percentage: &zero, Allow: map[string][]string{"user": {"1001"}, "appid": {"ops"}}}
+	assert.True(t, beta.Evaluate("beta", Attributes{UserID: "1001"}))
+	assert.True(t, beta.Evaluate("beta", Attributes{UserID: "1002", AppID: "ops"}))
+	assert.False(t, beta.Evaluate("beta", Attributes{UserID: "1002"}))
+
+	// device和version由客户端设置, 不能用于allow; allow不能绕过envs
+	device := &Flag{Enabled: true, Percentage: &zero, Allow: map[string][]string{"device": {"d1"}, "version": {"9.9"}}}
+	assert.False(t, device.Evaluate("device", Attributes{Device: "d1", AppVersion: "9.9"}))
+	envs := &Flag{Enabled: true, Envs: []string{"test"}, Allow: map[string][]string{"user": {"1001"}}}
+	assert.False(t, envs.Evaluate("envs", Attributes{UserID: "1001", Env: "prod"}))
+	assert.True(t, envs.Evaluate("envs", Attributes{UserID: "1001", Env: "test"}))
+
+	version := &Flag{Enabled: true, MinVersion: "2.3.0", Envs: []string{"developer"}}
+	assert.True(t, version.Evaluate("v", Attributes{AppVersion: "2.10", Env: "developer"}))
+	assert.False(t, version.Evaluate("v", Attributes{AppVersion: "2.2.9", Env: "developer"}))
+	assert.False(t, version.Evaluate("v", Attributes{Env: "developer"}))
+	assert.False(t, version.Evaluate("v", Attributes{AppVersion: "2.3.0", Env: "prod"}))
+
+	// 同一用户结果固定, 整体比例接近percentage
+	rollout, enabled := &Flag{Enabled: true, Percentage: &half}, 0
+	for i := 0; i < 1000; i++ {
+		attrs := Attributes{UserID: strconv.Itoa(i)}
+		if rollout.Evaluate("rollout", attrs) {
+			enabled++
+		}
+		assert.Equal(t, rollout.Evaluate("rollout", attrs), rollout.Evaluate("rollout", attrs))
+	}
+	assert.InDelta(t, 500, enabled, 80)
+}
+
+func TestSet(t *testing.T) {
+	assert.Nil(t, discovery.Init(discovery.Options{Config: "memory://"}))
+	assert.Nil(t, discovery.KVWrite("/flags/test", `{"new_checkout": {"enabled": true, "min_version": "2.3.0"}}`))
+	s := NewSet("/flags/test")
+
+	// url参数和header中的appid没有经过校验, 不会使用
+	r := httptest.NewRequest("POST", "/graphql?appid=ops", nil)
+	r.Header.Set("X-App-ID", "ops")
+	ctx := context.WithValue(context.Background(), rpc.KeyRawRequest, r)
+	ctx = context.WithValue(ctx, rpc.KeyUser, &types.User{ID: "1001"})
+	ctx = context.WithValue(ctx, rpc.KeyAppID, "ios")
+	ctx = context.WithValue(ctx, rpc.KeyAppVersion, "2.4.1")
+	attrs := FromContext(ctx)
+	assert.Equal(t, "1001", attrs.UserID)
+	assert.Equal(t, "ios", attrs.AppID)
+	assert.Equal(t, "2.4.1", attrs.AppVersion)
+	assert.True(t, s.Enabled(ctx, "new_checkout"))
+	assert.False(t, s.Enabled(ctx, "unknown"))
+
+	// 下游服务通过rpc context读取
+	down := httptest.NewRequest("POST", "/graphql", nil)
+	assert.Nil(t, rpc.ContextToHTTPRequest(ctx, down))
+	downCtx, err := rpc.ContextFromHTTPRequest(nil, down)
+	assert.Nil(t, err)
+	assert.Equal(t, attrs, FromContext(downCtx))
+	assert.True(t, s.Enabled(downCtx, "new_checkout"))
+
+	// 热更新, 格式错误时保留旧值
+	assert.Nil(t, discovery.KVWrite("/flags/test", `{"new_checkout": {"enabled": false}}`))
+	assert.Eventually(t, func() bool {
+		return !s.Enabled(ctx, "new_checkout")
+	}, time.Second, 10*time.Millisecond)
+	assert.NotNil(t, s.Update([]byte("{")))
+	assert.False(t, s.Enabled(ctx, "new_checkout"))
+	assert.Len(t, s.Flags(), 1)
+}
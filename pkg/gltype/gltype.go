@@ -0,0 +1,433 @@
+// Package gltype 根据Go结构体生成graphql类型, 避免结构体和graphql配置分别维护
+//
+// map, interface{}等不支持的字段类型会被跳过并记录日志, enum=引用未注册的枚举时返回错误
+// graphql的Int只有32位, int64和uint, uint32, uint64映射为Int64标量, 与ID一样序列化为字符串
+// 字段名使用json tag, graphql tag设置字段属性, 多个属性用逗号分隔, desc和deprecated的值可以包含逗号:
+//
+//	type Order struct {
+//		ID     string    `json:"id" graphql:"nonnull,desc=订单ID"`
+//		Status int       `json:"status" graphql:"enum=OrderStatus"`
+//		Items  []Item    `json:"items"`
+//		Parent *Order    `json:"parent"`
+//		Remark string    `json:"remark" graphql:"deprecated=不再使用"`
+//		Secret string    `json:"secret" graphql:"-"`
+//	}
+package gltype
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/microsvs/base/pkg/log"
+)
+
+// Namer 结构体或者结构体指针实现该接口时使用GraphQLName作为类型名, 否则使用Go类型名
+type Namer interface {
+	GraphQLName() string
+}
+
+var namerType = reflect.TypeOf((*Namer)(nil)).Elem()
+
+// Registry 按Go类型缓存生成的graphql类型, 同一个类型只生成一次, 相互引用的类型通过thunk延迟生成字段
+type Registry struct {
+	mutex   sync.Mutex
+	objects map[reflect.Type]*graphql.Object
+	inputs  map[reflect.Type]*graphql.InputObject
+	scalars map[reflect.Type]*graphql.Scalar
+	enums   map[reflect.Type]*graphql.Enum
+	names   map[string]*graphql.Enum
+}
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		objects: make(map[reflect.Type]*graphql.Object),
+		inputs:  make(map[reflect.Type]*graphql.InputObject),
+		scalars: map[reflect.Type]*graphql.Scalar{
+			reflect.TypeOf(time.Time{}): graphql.DateTime,
+		},
+		enums: make(map[reflect.Type]*graphql.Enum),
+		names: make(map[string]*graphql.Enum),
+	}
+}
+
+// Object 生成v对应的对象类型, v为结构体或者结构体指针
+// v不是结构体, 或者v及其引用的结构体中enum=引用了未注册的枚举时返回错误
+/* example
+GLOrder, err := gltype.Object(Order{})
+if err != nil {
+	log.ErrorRaw("build graphql type failed. err=%s", err.Error())
+}
+*/
+func Object(v interface{}) (*graphql.Object, error) {
+	return DefaultRegistry.Object(v)
+}
+
+// InputObject 生成v对应的输入类型, 类型名为Object类型名加Input
+func InputObject(v interface{}) (*graphql.InputObject, error) {
+	return DefaultRegistry.InputObject(v)
+}
+
+// Enum 生成枚举类型, v为枚举值的Go类型, 该类型的字段自动使用此枚举
+func Enum(v interface{}, name string, values map[string]interface{}) *graphql.Enum {
+	return DefaultRegistry.Enum(v, name, values)
+}
+
+// RegisterEnum 注册已有的枚举类型, 可以通过enum=Name引用, v不为空时该Go类型的字段自动使用此枚举
+func RegisterEnum(enum *graphql.Enum, v interface{}) {
+	DefaultRegistry.RegisterEnum(enum, v)
+}
+
+// RegisterScalar Go类型映射为自定义标量, time.Time默认映射为DateTime
+func RegisterScalar(v interface{}, scalar *graphql.Scalar) {
+	DefaultRegistry.RegisterScalar(v, scalar)
+}
+
+func (r *Registry) Object(v interface{}) (*graphql.Object, error) {
+	t, err := r.structType(v)
+	if err != nil {
+		return nil, err
+	}
+	return r.object(t), nil
+}
+
+func (r *Registry) InputObject(v interface{}) (*graphql.InputObject, error) {
+	t, err := r.structType(v)
+	if err != nil {
+		return nil, err
+	}
+	return r.inputObject(t), nil
+}
+
+func (r *Registry) Enum(v interface{}, name string, values map[string]interface{}) *graphql.Enum {
+	config := graphql.EnumValueConfigMap{}
+	for key, value := range values {
+		config[key] = &graphql.EnumValueConfig{Value: value}
+	}
+	enum := graphql.NewEnum(graphql.EnumConfig{Name: name, Values: config})
+	r.RegisterEnum(enum, v)
+	return enum
+}
+
+func (r *Registry) RegisterEnum(enum *graphql.Enum, v interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.names[enum.Name()] = enum
+	if v != nil {
+		r.enums[reflect.TypeOf(v)] = enum
+	}
+}
+
+func (r *Registry) RegisterScalar(v interface{}, scalar *graphql.Scalar) {
+	r.mutex.Lock()
+	r.scalars[reflect.TypeOf(v)] = scalar
+	r.mutex.Unlock()
+}
+
+// structType v对应的结构体类型, 同时检查字段引用的枚举
+func (r *Registry) structType(v interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("gltype: %v is not a struct", t)
+	}
+	return t, r.checkEnums(t, make(map[reflect.Type]bool))
+}
+
+// checkEnums 字段在thunk中延迟生成, 提前检查t及其引用的结构体中enum=引用的枚举都已经注册
+func (r *Registry) checkEnums(t reflect.Type, visited map[reflect.Type]bool) error {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+	var err error
+	r.eachField(t, func(name string, ft reflect.Type, tag fieldTag) {
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+		}
+		r.mutex.Lock()
+		_, isEnum := r.names[tag.enum]
+		_, isScalar := r.scalars[ft]
+		r.mutex.Unlock()
+		switch {
+		case err != nil:
+		case len(tag.enum) > 0 && !isEnum:
+			err = fmt.Errorf("gltype: enum %s of %s.%s is not registered", tag.enum, t.Name(), name)
+		case len(tag.enum) <= 0 && !isScalar && ft.Kind() == reflect.Struct:
+			err = r.checkEnums(ft, visited)
+		}
+	})
+	return err
+}
+
+func typeName(t reflect.Type) string {
+	if t.Implements(namerType) {
+		return reflect.Zero(t).Interface().(Namer).GraphQLName()
+	}
+	if reflect.PtrTo(t).Implements(namerType) {
+		return reflect.New(t).Interface().(Namer).GraphQLName()
+	}
+	return t.Name()
+}
+
+func (r *Registry) object(t reflect.Type) *graphql.Object {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if obj, ok := r.objects[t]; ok {
+		return obj
+	}
+	obj := graphql.NewObject(graphql.ObjectConfig{
+		Name: typeName(t),
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			fields := graphql.Fields{}
+			r.eachField(t, func(name string, ft reflect.Type, tag fieldTag) {
+				typ := r.outputType(ft, tag)
+				if typ == nil {
+					log.ErrorRaw("[gltype.Object] skip field %s.%s, unsupported type %v", t.Name(), name, ft)
+					return
+				}
+				fields[name] = &graphql.Field{
+					Type:              tag.wrap(typ).(graphql.Output),
+					Description:       tag.desc,
+					DeprecationReason: tag.deprecated,
+				}
+			})
+			return fields
+		}),
+	})
+	r.objects[t] = obj
+	return obj
+}
+
+func (r *Registry) inputObject(t reflect.Type) *graphql.InputObject {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if obj, ok := r.inputs[t]; ok {
+		return obj
+	}
+	obj := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: typeName(t) + "Input",
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			fields := graphql.InputObjectConfigFieldMap{}
+			r.eachField(t, func(name string, ft reflect.Type, tag fieldTag) {
+				typ := r.inputType(ft, tag)
+				if typ == nil {
+					log.ErrorRaw("[gltype.InputObject] skip field %s.%s, unsupported type %v", t.Name(), name, ft)
+					return
+				}
+				fields[name] = &graphql.InputObjectFieldConfig{
+					Type:        tag.wrap(typ).(graphql.Input),
+					Description: tag.desc,
+				}
+			})
+			return fields
+		}),
+	})
+	r.inputs[t] = obj
+	return obj
+}
+
+// eachField 遍历导出字段, 匿名结构体的字段与encoding/json一样展开
+func (r *Registry) eachField(t reflect.Type, fn func(name string, ft reflect.Type, tag fieldTag)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.Tag.Get("graphql") == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				r.eachField(ft, fn)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fn(name, field.Type, parseTag(field.Tag.Get("graphql")))
+	}
+}
+
+// outputType 不支持的类型(map, interface{}, chan等)返回nil
+func (r *Registry) outputType(t reflect.Type, tag fieldTag) graphql.Output {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return r.outputType(t.Elem(), tag)
+	case reflect.Slice, reflect.Array:
+		if elem := r.outputType(t.Elem(), fieldTag{enum: tag.enum}); elem != nil {
+			return graphql.NewList(elem)
+		}
+		return nil
+	}
+	if typ := r.leafType(t, tag); typ != nil {
+		return typ.(graphql.Output)
+	}
+	if t.Kind() == reflect.Struct {
+		return r.object(t)
+	}
+	return nil
+}
+
+// inputType 不支持的类型返回nil
+func (r *Registry) inputType(t reflect.Type, tag fieldTag) graphql.Input {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return r.inputType(t.Elem(), tag)
+	case reflect.Slice, reflect.Array:
+		if elem := r.inputType(t.Elem(), fieldTag{enum: tag.enum}); elem != nil {
+			return graphql.NewList(elem)
+		}
+		return nil
+	}
+	if typ := r.leafType(t, tag); typ != nil {
+		return typ.(graphql.Input)
+	}
+	if t.Kind() == reflect.Struct {
+		return r.inputObject(t)
+	}
+	return nil
+}
+
+// leafType 枚举和标量类型, 不是叶子类型时返回nil
+func (r *Registry) leafType(t reflect.Type, tag fieldTag) graphql.Type {
+	r.mutex.Lock()
+	scalar, isScalar := r.scalars[t]
+	enum, isEnum := r.enums[t]
+	if len(tag.enum) > 0 {
+		// Object和InputObject已经检查过, 枚举不存在时当作不支持的类型
+		if enum, isEnum = r.names[tag.enum]; !isEnum {
+			r.mutex.Unlock()
+			return nil
+		}
+	}
+	r.mutex.Unlock()
+	switch {
+	case isEnum:
+		return enum
+	case isScalar:
+		return scalar
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return graphql.String
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return graphql.Int
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return Int64
+	case reflect.Float32, reflect.Float64:
+		return graphql.Float
+	}
+	return nil
+}
+
+// Int64 超出graphql Int(32位)范围的整数, 与ID一样序列化为字符串, 输入可以是字符串或者整数
+var Int64 = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Int64",
+	Description: "64位整数, 序列化为字符串",
+	Serialize: func(value interface{}) interface{} {
+		v := reflect.ValueOf(value)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(v.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return strconv.FormatUint(v.Uint(), 10)
+		case reflect.String:
+			return v.String()
+		}
+		return nil
+	},
+	ParseValue: func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			return parseInt64(v)
+		case float64:
+			// json解析出的数字为float64
+			return parseInt64(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		return nil
+	},
+	ParseLiteral: func(value ast.Value) interface{} {
+		switch v := value.(type) {
+		case *ast.StringValue:
+			return parseInt64(v.Value)
+		case *ast.IntValue:
+			return parseInt64(v.Value)
+		}
+		return nil
+	},
+})
+
+// parseInt64 超出int64范围的正数解析为uint64, 不是整数时返回nil
+func parseInt64(s string) interface{} {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n
+	}
+	return nil
+}
+
+type fieldTag struct {
+	nonNull    bool
+	desc       string
+	deprecated string
+	enum       string
+}
+
+// parseTag 解析graphql tag, 比如: nonnull,desc=用户ID, 手机号登录,enum=BasicUserStatus
+// 不是属性名的部分属于前一个desc或者deprecated的值
+func parseTag(tag string) fieldTag {
+	var (
+		ret  fieldTag
+		text *string // 正在解析的desc或者deprecated
+	)
+	for _, item := range strings.Split(tag, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		var value string
+		if len(kv) > 1 {
+			value = kv[1]
+		}
+		switch {
+		case kv[0] == "nonnull" && len(kv) == 1:
+			ret.nonNull, text = true, nil
+		case kv[0] == "desc" && len(kv) > 1:
+			ret.desc, text = value, &ret.desc
+		case kv[0] == "deprecated" && len(kv) > 1:
+			ret.deprecated, text = value, &ret.deprecated
+		case kv[0] == "enum" && len(kv) > 1:
+			ret.enum, text = value, nil
+		case text != nil:
+			*text += "," + item
+		}
+	}
+	return ret
+}
+
+func (tag fieldTag) wrap(typ graphql.Type) graphql.Type {
+	if tag.nonNull {
+		return graphql.NewNonNull(typ)
+	}
+	return typ
+}
@@ -0,0 +1,174 @@
+package gltype
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+type orderStatus int
+
+type base struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type order struct {
+	base
+	ID       string      `json:"id" graphql:"nonnull,desc=订单ID"`
+	Status   orderStatus `json:"status"`
+	Level    int         `json:"level" graphql:"enum=OrderLevel"`
+	Tags     []string    `json:"tags"`
+	Parent   *order      `json:"parent"`
+	Remark   string      `json:"remark" graphql:"deprecated=不再使用"`
+	Secret   string      `json:"-"`
+	internal string
+}
+
+func (order) GraphQLName() string { return "Order" }
+
+func TestObject(t *testing.T) {
+	r := NewRegistry()
+	r.Enum(orderStatus(0), "OrderStatus", map[string]interface{}{"Paid": orderStatus(10), "Closed": orderStatus(20)})
+	r.Enum(nil, "OrderLevel", map[string]interface{}{"Normal": 1, "VIP": 2})
+	obj, err := r.Object(&order{})
+	assert.Nil(t, err)
+	same, _ := r.Object(order{})
+	assert.Equal(t, obj, same)
+	input, err := r.InputObject(order{})
+	assert.Nil(t, err)
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"order": &graphql.Field{
+					Type: obj,
+					Args: graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: input}},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return &order{ID: "1", Status: 10, Level: 2, Tags: []string{"a"}, Parent: &order{ID: "0"}}, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	fields := obj.Fields()
+	assert.Equal(t, []string{"created_at", "id", "level", "parent", "remark", "status", "tags"}, sortedKeys(fields))
+	assert.Equal(t, "String!", fields["id"].Type.String())
+	assert.Equal(t, "订单ID", fields["id"].Description)
+	assert.Equal(t, "不再使用", fields["remark"].DeprecationReason)
+	assert.Equal(t, "DateTime", fields["created_at"].Type.String())
+	assert.Equal(t, "Order", fields["parent"].Type.String())
+	assert.Equal(t, "[String]", fields["tags"].Type.String())
+	assert.Equal(t, "OrderInput", schema.Type("OrderInput").Name())
+
+	result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ order { id status level tags parent { id } } }"})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"order": map[string]interface{}{
+		"id": "1", "status": "Paid", "level": "VIP", "tags": []interface{}{"a"},
+		"parent": map[string]interface{}{"id": "0"},
+	}}, result.Data)
+}
+
+func sortedKeys(fields graphql.FieldDefinitionMap) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type payment struct {
+	ID     string              `json:"id" graphql:"desc=支付ID, 第三方流水号,nonnull"`
+	Extra  map[string]string   `json:"extra"`
+	Raw    interface{}         `json:"raw"`
+	Items  []map[string]string `json:"items"`
+	Amount int                 `json:"amount" graphql:"deprecated=使用amounts, 按币种区分"`
+}
+
+func (*payment) GraphQLName() string { return "Payment" }
+
+// 不支持的字段类型被跳过, 指针接收者实现Namer
+func TestObjectSkipUnsupported(t *testing.T) {
+	r := NewRegistry()
+	obj, err := r.Object(payment{})
+	assert.Nil(t, err)
+	assert.Equal(t, "Payment", obj.Name())
+	fields := obj.Fields()
+	assert.Equal(t, []string{"amount", "id"}, sortedKeys(fields))
+	assert.Equal(t, "String!", fields["id"].Type.String())
+	assert.Equal(t, "支付ID, 第三方流水号", fields["id"].Description)
+	assert.Equal(t, "使用amounts, 按币种区分", fields["amount"].DeprecationReason)
+
+	input, err := r.InputObject(&payment{})
+	assert.Nil(t, err)
+	assert.Equal(t, "PaymentInput", input.Name())
+	assert.Len(t, input.Fields(), 2)
+}
+
+func TestParseTag(t *testing.T) {
+	assert.Equal(t, fieldTag{nonNull: true, desc: "a,b", enum: "E"}, parseTag("desc=a,b,nonnull,enum=E"))
+	assert.Equal(t, fieldTag{desc: "nonnull=1"}, parseTag("desc=nonnull=1"))
+	assert.Equal(t, fieldTag{}, parseTag("unknown"))
+}
+
+type account struct {
+	ID      int64   `json:"id"`
+	Balance uint64  `json:"balance"`
+	Owner   *uint32 `json:"owner"`
+	Level   int32   `json:"level"`
+}
+
+// 64位和无符号整数映射为Int64, 序列化为字符串
+func TestObjectInt64(t *testing.T) {
+	r := NewRegistry()
+	obj, err := r.Object(account{})
+	assert.Nil(t, err)
+	fields := obj.Fields()
+	assert.Equal(t, "Int64", fields["id"].Type.String())
+	assert.Equal(t, "Int64", fields["balance"].Type.String())
+	assert.Equal(t, "Int", fields["level"].Type.String())
+
+	owner := uint32(4294967295)
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"account": &graphql.Field{
+					Type: obj,
+					Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: Int64}},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return &account{ID: p.Args["id"].(int64), Balance: 18446744073709551615, Owner: &owner}, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	result := graphql.Do(graphql.Params{Schema: schema, RequestString: `{ account(id: "9007199254740993") { id balance owner } }`})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"account": map[string]interface{}{
+		"id": "9007199254740993", "balance": "18446744073709551615", "owner": "4294967295",
+	}}, result.Data)
+}
+
+type refund struct {
+	Order  order `json:"order"`
+	Status int   `json:"status" graphql:"enum=RefundStatus"`
+}
+
+// enum=引用未注册的枚举时返回错误, 嵌套的结构体同样检查
+func TestObjectUnknownEnum(t *testing.T) {
+	r := NewRegistry()
+	r.Enum(orderStatus(0), "OrderStatus", map[string]interface{}{"Paid": orderStatus(10)})
+	_, err := r.Object(refund{})
+	assert.NotNil(t, err)
+	_, err = r.InputObject(order{})
+	assert.EqualError(t, err, "gltype: enum OrderLevel of order.level is not registered")
+	_, err = r.Object(1)
+	assert.NotNil(t, err)
+}
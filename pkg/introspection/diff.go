@@ -0,0 +1,235 @@
+package introspection
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Level schema变更的影响程度
+type Level string
+
+const (
+	Breaking  Level = "BREAKING"  // 已有的请求会失败
+	Dangerous Level = "DANGEROUS" // 已有的请求不会失败, 但是客户端可能无法正确处理, 比如: 新增枚举值
+	Safe      Level = "SAFE"
+)
+
+// Change 一项schema变更, Path为类型、字段或者参数的路径, 比如: Query.user.id
+type Change struct {
+	Level   Level
+	Path    string
+	Message string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%-9s %s: %s", c.Level, c.Path, c.Message)
+}
+
+// HasBreaking 是否包含不兼容的变更
+func HasBreaking(changes []Change) bool {
+	for _, change := range changes {
+		if change.Level == Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff 比较新旧schema, 返回按路径排序的变更列表
+/* example
+changes := introspection.Diff(old, cur)
+if introspection.HasBreaking(changes) {
+	os.Exit(1)
+}
+*/
+func Diff(old, cur *Schema) []Change {
+	var d differ
+	d.root("query", old.QueryType, cur.QueryType)
+	d.root("mutation", old.MutationType, cur.MutationType)
+	d.root("subscription", old.SubscriptionType, cur.SubscriptionType)
+	for _, ot := range old.Types {
+		if IsBuiltin(ot.Name) {
+			continue
+		}
+		nt := cur.Type(ot.Name)
+		switch {
+		case nt == nil:
+			d.add(Breaking, ot.Name, "type removed")
+		case nt.Kind != ot.Kind:
+			d.add(Breaking, ot.Name, fmt.Sprintf("kind changed from %s to %s", ot.Kind, nt.Kind))
+		default:
+			d.typ(ot, nt)
+		}
+	}
+	for _, nt := range cur.Types {
+		if !IsBuiltin(nt.Name) && old.Type(nt.Name) == nil {
+			d.add(Safe, nt.Name, "type added")
+		}
+	}
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		return d.changes[i].Path < d.changes[j].Path
+	})
+	return d.changes
+}
+
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) add(level Level, path, message string) {
+	d.changes = append(d.changes, Change{Level: level, Path: path, Message: message})
+}
+
+func (d *differ) root(op string, old, cur *TypeName) {
+	switch {
+	case old != nil && cur == nil:
+		d.add(Breaking, "schema."+op, "root type removed")
+	case old == nil && cur != nil:
+		d.add(Safe, "schema."+op, "root type added")
+	case old != nil && old.Name != cur.Name:
+		d.add(Breaking, "schema."+op, fmt.Sprintf("root type changed from %s to %s", old.Name, cur.Name))
+	}
+}
+
+func (d *differ) typ(old, cur *Type) {
+	switch old.Kind {
+	case KindObject, KindInterface:
+		d.fields(old, cur)
+		d.members(old.Name, "interface", old.Interfaces, cur.Interfaces, Dangerous)
+	case KindUnion:
+		d.members(old.Name, "member", old.PossibleTypes, cur.PossibleTypes, Dangerous)
+	case KindEnum:
+		d.enumValues(old, cur)
+	case KindInputObject:
+		d.inputValues(old.Name, "input field", old.InputFields, cur.InputFields, Dangerous)
+	}
+}
+
+func (d *differ) fields(old, cur *Type) {
+	for _, of := range old.Fields {
+		path := old.Name + "." + of.Name
+		nf := cur.Field(of.Name)
+		if nf == nil {
+			d.add(Breaking, path, "field removed")
+			continue
+		}
+		if !safeOutputChange(of.Type, nf.Type) {
+			d.add(Breaking, path, fmt.Sprintf("type changed from %s to %s", of.Type, nf.Type))
+		} else if of.Type.String() != nf.Type.String() {
+			d.add(Safe, path, fmt.Sprintf("type changed from %s to %s", of.Type, nf.Type))
+		}
+		if !of.IsDeprecated && nf.IsDeprecated {
+			d.add(Safe, path, "field deprecated")
+		}
+		d.inputValues(path, "argument", of.Args, nf.Args, Safe)
+	}
+	for _, nf := range cur.Fields {
+		if old.Field(nf.Name) == nil {
+			d.add(Safe, old.Name+"."+nf.Name, "field added")
+		}
+	}
+}
+
+// inputValues 比较参数或者输入字段, 新增可选项的影响程度由optional指定
+func (d *differ) inputValues(parent, what string, old, cur []*InputValue, optional Level) {
+	find := func(values []*InputValue, name string) *InputValue {
+		for _, value := range values {
+			if value.Name == name {
+				return value
+			}
+		}
+		return nil
+	}
+	for _, ov := range old {
+		path := parent + "." + ov.Name
+		nv := find(cur, ov.Name)
+		if nv == nil {
+			d.add(Breaking, path, what+" removed")
+			continue
+		}
+		if !safeInputChange(ov.Type, nv.Type) {
+			d.add(Breaking, path, fmt.Sprintf("type changed from %s to %s", ov.Type, nv.Type))
+		} else if ov.Type.String() != nv.Type.String() {
+			d.add(Safe, path, fmt.Sprintf("type changed from %s to %s", ov.Type, nv.Type))
+		}
+		if ov.DefaultValue != nil && (nv.DefaultValue == nil || *ov.DefaultValue != *nv.DefaultValue) {
+			d.add(Dangerous, path, "default value changed")
+		}
+	}
+	for _, nv := range cur {
+		if find(old, nv.Name) != nil {
+			continue
+		}
+		if nv.Type.IsNonNull() && nv.DefaultValue == nil {
+			d.add(Breaking, parent+"."+nv.Name, "required "+what+" added")
+		} else {
+			d.add(optional, parent+"."+nv.Name, what+" added")
+		}
+	}
+}
+
+func (d *differ) enumValues(old, cur *Type) {
+	values := make(map[string]bool)
+	for _, value := range cur.EnumValues {
+		values[value.Name] = true
+	}
+	for _, value := range old.EnumValues {
+		if !values[value.Name] {
+			d.add(Breaking, old.Name+"."+value.Name, "enum value removed")
+		}
+		delete(values, value.Name)
+	}
+	for _, value := range cur.EnumValues {
+		if values[value.Name] {
+			d.add(Dangerous, old.Name+"."+value.Name, "enum value added")
+		}
+	}
+}
+
+// members 比较接口实现或者union成员, 删除为不兼容变更
+func (d *differ) members(parent, what string, old, cur []*TypeRef, added Level) {
+	names := make(map[string]bool)
+	for _, ref := range cur {
+		names[ref.Name] = true
+	}
+	for _, ref := range old {
+		if !names[ref.Name] {
+			d.add(Breaking, parent, fmt.Sprintf("%s %s removed", what, ref.Name))
+		}
+		delete(names, ref.Name)
+	}
+	for _, ref := range cur {
+		if names[ref.Name] {
+			d.add(added, parent, fmt.Sprintf("%s %s added", what, ref.Name))
+		}
+	}
+}
+
+// safeOutputChange 返回值类型变更是否兼容, 可以增加非空限制
+func safeOutputChange(old, cur *TypeRef) bool {
+	switch old.Kind {
+	case KindNonNull:
+		return cur.Kind == KindNonNull && safeOutputChange(old.OfType, cur.OfType)
+	case KindList:
+		return (cur.Kind == KindList && safeOutputChange(old.OfType, cur.OfType)) ||
+			(cur.Kind == KindNonNull && safeOutputChange(old, cur.OfType))
+	}
+	if cur.Kind == KindNonNull {
+		return safeOutputChange(old, cur.OfType)
+	}
+	return cur.Kind != KindList && old.Name == cur.Name
+}
+
+// safeInputChange 参数类型变更是否兼容, 可以去掉非空限制
+func safeInputChange(old, cur *TypeRef) bool {
+	switch old.Kind {
+	case KindNonNull:
+		if cur.Kind == KindNonNull {
+			return safeInputChange(old.OfType, cur.OfType)
+		}
+		return safeInputChange(old.OfType, cur)
+	case KindList:
+		return cur.Kind == KindList && safeInputChange(old.OfType, cur.OfType)
+	}
+	return cur.Kind != KindList && cur.Kind != KindNonNull && old.Name == cur.Name
+}
@@ -0,0 +1,92 @@
+package introspection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const oldSDL = `
+"用户"
+type User {
+  id: ID!
+  name: String
+  mobile: String
+  status: Status
+}
+
+enum Status {
+  OK
+  INVALID
+}
+
+input UserFilter {
+  status: Status
+}
+
+type Query {
+  user(id: ID!, detail: Boolean = false): User
+  users(filter: UserFilter): [User]
+}
+`
+
+const newSDL = `
+"用户"
+type User {
+  id: ID!
+  name: String!
+  status: Status
+  email: String @deprecated(reason: "use contact")
+}
+
+enum Status {
+  OK
+  INVALID
+  DELETED
+}
+
+input UserFilter {
+  status: Status
+  keyword: String
+}
+
+type Query {
+  user(id: ID, detail: Boolean = true, tenant: String!): User
+  users(filter: UserFilter): [User]
+}
+`
+
+func TestSDLRoundTrip(t *testing.T) {
+	schema, err := FromSDL(newSDL)
+	assert.Nil(t, err)
+	sdl := schema.SDL()
+	again, err := FromSDL(sdl)
+	assert.Nil(t, err)
+	assert.Equal(t, sdl, again.SDL())
+	assert.Equal(t, schema.Hash(), again.Hash())
+	assert.Contains(t, sdl, `"用户"
+type User {
+  email: String @deprecated(reason: "use contact")
+  id: ID!`)
+}
+
+func TestDiff(t *testing.T) {
+	old, _ := FromSDL(oldSDL)
+	cur, _ := FromSDL(newSDL)
+	var got []string
+	for _, change := range Diff(old, cur) {
+		got = append(got, change.String())
+	}
+	assert.Equal(t, []string{
+		"DANGEROUS Query.user.detail: default value changed",
+		"SAFE      Query.user.id: type changed from ID! to ID",
+		"BREAKING  Query.user.tenant: required argument added",
+		"DANGEROUS Status.DELETED: enum value added",
+		"SAFE      User.email: field added",
+		"BREAKING  User.mobile: field removed",
+		"SAFE      User.name: type changed from String to String!",
+		"DANGEROUS UserFilter.keyword: input field added",
+	}, got)
+	assert.True(t, HasBreaking(Diff(old, cur)))
+	assert.False(t, HasBreaking(Diff(cur, cur)))
+}
@@ -0,0 +1,221 @@
+package introspection
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/utils"
+)
+
+// type kind, 与graphql introspection中__TypeKind一致
+const (
+	KindScalar      = "SCALAR"
+	KindObject      = "OBJECT"
+	KindInterface   = "INTERFACE"
+	KindUnion       = "UNION"
+	KindEnum        = "ENUM"
+	KindInputObject = "INPUT_OBJECT"
+	KindList        = "LIST"
+	KindNonNull     = "NON_NULL"
+)
+
+// Query 标准的introspection查询语句
+const Query = `
+query IntrospectionQuery {
+	__schema {
+		queryType { name }
+		mutationType { name }
+		subscriptionType { name }
+		types { ...FullType }
+	}
+}
+fragment FullType on __Type {
+	kind
+	name
+	description
+	fields(includeDeprecated: true) {
+		name
+		description
+		args { ...InputValue }
+		type { ...TypeRef }
+		isDeprecated
+		deprecationReason
+	}
+	inputFields { ...InputValue }
+	interfaces { ...TypeRef }
+	enumValues(includeDeprecated: true) {
+		name
+		description
+		isDeprecated
+		deprecationReason
+	}
+	possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue {
+	name
+	description
+	type { ...TypeRef }
+	defaultValue
+}
+fragment TypeRef on __Type {
+	kind
+	name
+	ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}
+`
+
+// Schema introspection返回的schema描述, 也可以由SDL解析得到
+type Schema struct {
+	QueryType        *TypeName `json:"queryType"`
+	MutationType     *TypeName `json:"mutationType"`
+	SubscriptionType *TypeName `json:"subscriptionType"`
+	Types            []*Type   `json:"types"`
+}
+
+type TypeName struct {
+	Name string `json:"name"`
+}
+
+type Type struct {
+	Kind          string        `json:"kind"`
+	Name          string        `json:"name"`
+	Description   string        `json:"description"`
+	Fields        []*Field      `json:"fields"`
+	InputFields   []*InputValue `json:"inputFields"`
+	Interfaces    []*TypeRef    `json:"interfaces"`
+	EnumValues    []*EnumValue  `json:"enumValues"`
+	PossibleTypes []*TypeRef    `json:"possibleTypes"`
+}
+
+type Field struct {
+	Name              string        `json:"name"`
+	Description       string        `json:"description"`
+	Args              []*InputValue `json:"args"`
+	Type              *TypeRef      `json:"type"`
+	IsDeprecated      bool          `json:"isDeprecated"`
+	DeprecationReason string        `json:"deprecationReason"`
+}
+
+type InputValue struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Type         *TypeRef `json:"type"`
+	DefaultValue *string  `json:"defaultValue"`
+}
+
+type EnumValue struct {
+	Name              string `json:"name"`
+	Description       string `json:"description"`
+	IsDeprecated      bool   `json:"isDeprecated"`
+	DeprecationReason string `json:"deprecationReason"`
+}
+
+// TypeRef 字段或参数的类型引用, LIST和NON_NULL通过OfType嵌套
+type TypeRef struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	OfType *TypeRef `json:"ofType"`
+}
+
+// String 返回graphql类型表示, 比如: [String!]!
+func (t *TypeRef) String() string {
+	if t == nil {
+		return ""
+	}
+	switch t.Kind {
+	case KindNonNull:
+		return t.OfType.String() + "!"
+	case KindList:
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// NamedType 去掉LIST和NON_NULL后的类型名称
+func (t *TypeRef) NamedType() string {
+	for t != nil && (t.Kind == KindNonNull || t.Kind == KindList) {
+		t = t.OfType
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+// IsNonNull 类型是否不允许为null
+func (t *TypeRef) IsNonNull() bool {
+	return t != nil && t.Kind == KindNonNull
+}
+
+// Type 根据名称查找类型
+func (s *Schema) Type(name string) *Type {
+	for _, typ := range s.Types {
+		if typ.Name == name {
+			return typ
+		}
+	}
+	return nil
+}
+
+// Field 根据名称查找字段
+func (t *Type) Field(name string) *Field {
+	if t == nil {
+		return nil
+	}
+	for _, field := range t.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// IsBuiltin 是否为graphql内置类型(introspection类型和标量)
+func IsBuiltin(name string) bool {
+	if strings.HasPrefix(name, "__") {
+		return true
+	}
+	switch name {
+	case "String", "Int", "Float", "Boolean", "ID":
+		return true
+	}
+	return false
+}
+
+// Fetch 通过服务的/graphql接口获取schema, dns与rpc.CallService一致
+func Fetch(ctx context.Context, dns string) (*Schema, error) {
+	var (
+		data   map[string]interface{}
+		schema = new(Schema)
+		err    error
+	)
+	if data, err = rpc.CallService(ctx, dns, Query); err != nil {
+		return nil, err
+	}
+	if err = utils.Decode(data, "__schema", schema); err != nil {
+		return nil, fmt.Errorf("[Fetch] decode introspection result failed. err=%s", err.Error())
+	}
+	return schema, nil
+}
+
+// FromSchema 从本地graphql.Schema获取schema描述
+func FromSchema(schema graphql.Schema) (*Schema, error) {
+	var (
+		ret = new(Schema)
+		err error
+	)
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: Query,
+	})
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("[FromSchema] introspection failed. err=%s", result.Errors[0].Message)
+	}
+	if err = utils.Decode(result.Data, "__schema", ret); err != nil {
+		return nil, fmt.Errorf("[FromSchema] decode introspection result failed. err=%s", err.Error())
+	}
+	return ret, nil
+}
@@ -0,0 +1,49 @@
+package introspection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// Normalize 对类型、字段、参数和枚举值按名称排序, 相同的schema得到相同的描述
+func (s *Schema) Normalize() {
+	sort.Slice(s.Types, func(i, j int) bool {
+		return s.Types[i].Name < s.Types[j].Name
+	})
+	for _, typ := range s.Types {
+		sort.Slice(typ.Fields, func(i, j int) bool {
+			return typ.Fields[i].Name < typ.Fields[j].Name
+		})
+		for _, field := range typ.Fields {
+			sortInputValues(field.Args)
+		}
+		sortInputValues(typ.InputFields)
+		sortTypeRefs(typ.Interfaces)
+		sortTypeRefs(typ.PossibleTypes)
+		sort.Slice(typ.EnumValues, func(i, j int) bool {
+			return typ.EnumValues[i].Name < typ.EnumValues[j].Name
+		})
+	}
+}
+
+func sortInputValues(values []*InputValue) {
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+}
+
+func sortTypeRefs(refs []*TypeRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+}
+
+// Hash 规范化以后schema描述的sha256摘要, 用于判断schema是否发生变化
+func (s *Schema) Hash() string {
+	s.Normalize()
+	bts, _ := json.Marshal(s)
+	sum := sha256.Sum256(bts)
+	return hex.EncodeToString(sum[:])
+}
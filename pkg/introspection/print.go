@@ -0,0 +1,139 @@
+package introspection
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// PrintSchema 输出本地graphql.Schema的SDL
+/* example
+sdl, err := introspection.PrintSchema(*d.Schema())
+ioutil.WriteFile("user.graphql", []byte(sdl), 0644)
+*/
+func PrintSchema(schema graphql.Schema) (string, error) {
+	s, err := FromSchema(schema)
+	if err != nil {
+		return "", err
+	}
+	return s.SDL(), nil
+}
+
+// SDL 输出规范化以后的SDL, 不包含内置类型, 相同的schema输出相同的文本
+func (s *Schema) SDL() string {
+	var buf bytes.Buffer
+	s.Normalize()
+	if s.customRootTypes() {
+		buf.WriteString("schema {\n")
+		for _, op := range []struct {
+			name string
+			typ  *TypeName
+		}{{"query", s.QueryType}, {"mutation", s.MutationType}, {"subscription", s.SubscriptionType}} {
+			if op.typ != nil {
+				fmt.Fprintf(&buf, "  %s: %s\n", op.name, op.typ.Name)
+			}
+		}
+		buf.WriteString("}\n\n")
+	}
+	for _, typ := range s.Types {
+		if IsBuiltin(typ.Name) {
+			continue
+		}
+		printDescription(&buf, "", typ.Description)
+		switch typ.Kind {
+		case KindScalar:
+			fmt.Fprintf(&buf, "scalar %s\n\n", typ.Name)
+		case KindObject, KindInterface:
+			keyword := "type"
+			if typ.Kind == KindInterface {
+				keyword = "interface"
+			}
+			fmt.Fprintf(&buf, "%s %s", keyword, typ.Name)
+			if len(typ.Interfaces) > 0 {
+				names := make([]string, 0, len(typ.Interfaces))
+				for _, iface := range typ.Interfaces {
+					names = append(names, iface.Name)
+				}
+				fmt.Fprintf(&buf, " implements %s", strings.Join(names, " & "))
+			}
+			buf.WriteString(" {\n")
+			for _, field := range typ.Fields {
+				printDescription(&buf, "  ", field.Description)
+				fmt.Fprintf(&buf, "  %s%s: %s%s\n", field.Name, printArgs(field.Args),
+					field.Type, printDeprecated(field.IsDeprecated, field.DeprecationReason))
+			}
+			buf.WriteString("}\n\n")
+		case KindUnion:
+			names := make([]string, 0, len(typ.PossibleTypes))
+			for _, member := range typ.PossibleTypes {
+				names = append(names, member.Name)
+			}
+			fmt.Fprintf(&buf, "union %s = %s\n\n", typ.Name, strings.Join(names, " | "))
+		case KindEnum:
+			fmt.Fprintf(&buf, "enum %s {\n", typ.Name)
+			for _, value := range typ.EnumValues {
+				printDescription(&buf, "  ", value.Description)
+				fmt.Fprintf(&buf, "  %s%s\n", value.Name, printDeprecated(value.IsDeprecated, value.DeprecationReason))
+			}
+			buf.WriteString("}\n\n")
+		case KindInputObject:
+			fmt.Fprintf(&buf, "input %s {\n", typ.Name)
+			for _, field := range typ.InputFields {
+				printDescription(&buf, "  ", field.Description)
+				fmt.Fprintf(&buf, "  %s\n", printInputValue(field))
+			}
+			buf.WriteString("}\n\n")
+		}
+	}
+	return strings.TrimRight(buf.String(), "\n") + "\n"
+}
+
+// customRootTypes 根类型不是默认名称时需要输出schema定义
+func (s *Schema) customRootTypes() bool {
+	return (s.QueryType != nil && s.QueryType.Name != "Query") ||
+		(s.MutationType != nil && s.MutationType.Name != "Mutation") ||
+		(s.SubscriptionType != nil && s.SubscriptionType.Name != "Subscription")
+}
+
+func printArgs(args []*InputValue) string {
+	if len(args) <= 0 {
+		return ""
+	}
+	items := make([]string, 0, len(args))
+	for _, arg := range args {
+		items = append(items, printInputValue(arg))
+	}
+	return "(" + strings.Join(items, ", ") + ")"
+}
+
+func printInputValue(value *InputValue) string {
+	str := value.Name + ": " + value.Type.String()
+	if value.DefaultValue != nil {
+		str += " = " + *value.DefaultValue
+	}
+	return str
+}
+
+func printDescription(buf *bytes.Buffer, indent, desc string) {
+	if len(desc) > 0 {
+		fmt.Fprintf(buf, "%s%s\n", indent, quote(desc))
+	}
+}
+
+func printDeprecated(deprecated bool, reason string) string {
+	if !deprecated {
+		return ""
+	}
+	if len(reason) <= 0 || reason == "No longer supported" {
+		return " @deprecated"
+	}
+	return " @deprecated(reason: " + quote(reason) + ")"
+}
+
+var quoteReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func quote(s string) string {
+	return `"` + quoteReplacer.Replace(s) + `"`
+}
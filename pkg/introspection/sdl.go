@@ -0,0 +1,239 @@
+package introspection
+
+import (
+	"fmt"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// FromSDL 解析SDL文本得到schema描述
+func FromSDL(sdl string) (*Schema, error) {
+	var (
+		doc *ast.Document
+		err error
+	)
+	if doc, err = parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(sdl),
+			Name: "SDL",
+		}),
+	}); err != nil {
+		return nil, err
+	}
+	schema := new(Schema)
+	for _, def := range doc.Definitions {
+		switch d := def.(type) {
+		case *ast.SchemaDefinition:
+			for _, op := range d.OperationTypes {
+				name := &TypeName{Name: op.Type.Name.Value}
+				switch op.Operation {
+				case ast.OperationTypeQuery:
+					schema.QueryType = name
+				case ast.OperationTypeMutation:
+					schema.MutationType = name
+				case ast.OperationTypeSubscription:
+					schema.SubscriptionType = name
+				}
+			}
+		case *ast.ScalarDefinition:
+			schema.Types = append(schema.Types, &Type{
+				Kind:        KindScalar,
+				Name:        d.Name.Value,
+				Description: description(d.Description),
+			})
+		case *ast.ObjectDefinition:
+			typ := &Type{
+				Kind:        KindObject,
+				Name:        d.Name.Value,
+				Description: description(d.Description),
+				Fields:      fieldsFromAST(d.Fields),
+			}
+			for _, iface := range d.Interfaces {
+				typ.Interfaces = append(typ.Interfaces, &TypeRef{Kind: KindInterface, Name: iface.Name.Value})
+			}
+			schema.Types = append(schema.Types, typ)
+		case *ast.InterfaceDefinition:
+			schema.Types = append(schema.Types, &Type{
+				Kind:        KindInterface,
+				Name:        d.Name.Value,
+				Description: description(d.Description),
+				Fields:      fieldsFromAST(d.Fields),
+			})
+		case *ast.UnionDefinition:
+			typ := &Type{
+				Kind:        KindUnion,
+				Name:        d.Name.Value,
+				Description: description(d.Description),
+			}
+			for _, member := range d.Types {
+				typ.PossibleTypes = append(typ.PossibleTypes, &TypeRef{Kind: KindObject, Name: member.Name.Value})
+			}
+			schema.Types = append(schema.Types, typ)
+		case *ast.EnumDefinition:
+			typ := &Type{
+				Kind:        KindEnum,
+				Name:        d.Name.Value,
+				Description: description(d.Description),
+			}
+			for _, value := range d.Values {
+				reason, deprecated := deprecation(value.Directives)
+				typ.EnumValues = append(typ.EnumValues, &EnumValue{
+					Name:              value.Name.Value,
+					Description:       description(value.Description),
+					IsDeprecated:      deprecated,
+					DeprecationReason: reason,
+				})
+			}
+			schema.Types = append(schema.Types, typ)
+		case *ast.InputObjectDefinition:
+			schema.Types = append(schema.Types, &Type{
+				Kind:        KindInputObject,
+				Name:        d.Name.Value,
+				Description: description(d.Description),
+				InputFields: inputValuesFromAST(d.Fields),
+			})
+		}
+	}
+	// 没有schema定义时使用默认的根类型名称
+	if schema.QueryType == nil && schema.Type("Query") != nil {
+		schema.QueryType = &TypeName{Name: "Query"}
+	}
+	if schema.MutationType == nil && schema.Type("Mutation") != nil {
+		schema.MutationType = &TypeName{Name: "Mutation"}
+	}
+	if schema.SubscriptionType == nil && schema.Type("Subscription") != nil {
+		schema.SubscriptionType = &TypeName{Name: "Subscription"}
+	}
+	// 补全接口的实现类型
+	for _, typ := range schema.Types {
+		for _, iface := range typ.Interfaces {
+			if it := schema.Type(iface.Name); it != nil {
+				it.PossibleTypes = append(it.PossibleTypes, &TypeRef{Kind: KindObject, Name: typ.Name})
+			}
+		}
+	}
+	if err = schema.resolveKinds(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func fieldsFromAST(defs []*ast.FieldDefinition) []*Field {
+	fields := make([]*Field, 0, len(defs))
+	for _, def := range defs {
+		reason, deprecated := deprecation(def.Directives)
+		fields = append(fields, &Field{
+			Name:              def.Name.Value,
+			Description:       description(def.Description),
+			Args:              inputValuesFromAST(def.Arguments),
+			Type:              typeRefFromAST(def.Type),
+			IsDeprecated:      deprecated,
+			DeprecationReason: reason,
+		})
+	}
+	return fields
+}
+
+func inputValuesFromAST(defs []*ast.InputValueDefinition) []*InputValue {
+	values := make([]*InputValue, 0, len(defs))
+	for _, def := range defs {
+		value := &InputValue{
+			Name:        def.Name.Value,
+			Description: description(def.Description),
+			Type:        typeRefFromAST(def.Type),
+		}
+		if def.DefaultValue != nil {
+			str := fmt.Sprintf("%v", printer.Print(def.DefaultValue))
+			value.DefaultValue = &str
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// typeRefFromAST 命名类型的Kind在resolveKinds中补全
+func typeRefFromAST(t ast.Type) *TypeRef {
+	switch typ := t.(type) {
+	case *ast.NonNull:
+		return &TypeRef{Kind: KindNonNull, OfType: typeRefFromAST(typ.Type)}
+	case *ast.List:
+		return &TypeRef{Kind: KindList, OfType: typeRefFromAST(typ.Type)}
+	case *ast.Named:
+		return &TypeRef{Name: typ.Name.Value}
+	}
+	return nil
+}
+
+func (s *Schema) resolveKinds() error {
+	var resolve func(ref *TypeRef) error
+	resolve = func(ref *TypeRef) error {
+		if ref == nil {
+			return nil
+		}
+		if ref.Kind == KindNonNull || ref.Kind == KindList {
+			return resolve(ref.OfType)
+		}
+		if IsBuiltin(ref.Name) {
+			ref.Kind = KindScalar
+			return nil
+		}
+		typ := s.Type(ref.Name)
+		if typ == nil {
+			return fmt.Errorf("unknown type %s", ref.Name)
+		}
+		ref.Kind = typ.Kind
+		return nil
+	}
+	for _, typ := range s.Types {
+		for _, field := range typ.Fields {
+			if err := resolve(field.Type); err != nil {
+				return err
+			}
+			for _, arg := range field.Args {
+				if err := resolve(arg.Type); err != nil {
+					return err
+				}
+			}
+		}
+		for _, field := range typ.InputFields {
+			if err := resolve(field.Type); err != nil {
+				return err
+			}
+		}
+	}
+	// SDL中不需要声明内置标量, 补全后与introspection结果一致
+	for _, name := range []string{"String", "Int", "Float", "Boolean", "ID"} {
+		if s.Type(name) == nil {
+			s.Types = append(s.Types, &Type{Kind: KindScalar, Name: name})
+		}
+	}
+	return nil
+}
+
+func description(v *ast.StringValue) string {
+	if v == nil {
+		return ""
+	}
+	return v.Value
+}
+
+func deprecation(dirs []*ast.Directive) (reason string, deprecated bool) {
+	for _, dir := range dirs {
+		if dir.Name == nil || dir.Name.Value != "deprecated" {
+			continue
+		}
+		reason = "No longer supported"
+		for _, arg := range dir.Arguments {
+			if arg.Name != nil && arg.Name.Value == "reason" {
+				if str, ok := arg.Value.(*ast.StringValue); ok {
+					reason = str.Value
+				}
+			}
+		}
+		return reason, true
+	}
+	return "", false
+}
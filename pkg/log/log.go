@@ -14,6 +14,7 @@ import (
 	jsoniter "github.com/json-iterator/go"
 	"github.com/microsvs/base/pkg/env"
 	ierrors "github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/mask"
 	"github.com/microsvs/base/pkg/rpc"
 	"github.com/microsvs/base/pkg/timer"
 	"github.com/microsvs/base/pkg/types"
@@ -126,9 +127,10 @@ func logC(ctx context.Context, level string, service rpc.FGService, tag TraceSer
 	logStru.TraceRPCID = rpc.GetContextFromKey(ctx, rpc.KeyRPCID, "0").(string)
 	logStru.FromIP = utils.GetClientIPAdress(request)
 	fmt.Fprintf(&buildLog, format, v...)
-	logStru.Cnt = buildLog.Bytes()
+	// 日志内容中的手机号等敏感信息脱敏
+	logStru.Cnt = []byte(mask.Redact(buildLog.String()))
 	logStru.ConsoleInfo = ConsoleInfo{
-		Mobile: user.Mobile,
+		Mobile: mask.Mask(mask.Phone, user.Mobile),
 		Client: strings.Split(logStru.TraceID, ":")[0],
 		UserID: user.ID,
 	}
@@ -0,0 +1,144 @@
+// Package mask 敏感信息脱敏, 用于接口返回值和日志输出
+package mask
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kind 敏感信息类型, 自定义类型通过Register注册脱敏方法
+type Kind string
+
+const (
+	Phone  Kind = "phone"
+	Email  Kind = "email"
+	IDCard Kind = "idcard"
+)
+
+// Masker 脱敏方法, 输入原始值返回脱敏以后的值
+type Masker func(string) string
+
+var (
+	mutex   sync.RWMutex
+	maskers = map[Kind]Masker{
+		Phone:  maskPhone,
+		Email:  maskEmail,
+		IDCard: maskIDCard,
+	}
+	secrets  = map[string]bool{}
+	replacer = strings.NewReplacer()
+)
+
+// MinSecretLen 短于该长度的值不注册为secret, 避免替换掉日志中的常见单词
+var MinSecretLen = 6
+
+// RegisterSecret 注册不能出现在日志中的值(密码, 带密码的连接地址等), Redact时替换为******
+func RegisterSecret(s string) {
+	if len(s) < MinSecretLen {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if secrets[s] {
+		return
+	}
+	secrets[s] = true
+	// 同一位置优先替换较长的值
+	keys := make([]string, 0, len(secrets))
+	for secret := range secrets {
+		keys = append(keys, secret)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+	pairs := make([]string, 0, 2*len(keys))
+	for _, secret := range keys {
+		pairs = append(pairs, secret, "******")
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// Register 注册或者替换某种类型的脱敏方法
+/* example
+mask.Register("bankcard", func(s string) string {
+	return mask.Keep(s, 0, 4)
+})
+*/
+func Register(kind Kind, fn Masker) {
+	mutex.Lock()
+	maskers[kind] = fn
+	mutex.Unlock()
+}
+
+// Mask 按照类型脱敏, 未注册的类型全部替换为*
+func Mask(kind Kind, s string) string {
+	if len(s) <= 0 {
+		return s
+	}
+	mutex.RLock()
+	fn, ok := maskers[kind]
+	mutex.RUnlock()
+	if !ok {
+		return Keep(s, 0, 0)
+	}
+	return fn(s)
+}
+
+// Keep 保留前head个和后tail个字符, 中间替换为*, 长度不够时全部替换
+func Keep(s string, head, tail int) string {
+	runes := []rune(s)
+	if head+tail >= len(runes) {
+		head, tail = 0, 0
+	}
+	for i := head; i < len(runes)-tail; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// 13812345678 => 138****5678
+func maskPhone(s string) string {
+	return Keep(s, 3, 4)
+}
+
+// xhj@example.com => x***@example.com
+func maskEmail(s string) string {
+	idx := strings.LastIndex(s, "@")
+	if idx <= 0 {
+		return Keep(s, 0, 0)
+	}
+	return s[:1] + "***" + s[idx:]
+}
+
+// 110101199001011234 => 110***********1234
+func maskIDCard(s string) string {
+	return Keep(s, 3, 4)
+}
+
+var (
+	digitsRegexp = regexp.MustCompile(`[0-9]+[Xx]?`)
+	emailRegexp  = regexp.MustCompile(`[0-9A-Za-z._%+-]+@[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)+`)
+	phoneRegexp  = regexp.MustCompile(`^1[3-9][0-9]{9}$`)
+	idCardRegexp = regexp.MustCompile(`^[0-9]{17}[0-9Xx]$`)
+)
+
+// Redact 对文本中出现的secret, 手机号, 身份证号和邮箱脱敏, 用于日志等无法标记字段的场景
+func Redact(s string) string {
+	mutex.RLock()
+	s = replacer.Replace(s)
+	mutex.RUnlock()
+	s = digitsRegexp.ReplaceAllStringFunc(s, func(digits string) string {
+		switch {
+		case phoneRegexp.MatchString(digits):
+			return Mask(Phone, digits)
+		case idCardRegexp.MatchString(digits):
+			return Mask(IDCard, digits)
+		}
+		return digits
+	})
+	return emailRegexp.ReplaceAllStringFunc(s, func(email string) string {
+		return Mask(Email, email)
+	})
+}
@@ -0,0 +1,29 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "138****5678", Mask(Phone, "13812345678"))
+	assert.Equal(t, "x***@example.com", Mask(Email, "xhj@example.com"))
+	assert.Equal(t, "110***********1234", Mask(IDCard, "110101199001011234"))
+	assert.Equal(t, "", Mask(Phone, ""))
+	// 已经脱敏的值再次脱敏结果不变
+	assert.Equal(t, "138****5678", Mask(Phone, "138****5678"))
+	// 长度不够时全部替换
+	assert.Equal(t, "****", Mask(Phone, "1234"))
+	assert.Equal(t, "***", Mask("unknown", "abc"))
+
+	Register("name", func(s string) string {
+		return Keep(s, 1, 0)
+	})
+	assert.Equal(t, "张**", Mask("name", "张小明"))
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "user login. mobile=138****5678 idcard=110***********123X email=x***@example.com order=20180101123456",
+		Redact("user login. mobile=13812345678 idcard=11010119900101123X email=xhj@example.com order=20180101123456"))
+}
@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/microsvs/base/pkg/log"
+)
+
+// 每个订阅者缓存的消息数, 超过后丢弃新消息
+var MemoryBufferSize = 64
+
+// Memory 进程内的发布订阅, 适用于单实例或者测试
+type Memory struct {
+	mutex  sync.RWMutex
+	topics map[string]map[chan []byte]struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		topics: make(map[string]map[chan []byte]struct{}),
+	}
+}
+
+func (m *Memory) Publish(topic string, payload []byte) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for ch := range m.topics[topic] {
+		select {
+		case ch <- payload:
+		default:
+			log.ErrorRaw("[Memory.Publish] subscriber of %s is full, drop message", topic)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	ch := make(chan []byte, MemoryBufferSize)
+	m.mutex.Lock()
+	if m.topics[topic] == nil {
+		m.topics[topic] = make(map[chan []byte]struct{})
+	}
+	m.topics[topic][ch] = struct{}{}
+	m.mutex.Unlock()
+	go func() {
+		<-ctx.Done()
+		m.mutex.Lock()
+		delete(m.topics[topic], ch)
+		if len(m.topics[topic]) <= 0 {
+			delete(m.topics, topic)
+		}
+		m.mutex.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPubSub(t *testing.T) {
+	ps := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := ps.Subscribe(ctx, "order/1")
+	assert.Nil(t, err)
+
+	assert.Nil(t, ps.Publish("order/2", []byte("ignored")))
+	assert.Nil(t, ps.Publish("order/1", []byte("paid")))
+	assert.Equal(t, []byte("paid"), <-ch)
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestSubscription(t *testing.T) {
+	ps := NewMemory()
+	order := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.Fields{
+			"id":     &graphql.Field{Type: graphql.String},
+			"status": &graphql.Field{Type: graphql.String},
+		},
+	})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: graphql.Fields{"ping": &graphql.Field{Type: graphql.String}},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Subscription",
+			Fields: graphql.Fields{
+				"orderChanged": &graphql.Field{
+					Type: order,
+					Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.String}},
+					Subscribe: Subscriber(ps, func(p graphql.ResolveParams) string {
+						return "order/" + p.Args["id"].(string)
+					}),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := graphql.Subscribe(graphql.Params{
+		Schema:        schema,
+		RequestString: `subscription { orderChanged(id: "1") { status } }`,
+		Context:       ctx,
+	})
+	// 等待订阅建立
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, Publish(ps, "order/1", map[string]interface{}{"id": "1", "status": "PAID"}))
+	result := <-results
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"orderChanged": map[string]interface{}{"status": "PAID"},
+	}, result.Data)
+}
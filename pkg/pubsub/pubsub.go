@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/log"
+)
+
+// PubSub 发布订阅, 订阅在ctx结束时取消并关闭返回的channel
+type PubSub interface {
+	Publish(topic string, payload []byte) error
+	Subscribe(ctx context.Context, topic string) (<-chan []byte, error)
+}
+
+// Publish 把v编码为json后发布
+/* example
+pubsub.Publish(ps, "order/1001", map[string]interface{}{"id": "1001", "status": "PAID"})
+*/
+func Publish(ps PubSub, topic string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ps.Publish(topic, payload)
+}
+
+// Subscriber 生成subscription字段的Subscribe函数, 消息解码后作为该字段的source
+/* example
+"orderChanged": &graphql.Field{
+	Type: GLOrder,
+	Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.String}},
+	Subscribe: pubsub.Subscriber(ps, func(p graphql.ResolveParams) string {
+		return "order/" + p.Args["id"].(string)
+	}),
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source, nil },
+}
+*/
+func Subscriber(ps PubSub, topic func(p graphql.ResolveParams) string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		var name = topic(p)
+		ch, err := ps.Subscribe(p.Context, name)
+		if err != nil {
+			return nil, err
+		}
+		// graphql-go要求返回chan interface{}
+		out := make(chan interface{})
+		go func() {
+			defer close(out)
+			for payload := range ch {
+				var v interface{}
+				if err := json.Unmarshal(payload, &v); err != nil {
+					log.ErrorRaw("[Subscriber] decode message of %s failed. err=%s", name, err.Error())
+					continue
+				}
+				select {
+				case out <- v:
+				case <-p.Context.Done():
+					return
+				}
+			}
+		}()
+		return out, nil
+	}
+}
@@ -38,6 +38,8 @@ const (
 	KeyCid
 	KeyDevice
 	KeyRemoteIp
+	KeyCaller // 调用方服务名称, 内部调用时由ContextToHTTPRequest设置
+	KeyAppID  // 签名校验通过的appid
 )
 
 // 用于区分内部调用，还是外部调用
@@ -76,6 +78,19 @@ func ContextToHTTPRequest(ctx context.Context, r *http.Request) error {
 		KeyUser:        user,
 		KeyConsoleInfo: console,
 	}
+	// 客户端属性, 供下游服务的开关等使用
+	for _, key := range []KeyContext{KeyAppID, KeyAppVersion, KeyDevice} {
+		if value, ok := GetContextFromKey(ctx, key, "").(string); ok && len(value) > 0 {
+			values[key] = value
+		}
+	}
+	// 当前服务是下游服务的调用方
+	switch service := GetContextFromKey(ctx, KeyService, "").(type) {
+	case string:
+		values[KeyCaller] = service
+	case FGService:
+		values[KeyCaller] = service.String()
+	}
 	if gobVal, err = toMsgpack(values); err != nil {
 		return err
 	}
@@ -119,6 +134,8 @@ func ContextFromHTTPRequest(ctx context.Context, r *http.Request) (context.Conte
 	if err = mapstructure.Decode(m[KeyUser], user); err != nil {
 		return nil, err
 	}
+	// 协议头由调用方设置, 不信任其中的角色和权限
+	user.Roles, user.Permissions = nil, nil
 	if err = mapstructure.Decode(m[KeyConsoleInfo], console); err != nil {
 		return nil, err
 	}
@@ -129,6 +146,11 @@ func ContextFromHTTPRequest(ctx context.Context, r *http.Request) (context.Conte
 	ctx = context.WithValue(ctx, KeyTraceID, traceid)
 	ctx = context.WithValue(ctx, KeyUser, user)
 	ctx = context.WithValue(ctx, KeyConsoleInfo, console)
+	for _, key := range []KeyContext{KeyCaller, KeyAppID, KeyAppVersion, KeyDevice} {
+		if value, ok := m[key].(string); ok && len(value) > 0 {
+			ctx = context.WithValue(ctx, key, value)
+		}
+	}
 	return ctx, nil
 }
 
@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/microsvs/base/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// 角色和权限不随rpc context传递, 伪造的协议头也不会生效
+func TestContextUserRoles(t *testing.T) {
+	ctx := context.WithValue(context.Background(), KeyUser, &types.User{
+		ID:          "1001",
+		Roles:       []string{"admin"},
+		Permissions: []string{"order:read", "order:write"},
+	})
+	r, _ := http.NewRequest("POST", "http://localhost/graphql", nil)
+	assert.Nil(t, ContextToHTTPRequest(ctx, r))
+
+	ctx, err := ContextFromHTTPRequest(nil, r)
+	assert.Nil(t, err)
+	user := ctx.Value(KeyUser).(*types.User)
+	assert.Equal(t, "1001", user.ID)
+	assert.Nil(t, user.Roles)
+	assert.Nil(t, user.Permissions)
+
+	forged, _ := toMsgpack(map[KeyContext]interface{}{
+		KeyRPCID:   "0",
+		KeyTraceID: "-",
+		KeyUser:    map[string]interface{}{"user_id": "1001", "roles": []string{"admin"}},
+	})
+	r.Header.Set(RPC__CONTEXT, forged)
+	ctx, err = ContextFromHTTPRequest(nil, r)
+	assert.Nil(t, err)
+	assert.Nil(t, ctx.Value(KeyUser).(*types.User).Roles)
+}
+
+// 当前服务作为调用方传递给下游服务
+func TestContextCaller(t *testing.T) {
+	r, _ := http.NewRequest("POST", "http://localhost/graphql", nil)
+	assert.Nil(t, ContextToHTTPRequest(context.WithValue(context.Background(), KeyService, "order"), r))
+	ctx, err := ContextFromHTTPRequest(nil, r)
+	assert.Nil(t, err)
+	assert.Equal(t, "order", ctx.Value(KeyCaller))
+
+	assert.Nil(t, ContextToHTTPRequest(context.WithValue(context.Background(), KeyTraceID, "-"), r))
+	ctx, err = ContextFromHTTPRequest(nil, r)
+	assert.Nil(t, err)
+	assert.Nil(t, ctx.Value(KeyCaller))
+}
@@ -1,12 +1,18 @@
 package rpc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/microsvs/base/pkg/types"
@@ -20,13 +26,110 @@ type Resp struct {
 }
 
 func CallService(ctx context.Context, dns string, data string) (map[string]interface{}, error) {
+	return callService(ctx, dns, "application/graphql", data, strings.NewReader(data))
+}
+
+// CallServiceWithVariables 以json格式发送graphql请求, 参数通过variables传递
+func CallServiceWithVariables(
+	ctx context.Context,
+	dns string,
+	query string,
+	variables map[string]interface{},
+) (map[string]interface{}, error) {
+	bts, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[CallService] json encode failed. err=%s", err.Error())
+	}
+	return callService(ctx, dns, "application/json", query, bytes.NewReader(bts))
+}
+
+// CallServiceWithUploads 按照graphql multipart规范发送请求, files的key为query中的变量名
+/* example
+query: mutation($file: Upload!){uploadPicture(file: $file){url}}
+files: map[string]*types.Upload{"file": upload}
+*/
+func CallServiceWithUploads(
+	ctx context.Context,
+	dns string,
+	query string,
+	variables map[string]interface{},
+	files map[string]*types.Upload,
+) (map[string]interface{}, error) {
+	var (
+		pr, pw  = io.Pipe()
+		mw      = multipart.NewWriter(pw)
+		names   = make([]string, 0, len(files))
+		fileMap = make(map[string][]string, len(files))
+		allVars = make(map[string]interface{}, len(variables)+len(files))
+	)
+	for name, value := range variables {
+		allVars[name] = value
+	}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for idx, name := range names {
+		allVars[name] = nil
+		fileMap[strconv.Itoa(idx)] = []string{"variables." + name}
+	}
+	operations, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": allVars,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[CallService] json encode failed. err=%s", err.Error())
+	}
+	bts, _ := json.Marshal(fileMap)
+	// 文件内容边读边发送, 不在内存中保存
+	go func() {
+		pw.CloseWithError(writeMultipart(mw, operations, bts, names, files))
+	}()
+	return callService(ctx, dns, mw.FormDataContentType(), query, pr)
+}
+
+func writeMultipart(mw *multipart.Writer, operations, fileMap []byte,
+	names []string, files map[string]*types.Upload) error {
+	if err := mw.WriteField("operations", string(operations)); err != nil {
+		return err
+	}
+	if err := mw.WriteField("map", string(fileMap)); err != nil {
+		return err
+	}
+	for idx, name := range names {
+		upload := files[name]
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": []string{fmt.Sprintf(`form-data; name="%d"; filename="%s"`,
+				idx, strings.Replace(upload.Filename, `"`, "\\\"", -1))},
+			"Content-Type": []string{upload.ContentType},
+		})
+		if err != nil {
+			return err
+		}
+		f, err := upload.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func callService(ctx context.Context, dns string, contentType string, query string, data io.Reader) (map[string]interface{}, error) {
 	var (
 		resp *http.Response
 		err  error
 		body []byte
 	)
 	url := fmt.Sprintf("http://%s/graphql", dns)
-	resp, err = httpPostWithContext(ctx, url, "application/graphql", data)
+	resp, err = httpPostWithContext(ctx, url, contentType, query, data)
 	if err != nil {
 		return nil, fmt.Errorf("[CallService] http request failed, err=%s", err.Error())
 	}
@@ -45,18 +148,18 @@ func CallService(ctx context.Context, dns string, data string) (map[string]inter
 }
 
 func httpPostWithContext(
-	ctx context.Context, url string, contentType string, data string) (
+	ctx context.Context, url string, contentType string, query string, data io.Reader) (
 	resp *http.Response, err error) {
 	var (
 		req *http.Request
 	)
-	if req, err = http.NewRequest("POST", url, strings.NewReader(data)); err != nil {
+	if req, err = http.NewRequest("POST", url, data); err != nil {
 		return nil, err
 	}
 	req, ht := nethttp.TraceRequest(
 		utils.GetGlobalTracer(),
 		req,
-		nethttp.OperationName("HTTP POST: "+getOperationName(data)),
+		nethttp.OperationName("HTTP POST: "+getOperationName(query)),
 	)
 	defer ht.Finish()
 	if err = ContextToHTTPRequest(ctx, req); err != nil {
@@ -2,8 +2,10 @@ package rpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	"github.com/microsvs/base/pkg/dataloader"
 	"github.com/microsvs/base/pkg/errors"
 	"github.com/microsvs/base/pkg/types"
 	"github.com/microsvs/base/pkg/utils"
@@ -29,6 +31,24 @@ var (
 				nickname
 			}
 		}
+   `
+	USERS_QUERY_SCHEMA = `
+		query{
+			users(user_ids: %s) {
+				id
+				mobile
+				nickname
+			}
+		}
+   `
+	USER_GRANTS_QUERY_SCHEMA = `
+		query{
+			user(user_id: "%s") {
+				id
+				roles
+				permissions
+			}
+		}
    `
 )
 
@@ -67,3 +87,70 @@ func GetUserFromIdRPC(ctx context.Context, dns string, id string) (*types.User,
 	}
 	return user, nil
 }
+
+// GetUserGrantsRPC 获取用户的角色和权限, 返回的user只有ID, Roles和Permissions
+// 角色和权限单独查询, 用户服务没有提供这两个字段时不影响GetUserFromIdRPC
+func GetUserGrantsRPC(ctx context.Context, dns string, id string) (*types.User, error) {
+	var (
+		data map[string]interface{}
+		err  error
+		user = new(types.User)
+	)
+	if data, err = CallService(ctx, dns, fmt.Sprintf(USER_GRANTS_QUERY_SCHEMA, id)); err != nil {
+		return nil, err
+	}
+	if err = utils.Decode(data, "user", user); err != nil {
+		return nil, err
+	}
+	if len(user.ID) <= 0 {
+		return nil, errors.FGEInvalidUserID
+	}
+	return user, nil
+}
+
+// GetUsersFromIdsRPC 批量获取用户信息, 返回user_id -> user
+func GetUsersFromIdsRPC(ctx context.Context, dns string, ids []string) (map[string]*types.User, error) {
+	var (
+		data  map[string]interface{}
+		err   error
+		bts   []byte
+		users []*types.User
+	)
+	if bts, err = json.Marshal(ids); err != nil {
+		return nil, err
+	}
+	if data, err = CallService(ctx, dns, fmt.Sprintf(USERS_QUERY_SCHEMA, bts)); err != nil {
+		return nil, err
+	}
+	if err = utils.Decode(data, "users", &users); err != nil {
+		return nil, err
+	}
+	ret := make(map[string]*types.User, len(users))
+	for _, user := range users {
+		if user != nil && len(user.ID) > 0 {
+			ret[user.ID] = user
+		}
+	}
+	return ret, nil
+}
+
+// UserLoader 当前请求内合并用户查询的loader, Load返回*types.User
+/* example
+func resolveOrderUser(p graphql.ResolveParams) (interface{}, error) {
+	order := p.Source.(*Order)
+	return rpc.UserLoader(p.Context, base.Service2Url(rpc.FGSUser)).Load(p.Context, order.UserId), nil
+}
+*/
+func UserLoader(ctx context.Context, dns string) *dataloader.Loader {
+	return dataloader.For(ctx, "user:"+dns, func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		users, err := GetUsersFromIdsRPC(ctx, dns, keys)
+		if err != nil {
+			return nil, err
+		}
+		ret := make(map[string]interface{}, len(users))
+		for id, user := range users {
+			ret[id] = user
+		}
+		return ret, nil
+	})
+}
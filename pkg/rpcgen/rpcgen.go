@@ -0,0 +1,413 @@
+package rpcgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+
+	"github.com/microsvs/base/pkg/introspection"
+)
+
+// Options 代码生成参数
+type Options struct {
+	// 生成代码的package名称
+	Package string
+	// 服务名称, 只用于注释
+	Service string
+	// 返回对象的最大嵌套深度, 默认: 3
+	MaxDepth int
+}
+
+const defaultMaxDepth = 3
+
+// 常见缩写, 生成go名称时保持大写
+var initialisms = map[string]bool{
+	"id": true, "ip": true, "url": true, "uri": true, "api": true,
+	"uuid": true, "http": true, "json": true, "sql": true, "sms": true,
+}
+
+// graphql内置标量与go类型的对应关系
+var scalarTypes = map[string]string{
+	"String":   "string",
+	"ID":       "string",
+	"Int":      "int",
+	"Float":    "float64",
+	"Boolean":  "bool",
+	"DateTime": "time.Time",
+}
+
+type generator struct {
+	schema  *introspection.Schema
+	opts    Options
+	buf     bytes.Buffer
+	useTime bool
+	methods map[string]bool
+}
+
+// Generate 根据schema生成类型化的rpc客户端代码
+/* example
+schema, _ := introspection.Fetch(ctx, base.Service2Url(rpc.FGSUser))
+code, _ := rpcgen.Generate(schema, rpcgen.Options{Package: "userrpc", Service: "user"})
+*/
+func Generate(schema *introspection.Schema, opts Options) ([]byte, error) {
+	if len(opts.Package) <= 0 {
+		return nil, fmt.Errorf("package name is empty")
+	}
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = defaultMaxDepth
+	}
+	g := &generator{
+		schema:  schema,
+		opts:    opts,
+		methods: make(map[string]bool),
+	}
+	var body bytes.Buffer
+	g.genTypes()
+	g.genClient()
+	body.Write(g.buf.Bytes())
+
+	g.buf.Reset()
+	g.printf("// Code generated by rpcgen from the %s service schema. DO NOT EDIT.\n\n", opts.Service)
+	g.printf("package %s\n\n", opts.Package)
+	g.printf("import (\n\"context\"\n")
+	if g.useTime {
+		g.printf("\"time\"\n")
+	}
+	g.printf("\n\"github.com/microsvs/base/pkg/rpc\"\n\"github.com/microsvs/base/pkg/utils\"\n)\n\n")
+	g.buf.Write(body.Bytes())
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code failed. err=%s", err.Error())
+	}
+	return src, nil
+}
+
+func (g *generator) printf(format string, v ...interface{}) {
+	fmt.Fprintf(&g.buf, format, v...)
+}
+
+func (g *generator) comment(name string, desc string) {
+	if len(desc) <= 0 {
+		return
+	}
+	g.printf("// %s %s\n", name, strings.Replace(strings.TrimSpace(desc), "\n", "\n// ", -1))
+}
+
+func (g *generator) isRoot(name string) bool {
+	for _, root := range []*introspection.TypeName{
+		g.schema.QueryType, g.schema.MutationType, g.schema.SubscriptionType,
+	} {
+		if root != nil && root.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) sortedTypes() []*introspection.Type {
+	types := make([]*introspection.Type, 0, len(g.schema.Types))
+	for _, typ := range g.schema.Types {
+		if introspection.IsBuiltin(typ.Name) || g.isRoot(typ.Name) {
+			continue
+		}
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].Name < types[j].Name
+	})
+	return types
+}
+
+func (g *generator) genTypes() {
+	for _, typ := range g.sortedTypes() {
+		switch typ.Kind {
+		case introspection.KindEnum:
+			g.genEnum(typ)
+		case introspection.KindObject, introspection.KindInterface:
+			g.genStruct(typ, typ.Fields, false)
+		case introspection.KindInputObject:
+			fields := make([]*introspection.Field, 0, len(typ.InputFields))
+			for _, input := range typ.InputFields {
+				fields = append(fields, &introspection.Field{
+					Name:        input.Name,
+					Description: input.Description,
+					Type:        input.Type,
+				})
+			}
+			g.genStruct(typ, fields, true)
+		}
+	}
+}
+
+func (g *generator) genEnum(typ *introspection.Type) {
+	name := GoName(typ.Name)
+	g.comment(name, typ.Description)
+	g.printf("type %s string\n\nconst (\n", name)
+	for _, value := range typ.EnumValues {
+		constName := name + GoName(value.Name)
+		g.comment(constName, value.Description)
+		if value.IsDeprecated {
+			g.printf("// Deprecated: %s\n", value.DeprecationReason)
+		}
+		g.printf("%s %s = %q\n", constName, name, value.Name)
+	}
+	g.printf(")\n\n")
+}
+
+func (g *generator) genStruct(typ *introspection.Type, fields []*introspection.Field, input bool) {
+	name := GoName(typ.Name)
+	g.comment(name, typ.Description)
+	g.printf("type %s struct {\n", name)
+	if typ.Kind == introspection.KindInterface {
+		g.printf("Typename string `json:\"__typename\"`\n")
+	}
+	for _, field := range fields {
+		g.comment(GoName(field.Name), field.Description)
+		if field.IsDeprecated {
+			g.printf("// Deprecated: %s\n", field.DeprecationReason)
+		}
+		if input {
+			g.printf("%s %s `json:\"%s\"`\n", GoName(field.Name), g.inputType(field.Type), jsonTag(field.Name, field.Type))
+		} else {
+			g.printf("%s %s `json:\"%s\"`\n", GoName(field.Name), g.outputType(field.Type, true), field.Name)
+		}
+	}
+	g.printf("}\n\n")
+}
+
+func jsonTag(name string, ref *introspection.TypeRef) string {
+	if ref.IsNonNull() {
+		return name
+	}
+	return name + ",omitempty"
+}
+
+// goType 命名类型对应的go类型
+func (g *generator) goType(name string) string {
+	if goType, ok := scalarTypes[name]; ok {
+		if goType == "time.Time" {
+			g.useTime = true
+		}
+		return goType
+	}
+	typ := g.schema.Type(name)
+	if typ == nil || typ.Kind == introspection.KindScalar {
+		return "interface{}"
+	}
+	if typ.Kind == introspection.KindUnion {
+		return "map[string]interface{}"
+	}
+	return GoName(name)
+}
+
+// outputType 返回值类型, 对象使用指针
+func (g *generator) outputType(ref *introspection.TypeRef, pointer bool) string {
+	switch ref.Kind {
+	case introspection.KindNonNull:
+		return g.outputType(ref.OfType, pointer)
+	case introspection.KindList:
+		return "[]" + g.outputType(ref.OfType, false)
+	case introspection.KindObject, introspection.KindInterface:
+		if pointer {
+			return "*" + g.goType(ref.Name)
+		}
+	}
+	return g.goType(ref.Name)
+}
+
+// inputType 参数类型, 可以为null的参数使用指针, 序列化时忽略
+func (g *generator) inputType(ref *introspection.TypeRef) string {
+	switch ref.Kind {
+	case introspection.KindNonNull:
+		inner := ref.OfType
+		if inner.Kind == introspection.KindList {
+			return "[]" + g.inputType(inner.OfType)
+		}
+		return g.goType(inner.Name)
+	case introspection.KindList:
+		return "[]" + g.inputType(ref.OfType)
+	}
+	goType := g.goType(ref.Name)
+	if goType == "interface{}" || strings.HasPrefix(goType, "map[") {
+		return goType
+	}
+	return "*" + goType
+}
+
+// selection 生成返回类型的字段列表, 需要参数的字段和超过深度的对象字段会被忽略
+func (g *generator) selection(name string, depth int, path map[string]bool) string {
+	typ := g.schema.Type(name)
+	if typ == nil {
+		return ""
+	}
+	switch typ.Kind {
+	case introspection.KindUnion:
+		return "{__typename}"
+	case introspection.KindObject, introspection.KindInterface:
+	default:
+		return ""
+	}
+	path[name] = true
+	defer delete(path, name)
+	var items []string
+	if typ.Kind == introspection.KindInterface {
+		items = append(items, "__typename")
+	}
+	for _, field := range typ.Fields {
+		if hasRequiredArgs(field) {
+			continue
+		}
+		named := field.Type.NamedType()
+		child := g.schema.Type(named)
+		if child != nil && (child.Kind == introspection.KindObject ||
+			child.Kind == introspection.KindInterface || child.Kind == introspection.KindUnion) {
+			if depth >= g.opts.MaxDepth || path[named] {
+				continue
+			}
+			if sub := g.selection(named, depth+1, path); len(sub) > 0 {
+				items = append(items, field.Name+sub)
+			}
+			continue
+		}
+		items = append(items, field.Name)
+	}
+	if len(items) <= 0 {
+		return ""
+	}
+	return "{" + strings.Join(items, " ") + "}"
+}
+
+func hasRequiredArgs(field *introspection.Field) bool {
+	for _, arg := range field.Args {
+		if arg.Type.IsNonNull() && arg.DefaultValue == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) genClient() {
+	g.printf("// Client %s服务的rpc客户端\n", g.opts.Service)
+	g.printf("type Client struct {\ndns string\n}\n\n")
+	g.printf("// NewClient dns与rpc.CallService一致, 比如: base.Service2Url(rpc.FGSUser)\n")
+	g.printf("func NewClient(dns string) *Client {\nreturn &Client{dns: dns}\n}\n\n")
+	g.printf(`func (c *Client) call(ctx context.Context, query string, args interface{}, method string, ret interface{}) error {
+	var (
+		data      map[string]interface{}
+		variables map[string]interface{}
+		err       error
+	)
+	if args != nil {
+		if err = utils.GenericTypeConvert(args, &variables); err != nil {
+			return err
+		}
+	}
+	if data, err = rpc.CallServiceWithVariables(ctx, c.dns, query, variables); err != nil {
+		return err
+	}
+	return utils.Decode(data, method, ret)
+}
+
+`)
+	if g.schema.QueryType != nil {
+		g.genRoot("query", g.schema.Type(g.schema.QueryType.Name), "")
+	}
+	if g.schema.MutationType != nil {
+		g.genRoot("mutation", g.schema.Type(g.schema.MutationType.Name), "Mutate")
+	}
+}
+
+func (g *generator) genRoot(operation string, root *introspection.Type, prefix string) {
+	if root == nil {
+		return
+	}
+	for _, field := range root.Fields {
+		method := GoName(field.Name)
+		if g.methods[method] {
+			method = prefix + method
+		}
+		g.methods[method] = true
+		g.genMethod(operation, method, field)
+	}
+}
+
+func (g *generator) genMethod(operation string, method string, field *introspection.Field) {
+	var (
+		argsType string
+		defs     []string
+		uses     []string
+	)
+	if len(field.Args) > 0 {
+		argsType = method + "Args"
+		g.printf("// %s %s的请求参数\n", argsType, field.Name)
+		g.printf("type %s struct {\n", argsType)
+		for _, arg := range field.Args {
+			g.comment(GoName(arg.Name), arg.Description)
+			g.printf("%s %s `json:\"%s\"`\n", GoName(arg.Name), g.inputType(arg.Type), jsonTag(arg.Name, arg.Type))
+			defs = append(defs, fmt.Sprintf("$%s: %s", arg.Name, arg.Type.String()))
+			uses = append(uses, fmt.Sprintf("%s: $%s", arg.Name, arg.Name))
+		}
+		g.printf("}\n\n")
+	}
+	query := operation
+	if len(defs) > 0 {
+		query += "(" + strings.Join(defs, ", ") + ")"
+	}
+	query += "{" + field.Name
+	if len(uses) > 0 {
+		query += "(" + strings.Join(uses, ", ") + ")"
+	}
+	query += g.selection(field.Type.NamedType(), 1, map[string]bool{}) + "}"
+
+	retType := g.outputType(field.Type, true)
+	g.comment(method, field.Description)
+	if field.IsDeprecated {
+		g.printf("// Deprecated: %s\n", field.DeprecationReason)
+	}
+	if len(argsType) > 0 {
+		g.printf("func (c *Client) %s(ctx context.Context, args *%s) (%s, error) {\n", method, argsType, retType)
+	} else {
+		g.printf("func (c *Client) %s(ctx context.Context) (%s, error) {\n", method, retType)
+	}
+	if strings.HasPrefix(retType, "*") {
+		g.printf("ret := new(%s)\n", retType[1:])
+		g.printf("if err := c.call(ctx, %q, %s, %q, ret); err != nil {\n", query, argsValue(argsType), field.Name)
+	} else {
+		g.printf("var ret %s\n", retType)
+		g.printf("if err := c.call(ctx, %q, %s, %q, &ret); err != nil {\n", query, argsValue(argsType), field.Name)
+	}
+	g.printf("return ret, err\n}\nreturn ret, nil\n}\n\n")
+}
+
+func argsValue(argsType string) string {
+	if len(argsType) > 0 {
+		return "args"
+	}
+	return "nil"
+}
+
+// GoName 把graphql名称转化为导出的go名称
+/* example
+GoName("user_id") // UserID
+GoName("PARK_ZONE") // ParkZone
+GoName("refreshToken") // RefreshToken
+*/
+func GoName(name string) string {
+	var result string
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	}) {
+		lower := strings.ToLower(part)
+		if initialisms[lower] {
+			result += strings.ToUpper(part)
+			continue
+		}
+		if part == strings.ToUpper(part) {
+			part = lower
+		}
+		result += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package rpcgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/microsvs/base/pkg/introspection"
+	"github.com/stretchr/testify/assert"
+)
+
+const userSDL = `
+"用户状态"
+enum BasicUserStatus {
+	Normal
+	Delete @deprecated(reason: "use Normal")
+}
+
+type BasicUser {
+	id: String
+	nickname: String
+	status: BasicUserStatus
+	friends(first: Int!): [BasicUser]
+	updated_at: DateTime
+}
+
+scalar DateTime
+
+type Query {
+	user(user_id: String!): BasicUser
+	users(user_ids: [String!]!, status: BasicUserStatus): [BasicUser]
+}
+`
+
+func TestGenerate(t *testing.T) {
+	schema, err := introspection.FromSDL(userSDL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	code, err := Generate(schema, Options{Package: "userrpc", Service: "user"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	src := string(code)
+	assert.Regexp(t, `BasicUserStatusNormal\s+BasicUserStatus = "Normal"`, src)
+	assert.Regexp(t, "UpdatedAt\\s+time.Time\\s+`json:\"updated_at\"`", src)
+	assert.Regexp(t, "UserID\\s+string\\s+`json:\"user_id\"`", src)
+	assert.Regexp(t, "Status\\s+\\*BasicUserStatus\\s+`json:\"status,omitempty\"`", src)
+	assert.True(t, strings.Contains(src, "func (c *Client) User(ctx context.Context, args *UserArgs) (*BasicUser, error)"))
+	assert.True(t, strings.Contains(src, "func (c *Client) Users(ctx context.Context, args *UsersArgs) ([]BasicUser, error)"))
+	// friends needs a required argument, so it is not selected
+	assert.True(t, strings.Contains(src, `"query($user_id: String!){user(user_id: $user_id){id nickname status updated_at}}"`))
+}
+
+func TestGoName(t *testing.T) {
+	assert.Equal(t, "UserID", GoName("user_id"))
+	assert.Equal(t, "ParkZone", GoName("PARK_ZONE"))
+	assert.Equal(t, "RefreshToken", GoName("refreshToken"))
+}
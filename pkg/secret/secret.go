@@ -0,0 +1,289 @@
+// Package secret 配置中心中的敏感配置加密存储
+// 每个值使用随机的数据密钥AES-256-GCM加密, 数据密钥再用主密钥加密(信封加密)
+// 格式为secret:v1:<主密钥id>:<base64(加密的数据密钥)>:<base64(密文)>
+// 主密钥来自环境变量APP_SECRET_KEY或者APP_SECRET_KEY_FILE, 解密以后的值注册到mask, 不会出现在日志中
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/microsvs/base/pkg/env"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/mask"
+)
+
+// Prefix 加密值的前缀
+const Prefix = "secret:v1:"
+
+// KeySize 主密钥和数据密钥的长度
+const KeySize = 32
+
+// Keyring 主密钥, 第一个用于加密, 全部用于解密
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring masters为32字节的主密钥, 第一个用于加密, 其他的用于轮换期间解密旧值
+func NewKeyring(masters ...[]byte) (*Keyring, error) {
+	if len(masters) <= 0 {
+		return nil, errors.SecretKeyNotFound
+	}
+	k := &Keyring{keys: make(map[string][]byte)}
+	for idx, master := range masters {
+		if len(master) != KeySize {
+			return nil, errors.SecretMalformed
+		}
+		kid := keyID(master)
+		if idx == 0 {
+			k.current = kid
+		}
+		k.keys[kid] = master
+	}
+	return k, nil
+}
+
+// ParseKeys 解析base64编码的主密钥, 每行一个, 忽略空行和#开头的注释
+func ParseKeys(text string) ([][]byte, error) {
+	var masters [][]byte
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); len(line) <= 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		master, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, errors.SecretMalformed
+		}
+		masters = append(masters, master)
+	}
+	return masters, nil
+}
+
+// LoadKeyring 依次从APP_SECRET_KEY和APP_SECRET_KEY_FILE读取主密钥
+func LoadKeyring() (*Keyring, error) {
+	text, _ := env.Get(env.SecretKey)
+	if len(text) <= 0 {
+		file, _ := env.Get(env.SecretFile)
+		if len(file) <= 0 {
+			return nil, errors.SecretKeyNotFound
+		}
+		bts, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text = string(bts)
+	}
+	masters, err := ParseKeys(text)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(masters...)
+}
+
+// GenerateKey 生成base64编码的随机主密钥
+func GenerateKey() (string, error) {
+	master := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, master); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(master), nil
+}
+
+func keyID(master []byte) string {
+	sum := sha256.Sum256(master)
+	return hex.EncodeToString(sum[:4])
+}
+
+// IsSecret 是否为加密值
+func IsSecret(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Encrypt 使用新的数据密钥加密, 数据密钥用当前主密钥加密
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	dek := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.current], dek)
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(dek, []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + k.current + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt 解密加密值, 不是加密值时原样返回
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsSecret(value) {
+		return value, nil
+	}
+	fields := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(fields) != 3 {
+		return "", errors.SecretMalformed
+	}
+	master, ok := k.keys[fields[0]]
+	if !ok {
+		return "", errors.SecretKeyMismatch
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", errors.SecretMalformed
+	}
+	data, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return "", errors.SecretMalformed
+	}
+	dek, err := open(master, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(dek, data)
+	if err != nil {
+		return "", err
+	}
+	mask.RegisterSecret(string(plain))
+	return string(plain), nil
+}
+
+// Rotate 用当前主密钥重新加密, 返回值是否变化, 不是加密值时原样返回
+func (k *Keyring) Rotate(value string) (string, bool, error) {
+	if !IsSecret(value) || strings.HasPrefix(value, Prefix+k.current+":") {
+		return value, false, nil
+	}
+	plain, err := k.Decrypt(value)
+	if err != nil {
+		return value, false, err
+	}
+	value, err = k.Encrypt(plain)
+	return value, err == nil, err
+}
+
+func seal(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.SecretMalformed
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.SecretMalformed
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	defaultMutex   sync.Mutex
+	defaultKeyring *Keyring
+)
+
+// Default 环境变量中的主密钥, 第一次调用时读取, 读取失败时下次调用重新读取
+func Default() (*Keyring, error) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	if defaultKeyring != nil {
+		return defaultKeyring, nil
+	}
+	k, err := LoadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	defaultKeyring = k
+	return k, nil
+}
+
+// SetDefault 替换默认主密钥, 用于测试或者从其他位置读取主密钥
+func SetDefault(k *Keyring) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultKeyring = k
+}
+
+// Encrypt 使用默认主密钥加密
+func Encrypt(plain string) (string, error) {
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return k.Encrypt(plain)
+}
+
+// Decrypt 使用默认主密钥解密, 不是加密值时不需要主密钥
+func Decrypt(value string) (string, error) {
+	if !IsSecret(value) {
+		return value, nil
+	}
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return k.Decrypt(value)
+}
+
+// String 敏感字符串, 打印和JSON序列化时输出******, 用于typed config的字段
+/* example
+type RedisConfig struct {
+	Addr     string        `kv:"addr"`
+	Password secret.String `kv:"password"`
+}
+redis.Dial(cfg.Addr, cfg.Password.Value())
+*/
+type String string
+
+const masked = "******"
+
+// Value 原始值
+func (s String) Value() string {
+	return string(s)
+}
+
+func (s String) String() string {
+	return masked
+}
+
+func (s String) GoString() string {
+	return masked
+}
+
+func (s String) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + masked + `"`), nil
+}
+
+func (s String) MarshalText() ([]byte, error) {
+	return []byte(masked), nil
+}
@@ -0,0 +1,67 @@
+package secret
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/mask"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKey(t *testing.T) []byte {
+	key, err := GenerateKey()
+	assert.Nil(t, err)
+	master, _ := base64.StdEncoding.DecodeString(key)
+	return master
+}
+
+func TestKeyring(t *testing.T) {
+	var (
+		oldKey, newKey = newKey(t), newKey(t)
+		plain          = "redis-password@127.0.0.1:6379"
+	)
+	old, err := NewKeyring(oldKey)
+	assert.Nil(t, err)
+	value, err := old.Encrypt(plain)
+	assert.Nil(t, err)
+	assert.True(t, IsSecret(value))
+	assert.NotContains(t, value, "redis-password")
+	ret, err := old.Decrypt(value)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, ret)
+	// 解密以后的值不会出现在日志中
+	assert.Equal(t, "connect ****** failed", mask.Redact("connect "+plain+" failed"))
+
+	// 轮换: 新密钥加密, 旧密钥只用于解密
+	cur, err := NewKeyring(newKey, oldKey)
+	assert.Nil(t, err)
+	rotated, changed, err := cur.Rotate(value)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	_, changed, _ = cur.Rotate(rotated)
+	assert.False(t, changed)
+	_, err = old.Decrypt(rotated)
+	assert.Equal(t, errors.SecretKeyMismatch, err)
+	ret, err = cur.Decrypt(rotated)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, ret)
+
+	_, err = cur.Decrypt(rotated[:len(rotated)-4] + "AAAA")
+	assert.Equal(t, errors.SecretMalformed, err)
+	ret, err = cur.Decrypt("127.0.0.1:6379")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:6379", ret)
+}
+
+func TestString(t *testing.T) {
+	cfg := struct {
+		Password String `json:"password"`
+	}{"redis-password"}
+	bts, _ := json.Marshal(cfg)
+	assert.Equal(t, `{"password":"******"}`, string(bts))
+	assert.Equal(t, "{******}", fmt.Sprintf("%v", cfg))
+	assert.Equal(t, "redis-password", cfg.Password.Value())
+}
@@ -10,8 +10,8 @@ type Token struct {
 	UserId             string    `db:"user_id" json:"user_id"`
 	Token              string    `db:"token" json:"token"`
 	TokenExpire        time.Time `db:"token_expire" json:"token_expire"`
-	RefreshToken       string    `db:"refresh_token" "json:"refresh_token"`
-	RefreshTokenExpire time.Time `db:"refresh_token_expire" "json:"refresh_token_expire"`
+	RefreshToken       string    `db:"refresh_token" json:"refresh_token"`
+	RefreshTokenExpire time.Time `db:"refresh_token_expire" json:"refresh_token_expire"`
 }
 
 func (Token) TableName() string {
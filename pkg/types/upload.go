@@ -0,0 +1,45 @@
+package types
+
+import (
+	"os"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Upload multipart请求中上传的文件, 内容保存在临时文件中, 请求结束后删除
+type Upload struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Path        string `json:"-"` // 临时文件路径
+}
+
+// Open 打开上传文件的临时文件, 调用方负责Close
+func (u *Upload) Open() (*os.File, error) {
+	return os.Open(u.Path)
+}
+
+// GLUpload 上传文件, 只能通过变量传入
+/* example
+mutation($file: Upload!) { uploadPicture(file: $file) { url } }
+*/
+var GLUpload = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Upload",
+	Description: "multipart请求上传的文件",
+	Serialize: func(value interface{}) interface{} {
+		if u, ok := value.(*Upload); ok {
+			return u.Filename
+		}
+		return nil
+	},
+	ParseValue: func(value interface{}) interface{} {
+		if u, ok := value.(*Upload); ok {
+			return u
+		}
+		return nil
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		return nil
+	},
+})
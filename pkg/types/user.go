@@ -21,6 +21,11 @@ type User struct {
 	Status    int       `msgpack:"status" db:"status" json:"status"` // -10: 无效；10: 有效
 	UpdatedAt time.Time `msgpack:"updated_at" db:"updated_at" json:"updated_at"`
 	CreatedAt time.Time `msgpack:"created_at" db:"created_at" json:"created_at"`
+
+	// 角色和权限用于字段鉴权, 下游服务无法验证协议头中的角色, 所以不随rpc context传递, 也不序列化到json
+	// 需要时从用户服务读取, GLUser的roles和permissions字段按字段名resolve
+	Roles       []string `mapstructure:"roles" msgpack:"-" db:"-" json:"-"`
+	Permissions []string `mapstructure:"permissions" msgpack:"-" db:"-" json:"-"`
 }
 
 func (User) TableName() string {
@@ -47,6 +52,14 @@ var GLUserConfig = graphql.ObjectConfig{
 			Type:        GLUserStatus,
 			Description: "用户状态",
 		},
+		"roles": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "用户角色",
+		},
+		"permissions": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "用户权限",
+		},
 	},
 }
 var GLUserStatus = graphql.NewEnum(graphql.EnumConfig{
@@ -0,0 +1,119 @@
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+const (
+	ContentTypeJSON           = "application/json"
+	ContentTypeGraphQL        = "application/graphql"
+	ContentTypeFormURLEncoded = "application/x-www-form-urlencoded"
+)
+
+// graphqlRequest graphql请求参数, 解析规则与handler一致
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+	Extensions    *requestExtensions     `json:"extensions,omitempty"`
+}
+
+// requestExtensions 请求扩展字段, 目前只支持persistedQuery
+/* example
+{"extensions":{"persistedQuery":{"version":1,"sha256Hash":"ecf4edb4..."}}}
+*/
+type requestExtensions struct {
+	PersistedQuery *persistedQuery `json:"persistedQuery,omitempty"`
+}
+
+type persistedQuery struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+// persistedHash 请求携带的查询摘要, 没有时返回空
+func (req *graphqlRequest) persistedHash() string {
+	if req.Extensions == nil || req.Extensions.PersistedQuery == nil {
+		return ""
+	}
+	return req.Extensions.PersistedQuery.Sha256Hash
+}
+
+// rewrite 把解析后的请求以json body的形式写回r, 供handler解析
+func (req *graphqlRequest) rewrite(r *http.Request) {
+	body, _ := json.Marshal(&graphqlRequest{
+		Query:         req.Query,
+		Variables:     req.Variables,
+		OperationName: req.OperationName,
+	})
+	r.Method = http.MethodPost
+	r.URL.RawQuery = ""
+	r.Header.Set("Content-Type", ContentTypeJSON)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+}
+
+// readGraphqlRequest 解析请求参数, 读取后恢复r.Body, 不影响handler再次解析
+func readGraphqlRequest(r *http.Request) (*graphqlRequest, error) {
+	var (
+		req  = new(graphqlRequest)
+		body []byte
+		err  error
+	)
+	// url中的query参数优先, 与handler一致
+	if values := r.URL.Query(); len(values.Get("query")) > 0 || len(values.Get("extensions")) > 0 {
+		return requestFromValues(values), nil
+	}
+	if r.Method != http.MethodPost || r.Body == nil {
+		return req, nil
+	}
+	if body, err = ioutil.ReadAll(r.Body); err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case ContentTypeGraphQL:
+		req.Query = string(body)
+	case ContentTypeFormURLEncoded:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		req = requestFromValues(values)
+	default:
+		if err = json.Unmarshal(body, req); err != nil {
+			// variables可能是json字符串
+			var compatible struct {
+				graphqlRequest
+				Variables string `json:"variables"`
+			}
+			if err = json.Unmarshal(body, &compatible); err != nil {
+				return nil, err
+			}
+			req = &compatible.graphqlRequest
+			json.Unmarshal([]byte(compatible.Variables), &req.Variables)
+		}
+	}
+	return req, nil
+}
+
+func requestFromValues(values url.Values) *graphqlRequest {
+	req := &graphqlRequest{
+		Query:         values.Get("query"),
+		OperationName: values.Get("operationName"),
+	}
+	if variables := values.Get("variables"); len(variables) > 0 {
+		json.Unmarshal([]byte(variables), &req.Variables)
+	}
+	if extensions := values.Get("extensions"); len(extensions) > 0 {
+		json.Unmarshal([]byte(extensions), &req.Extensions)
+	}
+	return req
+}
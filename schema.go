@@ -0,0 +1,174 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/env"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/introspection"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/rpc"
+)
+
+// SchemaCheckMode 启动时发现schema破坏了调用方正在使用的字段如何处理
+type SchemaCheckMode int
+
+const (
+	SchemaCheckWarn   SchemaCheckMode = iota // 记录错误日志, 继续启动
+	SchemaCheckRefuse                        // 拒绝启动
+	SchemaCheckOff                           // 不检查
+)
+
+// SchemaHistorySize 配置中心保留的schema版本数量
+var SchemaHistorySize = 20
+
+// SchemaVersion schema的一个历史版本, SDL保存在/schema/<env>/<service>/versions/<hash>
+type SchemaVersion struct {
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+}
+
+// 服务schema在配置中心的保存路径, 比如: /schema/developer/user/hash
+// 使用绝对路径, gateway和调用方与服务本身的APP_NAME不同, 只按APP_ENV区分
+// /schema/<env>/<service>/hash             当前schema的摘要, gateway通过watch该配置更新合并后的schema
+// /schema/<env>/<service>/sdl              当前schema的SDL
+// /schema/<env>/<service>/history          历史版本列表, []SchemaVersion
+// /schema/<env>/<service>/versions/<hash>  历史版本的SDL
+// /schema/<env>/<service>/consumers/<svc>  调用方使用的字段列表, []string
+func schemaKey(service rpc.FGService, name ...string) string {
+	stage, _ := env.Get(env.ServiceENV)
+	return fmt.Sprintf("/schema/%s/%s/%s", stage, service, strings.Join(name, "/"))
+}
+
+// SetSchemaCheck 设置启动时的schema兼容性检查方式, 默认为SchemaCheckWarn
+func (d *Daemon) SetSchemaCheck(mode SchemaCheckMode) {
+	d.mutex.Lock()
+	d.schemaCheck = mode
+	d.mutex.Unlock()
+}
+
+// RegisterSchemaUsage 调用方登记使用的字段, 格式为"Type.field", 服务启动时据此检查不兼容的变更
+/* example
+base.RegisterSchemaUsage(rpc.FGSUser, rpc.FGSOrder, []string{"Query.user", "BasicUser.mobile"})
+*/
+func RegisterSchemaUsage(service, consumer rpc.FGService, fields []string) error {
+	bts, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return discovery.KVWrite(schemaKey(service, "consumers", consumer.String()), string(bts))
+}
+
+// registerSchema 检查schema与上一次发布的版本是否兼容, 然后发布当前schema
+func (d *Daemon) registerSchema() error {
+	s, err := introspection.FromSchema(*d.Schema())
+	if err != nil {
+		log.ErrorRaw("[registerSchema] introspect schema of %s failed. err=%s", d.service, err.Error())
+		return nil
+	}
+	d.mutex.RLock()
+	mode := d.schemaCheck
+	d.mutex.RUnlock()
+	if mode != SchemaCheckOff {
+		if violations := d.checkSchema(s); len(violations) > 0 {
+			for _, violation := range violations {
+				log.ErrorRaw("[registerSchema] %s breaks consumers. %s", d.service, violation)
+			}
+			if mode == SchemaCheckRefuse {
+				return errors.SchemaBreaking
+			}
+		}
+	}
+	go d.publishSchema(s)
+	return nil
+}
+
+// checkSchema 与配置中心的上一个版本比较, 返回影响调用方的不兼容变更
+func (d *Daemon) checkSchema(s *introspection.Schema) []string {
+	prev := discovery.KVRead(schemaKey(d.service, "sdl"), "")
+	if len(prev) <= 0 {
+		return nil
+	}
+	old, err := introspection.FromSDL(prev)
+	if err != nil {
+		log.ErrorRaw("[checkSchema] parse previous schema of %s failed. err=%s", d.service, err.Error())
+		return nil
+	}
+	usages := make(map[string][]string)
+	kvpairs, _ := discovery.KVList(schemaKey(d.service, "consumers"))
+	for _, kvpair := range kvpairs {
+		var fields []string
+		if err = json.Unmarshal(kvpair.Value, &fields); err != nil {
+			log.ErrorRaw("[checkSchema] decode usage %s failed. err=%s", kvpair.Key, err.Error())
+			continue
+		}
+		usages[path.Base(kvpair.Key)] = fields
+	}
+	return breakingUsages(introspection.Diff(old, s), usages)
+}
+
+// breakingUsages 不兼容的变更与调用方使用的字段有包含关系时视为影响调用方
+func breakingUsages(changes []introspection.Change, usages map[string][]string) []string {
+	var ret []string
+	for _, change := range changes {
+		if change.Level != introspection.Breaking {
+			continue
+		}
+		var consumers []string
+		for consumer, fields := range usages {
+			for _, field := range fields {
+				if change.Path == field || strings.HasPrefix(change.Path, field+".") ||
+					strings.HasPrefix(field, change.Path+".") {
+					consumers = append(consumers, consumer)
+					break
+				}
+			}
+		}
+		if len(consumers) > 0 {
+			sort.Strings(consumers)
+			ret = append(ret, fmt.Sprintf("%s used by %s", change, strings.Join(consumers, ",")))
+		}
+	}
+	return ret
+}
+
+// publishSchema 把当前schema发布到配置中心, 并记录历史版本
+func (d *Daemon) publishSchema(s *introspection.Schema) {
+	var (
+		hash    = s.Hash()
+		sdl     = s.SDL()
+		history []SchemaVersion
+	)
+	if prev := discovery.KVRead(schemaKey(d.service, "history"), ""); len(prev) > 0 {
+		if err := json.Unmarshal([]byte(prev), &history); err != nil {
+			log.ErrorRaw("[publishSchema] decode schema history of %s failed. err=%s", d.service, err.Error())
+		}
+	}
+	if len(history) <= 0 || history[len(history)-1].Hash != hash {
+		if err := discovery.KVWrite(schemaKey(d.service, "versions", hash), sdl); err != nil {
+			log.ErrorRaw("[publishSchema] write schema version of %s failed. err=%s", d.service, err.Error())
+		}
+		history = append(history, SchemaVersion{Hash: hash, Time: time.Now()})
+		for len(history) > SchemaHistorySize {
+			discovery.KVDelete(schemaKey(d.service, "versions", history[0].Hash))
+			history = history[1:]
+		}
+		bts, _ := json.Marshal(history)
+		if err := discovery.KVWrite(schemaKey(d.service, "history"), string(bts)); err != nil {
+			log.ErrorRaw("[publishSchema] write schema history of %s failed. err=%s", d.service, err.Error())
+		}
+	}
+	if err := discovery.KVWrite(schemaKey(d.service, "sdl"), sdl); err != nil {
+		log.ErrorRaw("[publishSchema] write schema sdl of %s failed. err=%s", d.service, err.Error())
+	}
+	// 最后写入摘要, gateway收到通知时其他配置已经更新
+	if err := discovery.KVWrite(schemaKey(d.service, "hash"), hash); err != nil {
+		log.ErrorRaw("[publishSchema] write schema hash of %s failed. err=%s", d.service, err.Error())
+	}
+}
@@ -0,0 +1,58 @@
+package base
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/microsvs/base/cmd/discovery"
+	"github.com/microsvs/base/pkg/introspection"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakingUsages(t *testing.T) {
+	old, _ := introspection.FromSDL(`
+type User { id: ID! mobile: String name: String }
+type Query { user(id: ID!): User users: [User] }`)
+	cur, _ := introspection.FromSDL(`
+type User { id: ID! name: String }
+type Query { user(id: ID!, tenant: String!): User }`)
+	changes := introspection.Diff(old, cur)
+	assert.Equal(t, []string{
+		"BREAKING  Query.user.tenant: required argument added used by order",
+		"BREAKING  User.mobile: field removed used by order,pay",
+	}, breakingUsages(changes, map[string][]string{
+		"order": {"Query.user", "User.mobile"},
+		"pay":   {"User"},
+		"web":   {"User.name"},
+	}))
+	assert.Empty(t, breakingUsages(changes, map[string][]string{"web": {"User.name"}}))
+	assert.Equal(t, "/schema/developer/user/consumers/order", schemaKey(rpc.FGSUser, "consumers", "order"))
+}
+
+// 调用方在自己的命名空间下登记使用的字段, 被调用方发布schema时能够读到
+func TestSchemaUsageAcrossServices(t *testing.T) {
+	if os.Getenv("BASE_TEST_SCHEMA_USAGE") == "1" {
+		assert.Nil(t, discovery.Init(discovery.Options{}))
+		assert.Nil(t, RegisterSchemaUsage(rpc.FGSUser, rpc.FGSToken, []string{"BasicUser.nickname"}))
+		return
+	}
+	dir, err := ioutil.TempDir("", "schema")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, discovery.Init(discovery.Options{Config: "file://" + dir}))
+	d := &Daemon{service: rpc.FGSUser}
+	d.publishSchema(mustSDL(t, userServiceSDL))
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSchemaUsageAcrossServices$")
+	cmd.Env = append(os.Environ(), "BASE_TEST_SCHEMA_USAGE=1", "APP_NAME=token", "APP_ZK=file://"+dir)
+	out, err := cmd.CombinedOutput()
+	assert.Nil(t, err, string(out))
+
+	assert.Equal(t, []string{"BREAKING  BasicUser.nickname: field removed used by token"}, d.checkSchema(mustSDL(t, `
+enum BasicUserStatus { Normal Delete }
+type BasicUser { id: String status: BasicUserStatus }
+type Query { user(user_id: String!): BasicUser }`)))
+}
@@ -0,0 +1,597 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/microsvs/base/pkg/utils"
+)
+
+// selectionPrinter 把请求AST中的selection set原样还原为graphql文本
+// 变量使用请求中的值内联, 引用到的fragment定义会一并输出
+type selectionPrinter struct {
+	p         graphql.ResolveParams
+	variables map[string]*ast.VariableDefinition
+	used      map[string]bool
+	order     []string
+}
+
+func newSelectionPrinter(p graphql.ResolveParams) *selectionPrinter {
+	sp := &selectionPrinter{
+		p:         p,
+		variables: make(map[string]*ast.VariableDefinition),
+		used:      make(map[string]bool),
+	}
+	if op, ok := p.Info.Operation.(*ast.OperationDefinition); ok {
+		for _, def := range op.VariableDefinitions {
+			if def.Variable != nil && def.Variable.Name != nil {
+				sp.variables[def.Variable.Name.Value] = def
+			}
+		}
+	}
+	return sp
+}
+
+// selectionSet parent为selection所属的类型, 用于判断是否需要补充__typename
+func (sp *selectionPrinter) selectionSet(ss *ast.SelectionSet, parent graphql.Type) string {
+	if ss == nil || len(ss.Selections) <= 0 {
+		return ""
+	}
+	parent = namedGLType(parent)
+	var (
+		result      []string
+		hasFragment bool
+	)
+	for _, item := range ss.Selections {
+		switch s := item.(type) {
+		case *ast.Field:
+			result = append(result, sp.field(s, parent))
+		case *ast.InlineFragment:
+			str := "..."
+			cond := parent
+			if s.TypeCondition != nil && s.TypeCondition.Name != nil {
+				str += " on " + s.TypeCondition.Name.Value
+				cond = sp.p.Info.Schema.Type(s.TypeCondition.Name.Value)
+			}
+			str += sp.directives(s.Directives) + sp.selectionSet(s.SelectionSet, cond)
+			result = append(result, str)
+			hasFragment = true
+		case *ast.FragmentSpread:
+			if s.Name == nil {
+				continue
+			}
+			sp.useFragment(s.Name.Value)
+			result = append(result, "..."+s.Name.Value+sp.directives(s.Directives))
+			hasFragment = true
+		}
+	}
+	// 接口/联合类型需要__typename才能还原具体类型
+	if hasFragment || isAbstractGLType(parent) {
+		result = append(result, "__typename")
+	}
+	return fmt.Sprintf("{%s}", strings.Join(result, " "))
+}
+
+func (sp *selectionPrinter) field(f *ast.Field, parent graphql.Type) string {
+	var (
+		str   string
+		child graphql.Type
+	)
+	if f.Alias != nil && len(f.Alias.Value) > 0 {
+		str = f.Alias.Value + ":"
+	}
+	if f.Name != nil {
+		str += f.Name.Value
+		if def, ok := glTypeFields(parent)[f.Name.Value]; ok {
+			child = def.Type
+		}
+	}
+	return str + sp.arguments(f.Arguments) + sp.directives(f.Directives) + sp.selectionSet(f.SelectionSet, child)
+}
+
+func (sp *selectionPrinter) arguments(args []*ast.Argument) string {
+	if len(args) <= 0 {
+		return ""
+	}
+	items := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg.Name == nil {
+			continue
+		}
+		items = append(items, fmt.Sprintf("%s:%s", arg.Name.Value, sp.value(arg.Value)))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(items, ", "))
+}
+
+func (sp *selectionPrinter) directives(dirs []*ast.Directive) string {
+	var str string
+	for _, dir := range dirs {
+		if dir.Name == nil {
+			continue
+		}
+		str += " @" + dir.Name.Value + sp.arguments(dir.Arguments)
+	}
+	return str
+}
+
+func (sp *selectionPrinter) value(v ast.Value) string {
+	switch val := v.(type) {
+	case *ast.Variable:
+		if val.Name == nil {
+			return "null"
+		}
+		var typ graphql.Input
+		if def, ok := sp.variables[val.Name.Value]; ok {
+			typ = typeFromAST(sp.p.Info.Schema, def.Type)
+		}
+		return printGLValue(sp.p.Info.VariableValues[val.Name.Value], typ)
+	case *ast.StringValue:
+		return printGLLiteral(val.Value)
+	case *ast.ListValue:
+		items := make([]string, 0, len(val.Values))
+		for _, item := range val.Values {
+			items = append(items, sp.value(item))
+		}
+		return fmt.Sprintf("[%s]", strings.Join(items, ", "))
+	case *ast.ObjectValue:
+		items := make([]string, 0, len(val.Fields))
+		for _, field := range val.Fields {
+			if field.Name == nil {
+				continue
+			}
+			items = append(items, fmt.Sprintf("%s:%s", field.Name.Value, sp.value(field.Value)))
+		}
+		return fmt.Sprintf("{%s}", strings.Join(items, ", "))
+	}
+	return fmt.Sprintf("%v", printer.Print(v))
+}
+
+func (sp *selectionPrinter) useFragment(name string) {
+	if sp.used[name] {
+		return
+	}
+	sp.used[name] = true
+	sp.order = append(sp.order, name)
+}
+
+// fragmentDefinitions 输出selection中引用的所有fragment定义, 包括fragment内部嵌套引用的fragment
+func (sp *selectionPrinter) fragmentDefinitions() string {
+	var result []string
+	for i := 0; i < len(sp.order); i++ {
+		frag, ok := sp.p.Info.Fragments[sp.order[i]].(*ast.FragmentDefinition)
+		if !ok || frag.TypeCondition == nil || frag.TypeCondition.Name == nil {
+			continue
+		}
+		result = append(result, fmt.Sprintf("fragment %s on %s%s%s",
+			sp.order[i],
+			frag.TypeCondition.Name.Value,
+			sp.directives(frag.Directives),
+			sp.selectionSet(frag.SelectionSet, sp.p.Info.Schema.Type(frag.TypeCondition.Name.Value)),
+		))
+	}
+	if len(result) > 0 {
+		return " " + strings.Join(result, " ")
+	}
+	return ""
+}
+
+// namedGLType 去掉List和NonNull以后的类型
+func namedGLType(t graphql.Type) graphql.Type {
+	for {
+		switch typ := t.(type) {
+		case *graphql.List:
+			t = typ.OfType
+		case *graphql.NonNull:
+			t = typ.OfType
+		default:
+			return t
+		}
+	}
+}
+
+func isAbstractGLType(t graphql.Type) bool {
+	switch t.(type) {
+	case *graphql.Interface, *graphql.Union:
+		return true
+	}
+	return false
+}
+
+// glTypeFields 对象和接口类型的字段定义
+func glTypeFields(t graphql.Type) graphql.FieldDefinitionMap {
+	switch typ := namedGLType(t).(type) {
+	case *graphql.Object:
+		return typ.Fields()
+	case *graphql.Interface:
+		return typ.Fields()
+	}
+	return graphql.FieldDefinitionMap{}
+}
+
+// typeFromAST 把变量定义中的类型转化为schema中的graphql.Input
+func typeFromAST(schema graphql.Schema, t ast.Type) graphql.Input {
+	switch typ := t.(type) {
+	case *ast.List:
+		if inner := typeFromAST(schema, typ.Type); inner != nil {
+			return graphql.NewList(inner)
+		}
+	case *ast.NonNull:
+		if inner := typeFromAST(schema, typ.Type); inner != nil {
+			return graphql.NewNonNull(inner)
+		}
+	case *ast.Named:
+		if typ.Name == nil {
+			return nil
+		}
+		if input, ok := schema.Type(typ.Name.Value).(graphql.Input); ok {
+			return input
+		}
+	}
+	return nil
+}
+
+// printGLValue 把go值按graphql输入类型输出为graphql字面量
+/* example
+printGLValue(CEO, GLPositionEnum) // CEO
+printGLValue([]string{"a", "b"}, graphql.NewList(graphql.String)) // ["a", "b"]
+*/
+func printGLValue(v interface{}, typ graphql.Input) string {
+	if v == nil {
+		return "null"
+	}
+	// 上传文件无法内联, 转发时通过变量传递
+	if upload, ok := v.(*types.Upload); ok {
+		return "$" + uploadVariable(upload)
+	}
+	switch t := typ.(type) {
+	case *graphql.NonNull:
+		return printGLValue(v, t.OfType)
+	case *graphql.List:
+		value := reflect.ValueOf(v)
+		if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+			return printGLValue(v, t.OfType)
+		}
+		items := make([]string, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			items = append(items, printGLValue(value.Index(i).Interface(), t.OfType))
+		}
+		return fmt.Sprintf("[%s]", strings.Join(items, ", "))
+	case *graphql.InputObject:
+		imap, ok := v.(map[string]interface{})
+		if !ok {
+			if err := utils.GenericTypeConvert(v, &imap); err != nil {
+				return "null"
+			}
+		}
+		fields := t.Fields()
+		keys := make([]string, 0, len(imap))
+		for key := range imap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, key := range keys {
+			var ftype graphql.Input
+			if field, ok := fields[key]; ok {
+				ftype = field.Type
+			}
+			items = append(items, fmt.Sprintf("%s:%s", key, printGLValue(imap[key], ftype)))
+		}
+		return fmt.Sprintf("{%s}", strings.Join(items, ", "))
+	case *graphql.Enum:
+		if name := t.Serialize(v); name != nil {
+			return fmt.Sprintf("%v", name)
+		}
+		return "null"
+	case *graphql.Scalar:
+		return printGLLiteral(t.Serialize(v))
+	}
+	return printGLLiteral(v)
+}
+
+// printGLLiteral 没有类型信息时按json的值类型输出graphql字面量
+func printGLLiteral(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			items = append(items, printGLLiteral(item))
+		}
+		return fmt.Sprintf("[%s]", strings.Join(items, ", "))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, key := range keys {
+			items = append(items, fmt.Sprintf("%s:%s", key, printGLLiteral(val[key])))
+		}
+		return fmt.Sprintf("{%s}", strings.Join(items, ", "))
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprintf("%v", val)
+	}
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(bts)
+}
+
+// redirectedResult 转发返回的对象数据, key为请求中的response key(有别名时为别名)
+// 只有这种类型的数据才按别名取值, 本地resolve返回的map按字段名取值
+type redirectedResult map[string]interface{}
+
+// RedirectResultMap 把RedirectRequestEx返回的对象数据转化为map[string]interface{}
+/* example
+data, err := RedirectRequest(p, rpc.FGSUser)
+if imap, ok := RedirectResultMap(data); ok {
+	fmt.Println(imap["id"])
+}
+*/
+func RedirectResultMap(v interface{}) (map[string]interface{}, bool) {
+	switch data := v.(type) {
+	case redirectedResult:
+		return data, true
+	case map[string]interface{}:
+		return data, true
+	}
+	return nil, false
+}
+
+// fixRedirectResult 按请求的selection set把目标服务的返回结果转化为go类型
+// 对象数据为redirectedResult, key为请求中的response key(有别名时为别名)
+func fixRedirectResult(
+	data interface{},
+	t graphql.Output,
+	ss *ast.SelectionSet,
+	fragments map[string]ast.Definition,
+	schema graphql.Schema,
+) interface{} {
+	if data == nil {
+		return nil
+	}
+	switch val := t.(type) {
+	case *graphql.NonNull:
+		return fixRedirectResult(data, val.OfType, ss, fragments, schema)
+	case *graphql.List:
+		items, ok := data.([]interface{})
+		if !ok {
+			return data
+		}
+		for idx, item := range items {
+			items[idx] = fixRedirectResult(item, val.OfType, ss, fragments, schema)
+		}
+		return items
+	case *graphql.Enum:
+		return val.ParseValue(data)
+	case *graphql.Scalar:
+		return val.ParseValue(data)
+	case *graphql.Object, *graphql.Interface, *graphql.Union:
+		imap, ok := data.(map[string]interface{})
+		if !ok {
+			return data
+		}
+		fields := compositeFields(t, imap, schema)
+		result := make(redirectedResult, len(imap))
+		for key, value := range imap {
+			result[key] = value
+		}
+		collectRedirectFields(ss, fragments, func(key string, f *ast.Field) {
+			value, present := imap[key]
+			if !present || f.Name == nil {
+				return
+			}
+			if def, ok := fields[f.Name.Value]; ok {
+				result[key] = fixRedirectResult(value, def.Type, f.SelectionSet, fragments, schema)
+			}
+		})
+		return result
+	}
+	return data
+}
+
+// compositeFields 获取对象数据对应的字段定义, 接口和联合类型根据__typename确定具体类型
+func compositeFields(t graphql.Output, imap map[string]interface{}, schema graphql.Schema) graphql.FieldDefinitionMap {
+	if name, ok := imap["__typename"].(string); ok {
+		if obj, ok := schema.Type(name).(*graphql.Object); ok {
+			return obj.Fields()
+		}
+	}
+	return glTypeFields(t)
+}
+
+// collectRedirectFields 遍历selection set中的所有字段, 展开inline fragment和fragment spread
+func collectRedirectFields(ss *ast.SelectionSet, fragments map[string]ast.Definition, fn func(key string, f *ast.Field)) {
+	if ss == nil {
+		return
+	}
+	for _, item := range ss.Selections {
+		switch s := item.(type) {
+		case *ast.Field:
+			fn(responseKey(s), s)
+		case *ast.InlineFragment:
+			collectRedirectFields(s.SelectionSet, fragments, fn)
+		case *ast.FragmentSpread:
+			if s.Name == nil {
+				continue
+			}
+			if frag, ok := fragments[s.Name.Value].(*ast.FragmentDefinition); ok {
+				collectRedirectFields(frag.SelectionSet, fragments, fn)
+			}
+		}
+	}
+}
+
+func responseKey(f *ast.Field) string {
+	if f.Alias != nil && len(f.Alias.Value) > 0 {
+		return f.Alias.Value
+	}
+	if f.Name != nil {
+		return f.Name.Value
+	}
+	return ""
+}
+
+// installed 安装到Daemon的字段和接口类型被包装之前的resolver, 重复安装和复制字段时使用
+// 按安装的次数引用计数, UpdateSchema释放旧schema以后不再使用的类型被删除
+var installed = struct {
+	sync.Mutex
+	fields map[*graphql.FieldDefinition]*fieldResolvers
+	types  map[graphql.Abstract]*typeResolver
+}{
+	fields: make(map[*graphql.FieldDefinition]*fieldResolvers),
+	types:  make(map[graphql.Abstract]*typeResolver),
+}
+
+type fieldResolvers struct {
+	resolve   graphql.FieldResolveFn
+	subscribe graphql.FieldResolveFn
+	refs      int
+}
+
+type typeResolver struct {
+	resolveType graphql.ResolveTypeFn
+	refs        int
+}
+
+// walkSchemaFields 遍历schema中所有自定义对象类型的字段, fn可以包装字段的resolver
+func walkSchemaFields(schema *graphql.Schema, fn func(obj *graphql.Object, field *graphql.FieldDefinition)) {
+	for name, typ := range schema.TypeMap() {
+		obj, ok := typ.(*graphql.Object)
+		if !ok || strings.HasPrefix(name, "__") {
+			continue
+		}
+		for _, field := range obj.Fields() {
+			fn(obj, field)
+		}
+	}
+}
+
+// walkSchemaAbstracts 遍历schema中所有接口和联合类型
+func walkSchemaAbstracts(schema *graphql.Schema, fn func(abstract graphql.Abstract, resolveType *graphql.ResolveTypeFn)) {
+	for _, typ := range schema.TypeMap() {
+		switch abstract := typ.(type) {
+		case *graphql.Interface:
+			fn(abstract, &abstract.ResolveType)
+		case *graphql.Union:
+			fn(abstract, &abstract.ResolveType)
+		}
+	}
+}
+
+// unwrappedResolvers 没有经过Daemon包装的resolver
+func unwrappedResolvers(field *graphql.FieldDefinition) (resolve, subscribe graphql.FieldResolveFn) {
+	installed.Lock()
+	defer installed.Unlock()
+	if orig, ok := installed.fields[field]; ok {
+		return orig.resolve, orig.subscribe
+	}
+	return field.Resolve, field.Subscribe
+}
+
+// installRedirectResolvers 使默认resolve的字段能够按别名读取转发的结果
+// 同时让接口和联合类型能够根据__typename解析转发结果的具体类型
+// 所有字段的resolver先恢复为原始的resolver, 重复安装或者多个Daemon共用类型时包装不会叠加
+func installRedirectResolvers(schema *graphql.Schema) {
+	installed.Lock()
+	defer installed.Unlock()
+	walkSchemaFields(schema, func(obj *graphql.Object, field *graphql.FieldDefinition) {
+		orig, ok := installed.fields[field]
+		if !ok {
+			orig = &fieldResolvers{resolve: field.Resolve, subscribe: field.Subscribe}
+			installed.fields[field] = orig
+		}
+		orig.refs++
+		field.Resolve, field.Subscribe = orig.resolve, orig.subscribe
+		if field.Resolve == nil {
+			field.Resolve = redirectResolve(graphql.DefaultResolveFn)
+		}
+	})
+	walkSchemaAbstracts(schema, func(abstract graphql.Abstract, resolveType *graphql.ResolveTypeFn) {
+		orig, ok := installed.types[abstract]
+		if !ok {
+			orig = &typeResolver{resolveType: *resolveType}
+			installed.types[abstract] = orig
+		}
+		orig.refs++
+		*resolveType = redirectResolveType(abstract, orig.resolveType)
+	})
+}
+
+// releaseResolvers 释放installRedirectResolvers记录的原始resolver
+// 已经在执行的请求仍然使用包装以后的resolver, 所以不恢复字段的resolver
+func releaseResolvers(schema *graphql.Schema) {
+	installed.Lock()
+	defer installed.Unlock()
+	walkSchemaFields(schema, func(obj *graphql.Object, field *graphql.FieldDefinition) {
+		if orig, ok := installed.fields[field]; ok {
+			if orig.refs--; orig.refs <= 0 {
+				delete(installed.fields, field)
+			}
+		}
+	})
+	walkSchemaAbstracts(schema, func(abstract graphql.Abstract, resolveType *graphql.ResolveTypeFn) {
+		if orig, ok := installed.types[abstract]; ok {
+			if orig.refs--; orig.refs <= 0 {
+				delete(installed.types, abstract)
+			}
+		}
+	})
+}
+
+// redirectResolve 转发结果按别名保存, 数据来自转发并且字段有别名时按别名取值
+func redirectResolve(next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		data, ok := p.Source.(redirectedResult)
+		if !ok {
+			return next(p)
+		}
+		if len(p.Info.FieldASTs) > 0 {
+			if f := p.Info.FieldASTs[0]; f.Alias != nil && len(f.Alias.Value) > 0 {
+				if value, ok := data[f.Alias.Value]; ok {
+					return value, nil
+				}
+			}
+		}
+		p.Source = map[string]interface{}(data)
+		return next(p)
+	}
+}
+
+func redirectResolveType(abstract graphql.Abstract, next graphql.ResolveTypeFn) graphql.ResolveTypeFn {
+	return func(p graphql.ResolveTypeParams) *graphql.Object {
+		if data, ok := RedirectResultMap(p.Value); ok {
+			if name, ok := data["__typename"].(string); ok {
+				if obj, ok := p.Info.Schema.Type(name).(*graphql.Object); ok {
+					return obj
+				}
+			}
+		}
+		if next != nil {
+			return next(p)
+		}
+		// 与graphql-go未设置ResolveType时的行为一致, 依次使用IsTypeOf判断
+		for _, obj := range p.Info.Schema.PossibleTypes(abstract) {
+			if obj.IsTypeOf != nil && obj.IsTypeOf(graphql.IsTypeOfParams{
+				Value:   p.Value,
+				Info:    p.Info,
+				Context: p.Context,
+			}) {
+				return obj
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,248 @@
+package base
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/segmentio/ksuid"
+)
+
+const ContentTypeEventStream = "text/event-stream"
+
+var (
+	// 心跳间隔, 防止代理关闭空闲连接
+	SSEHeartbeat = 15 * time.Second
+	// 每个订阅保留的最近事件数, 用于Last-Event-ID续传
+	SSEReplaySize = 100
+	// 连接断开后订阅继续保留的时间, 超时后取消
+	SSEResumeTimeout = 30 * time.Second
+	// 单个连接待发送的事件数, 超过后断开连接, 客户端通过Last-Event-ID续传
+	sseListenerSize = 64
+)
+
+// sseEvent 事件id格式为: <stream>:<seq>
+type sseEvent struct {
+	Seq   int64
+	Event string
+	Data  []byte
+}
+
+// sseStream 一个订阅的执行结果, 连接断开后在SSEResumeTimeout内可以续传
+type sseStream struct {
+	mutex       sync.Mutex
+	id          string
+	fingerprint string
+	seq         int64
+	events      []sseEvent
+	listener    chan sseEvent
+	cancel      context.CancelFunc
+	timer       *time.Timer
+}
+
+type sseBroker struct {
+	mutex   sync.Mutex
+	streams map[string]*sseStream
+}
+
+func acceptEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ContentTypeEventStream)
+}
+
+// serveSSE 使用text/event-stream返回订阅结果, 认证与普通请求一致
+/* example
+GET /graphql?query=subscription{orderChanged(id:"1001"){status}}&token=xxxx
+Accept: text/event-stream
+Last-Event-ID: 1BnbVjoiRtmkgPY4vSk5DnIgRAx:3
+*/
+func (d *Daemon) serveSSE(ctx context.Context, w http.ResponseWriter, r *http.Request, req *graphqlRequest) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.FGEInvalidOperation
+	}
+	var (
+		fingerprint  = sseFingerprint(ctx, req)
+		stream, last = d.sse.resume(lastEventID(r), fingerprint)
+	)
+	if stream == nil {
+		// Daemon.execute只为query和mutation创建DataLoader, 订阅事件不共用缓存
+		ctx, cancel := context.WithCancel(ctx)
+		stream = d.sse.start(fingerprint, cancel)
+		go stream.run(d.execute(ctx, req))
+	}
+	replay, ch := stream.attach(last)
+	defer stream.detach(ch, func() { d.sse.remove(stream) })
+
+	w.Header().Set("Content-Type", ContentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, ev := range replay {
+		if writeSSEEvent(w, stream.id, ev); ev.Event == wsComplete {
+			flusher.Flush()
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(SSEHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case ev, ok := <-ch:
+			if !ok {
+				// 连接处理不过来或者被新连接接管
+				return nil
+			}
+			writeSSEEvent(w, stream.id, ev)
+			if ev.Event == wsComplete {
+				flusher.Flush()
+				return nil
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, stream string, ev sseEvent) {
+	fmt.Fprintf(w, "id: %s:%d\nevent: %s\ndata: %s\n\n", stream, ev.Seq, ev.Event, ev.Data)
+}
+
+// lastEventID EventSource重连时使用header, 也支持url参数
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); len(id) > 0 {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// sseFingerprint 续传时校验用户和请求一致, 避免读取其他用户的订阅
+func sseFingerprint(ctx context.Context, req *graphqlRequest) string {
+	var uid string
+	if user, ok := ctx.Value(rpc.KeyUser).(*types.User); ok && user != nil {
+		uid = user.ID
+	}
+	variables, _ := json.Marshal(req.Variables)
+	sum := sha256.Sum256([]byte(strings.Join(
+		[]string{uid, req.Query, string(variables), req.OperationName}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *sseBroker) start(fingerprint string, cancel context.CancelFunc) *sseStream {
+	stream := &sseStream{
+		id:          ksuid.New().String(),
+		fingerprint: fingerprint,
+		cancel:      cancel,
+	}
+	b.mutex.Lock()
+	if b.streams == nil {
+		b.streams = make(map[string]*sseStream)
+	}
+	b.streams[stream.id] = stream
+	b.mutex.Unlock()
+	return stream
+}
+
+// resume 根据Last-Event-ID查找仍在保留期内的订阅, 返回订阅和已收到的序号
+func (b *sseBroker) resume(lastID, fingerprint string) (*sseStream, int64) {
+	fields := strings.SplitN(lastID, ":", 2)
+	if len(fields) != 2 {
+		return nil, 0
+	}
+	seq, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	stream, ok := b.streams[fields[0]]
+	if !ok || stream.fingerprint != fingerprint {
+		return nil, 0
+	}
+	return stream, seq
+}
+
+func (b *sseBroker) remove(stream *sseStream) {
+	b.mutex.Lock()
+	delete(b.streams, stream.id)
+	b.mutex.Unlock()
+	stream.cancel()
+}
+
+// run 把执行结果写入缓冲区, 结束时追加complete事件
+func (s *sseStream) run(results chan *graphql.Result) {
+	for result := range results {
+		data, _ := json.Marshal(result)
+		s.push(wsNext, data)
+	}
+	s.push(wsComplete, []byte("{}"))
+}
+
+func (s *sseStream) push(event string, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq++
+	ev := sseEvent{Seq: s.seq, Event: event, Data: data}
+	s.events = append(s.events, ev)
+	if len(s.events) > SSEReplaySize {
+		s.events = s.events[len(s.events)-SSEReplaySize:]
+	}
+	if s.listener == nil {
+		return
+	}
+	select {
+	case s.listener <- ev:
+	default:
+		close(s.listener)
+		s.listener = nil
+	}
+}
+
+// attach 返回last之后的缓存事件和后续事件的channel, 已有连接会被关闭
+func (s *sseStream) attach(last int64) ([]sseEvent, chan sseEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.listener != nil {
+		close(s.listener)
+	}
+	var replay []sseEvent
+	for _, ev := range s.events {
+		if ev.Seq > last {
+			replay = append(replay, ev)
+		}
+	}
+	s.listener = make(chan sseEvent, sseListenerSize)
+	return replay, s.listener
+}
+
+// detach 连接断开, SSEResumeTimeout内没有续传则调用expire
+func (s *sseStream) detach(ch chan sseEvent, expire func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == ch {
+		s.listener = nil
+	}
+	if s.listener == nil && s.timer == nil {
+		s.timer = time.AfterFunc(SSEResumeTimeout, expire)
+	}
+}
@@ -0,0 +1,122 @@
+package base
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/dataloader"
+	"github.com/microsvs/base/pkg/pubsub"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// readSSEEvent 读取一个事件, 忽略心跳注释
+func readSSEEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	ev := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		line = strings.TrimRight(line, "\n")
+		if len(line) <= 0 {
+			if len(ev) > 0 {
+				return ev
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		fields := strings.SplitN(line, ": ", 2)
+		ev[fields[0]] = fields[1]
+	}
+}
+
+func TestSubscriptionSSE(t *testing.T) {
+	ps := pubsub.NewMemory()
+	server, _ := newSubscriptionServer(t, ps)
+	defer server.Close()
+	query := url.QueryEscape("subscription { companyChanged { name } }")
+	get := func(lastID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest("GET", server.URL+"/graphql?query="+query, nil)
+		req.Header.Set("Accept", ContentTypeEventStream)
+		if len(lastID) > 0 {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, ContentTypeEventStream, resp.Header.Get("Content-Type"))
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	resp, reader := get("")
+	time.Sleep(50 * time.Millisecond)
+	pubsub.Publish(ps, "company", map[string]interface{}{"name": "a"})
+	ev := readSSEEvent(t, reader)
+	assert.Equal(t, wsNext, ev["event"])
+	assert.JSONEq(t, `{"data":{"companyChanged":{"name":"a"}}}`, ev["data"])
+	resp.Body.Close()
+
+	// 断开期间的事件在续传时补发
+	time.Sleep(50 * time.Millisecond)
+	pubsub.Publish(ps, "company", map[string]interface{}{"name": "b"})
+	time.Sleep(50 * time.Millisecond)
+	resp, reader = get(ev["id"])
+	defer resp.Body.Close()
+	ev = readSSEEvent(t, reader)
+	assert.JSONEq(t, `{"data":{"companyChanged":{"name":"b"}}}`, ev["data"])
+	assert.True(t, strings.HasSuffix(ev["id"], ":2"))
+}
+
+// SSE订阅的每个事件重新加载DataLoader的数据
+func TestSubscriptionSSEDataLoader(t *testing.T) {
+	var loads int32
+	ps := pubsub.NewMemory()
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: graphql.Fields{"ping": &graphql.Field{Type: graphql.String}},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Subscription",
+			Fields: graphql.Fields{
+				"companyName": &graphql.Field{
+					Type: graphql.String,
+					Subscribe: pubsub.Subscriber(ps, func(p graphql.ResolveParams) string {
+						return "company"
+					}),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						loader := dataloader.For(p.Context, "company", func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+							return map[string]interface{}{keys[0]: fmt.Sprint(atomic.AddInt32(&loads, 1))}, nil
+						})
+						return loader.Load(p.Context, "1")()
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	server := httptest.NewServer(&Daemon{service: rpc.FGSGateway, schema: &schema})
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/graphql?query="+url.QueryEscape("subscription { companyName }"), nil)
+	req.Header.Set("Accept", ContentTypeEventStream)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	time.Sleep(50 * time.Millisecond)
+	for _, want := range []string{"1", "2"} {
+		pubsub.Publish(ps, "company", map[string]interface{}{})
+		ev := readSSEEvent(t, reader)
+		assert.JSONEq(t, `{"data":{"companyName":"`+want+`"}}`, ev["data"])
+	}
+}
@@ -0,0 +1,385 @@
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/microsvs/base/pkg/dataloader"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/rpc"
+)
+
+// websocket子协议
+const (
+	// subscriptions-transport-ws, apollo旧版本客户端使用
+	ProtocolGraphqlWS = "graphql-ws"
+	// graphql-ws, 新版本客户端使用
+	ProtocolGraphqlTransportWS = "graphql-transport-ws"
+)
+
+// 消息类型, 两个协议中含义相同但名称不同的类型以注释区分
+const (
+	wsConnectionInit      = "connection_init"
+	wsConnectionAck       = "connection_ack"
+	wsConnectionError     = "connection_error"     // graphql-ws
+	wsConnectionTerminate = "connection_terminate" // graphql-ws
+	wsKeepAlive           = "ka"                   // graphql-ws
+	wsStart               = "start"                // graphql-ws
+	wsStop                = "stop"                 // graphql-ws
+	wsData                = "data"                 // graphql-ws
+	wsSubscribe           = "subscribe"            // graphql-transport-ws
+	wsNext                = "next"                 // graphql-transport-ws
+	wsPing                = "ping"                 // graphql-transport-ws
+	wsPong                = "pong"                 // graphql-transport-ws
+	wsError               = "error"
+	wsComplete            = "complete"
+)
+
+// graphql-transport-ws关闭连接的错误码
+const (
+	wsCloseBadRequest      = 4400
+	wsCloseUnauthorized    = 4401
+	wsCloseForbidden       = 4403
+	wsCloseInitTimeout     = 4408
+	wsCloseSubscriberExist = 4409
+	wsCloseTooManyInit     = 4429
+)
+
+var (
+	// graphql-ws协议的心跳间隔
+	SubscriptionKeepAlive = 30 * time.Second
+	// 建立连接后等待connection_init的时间
+	SubscriptionInitTimeout = 10 * time.Second
+)
+
+// SetAllowedOrigins 设置允许建立websocket连接的跨域来源, 比如https://www.example.com, *表示不限制
+// 未设置时只允许同源和没有Origin的请求(非浏览器客户端)
+func (d *Daemon) SetAllowedOrigins(origins ...string) {
+	d.mutex.Lock()
+	d.origins = append([]string{}, origins...)
+	d.mutex.Unlock()
+}
+
+// checkOrigin token可以放在url中, 不校验来源时其他网站可以借用户的token建立连接
+func (d *Daemon) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) <= 0 {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	for _, allowed := range d.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	log.ErrorRaw("[checkOrigin] reject websocket from %s", origin)
+	return false
+}
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsConnection 一个websocket连接, 可以同时执行多个订阅
+type wsConnection struct {
+	daemon   *Daemon
+	r        *http.Request
+	conn     *websocket.Conn
+	protocol string
+
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	ctx        context.Context // connection_init之后有效
+	cancel     context.CancelFunc
+	subs       map[string]context.CancelFunc
+}
+
+// serveSubscription 升级为websocket连接, 与/graphql使用同一个端口和路径
+func (d *Daemon) serveSubscription(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{ProtocolGraphqlTransportWS, ProtocolGraphqlWS},
+		CheckOrigin:  d.checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.ErrorRaw("[serveSubscription] upgrade websocket failed. err=%s", err.Error())
+		return
+	}
+	c := &wsConnection{
+		daemon:   d,
+		r:        r,
+		conn:     conn,
+		protocol: conn.Subprotocol(),
+		subs:     make(map[string]context.CancelFunc),
+	}
+	if len(c.protocol) <= 0 {
+		c.protocol = ProtocolGraphqlWS
+	}
+	c.serve()
+}
+
+func (c *wsConnection) serve() {
+	defer c.close()
+	timer := time.AfterFunc(SubscriptionInitTimeout, func() {
+		if !c.initialized() {
+			c.closeWithCode(wsCloseInitTimeout, "connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+	for {
+		var msg wsMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				c.closeWithCode(wsCloseBadRequest, "invalid message")
+			}
+			return
+		}
+		switch msg.Type {
+		case wsConnectionInit:
+			if c.initialized() {
+				c.closeWithCode(wsCloseTooManyInit, "too many initialisation requests")
+				return
+			}
+			if !c.init(msg.Payload) {
+				return
+			}
+		case wsStart, wsSubscribe:
+			if !c.initialized() {
+				c.closeWithCode(wsCloseUnauthorized, "unauthorized")
+				return
+			}
+			if !c.subscribe(msg.ID, msg.Payload) {
+				return
+			}
+		case wsStop, wsComplete:
+			c.unsubscribe(msg.ID)
+		case wsPing:
+			c.write(&wsMessage{Type: wsPong})
+		case wsPong:
+		case wsConnectionTerminate:
+			return
+		default:
+			c.closeWithCode(wsCloseBadRequest, "unknown message type "+msg.Type)
+			return
+		}
+	}
+}
+
+func (c *wsConnection) initialized() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ctx != nil
+}
+
+// init 使用connection_init中的token认证, 没有时使用url中的token, 与buildContext一致
+/* example
+{"type":"connection_init","payload":{"token":"xxxx"}}
+*/
+func (c *wsConnection) init(payload json.RawMessage) bool {
+	var params struct {
+		Token string `json:"token"`
+	}
+	if len(payload) > 0 {
+		json.Unmarshal(payload, &params)
+	}
+	if len(params.Token) <= 0 {
+		params.Token = getTokenFromRequest(c.r)
+	}
+	ctx, err := buildContextWithToken(c.r, params.Token)
+	if err != nil {
+		log.ErrorRaw("[wsConnection.init] authenticate failed. err=%s", err.Error())
+		if c.protocol == ProtocolGraphqlWS {
+			bts, _ := json.Marshal(customErrorFormat(gqlerrors.FormatErrors(err)))
+			c.write(&wsMessage{Type: wsConnectionError, Payload: bts})
+		}
+		c.closeWithCode(wsCloseForbidden, "forbidden")
+		return false
+	}
+	ctx = context.WithValue(ctx, rpc.KeyService, c.daemon.service.String())
+	c.mutex.Lock()
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.mutex.Unlock()
+	c.write(&wsMessage{Type: wsConnectionAck})
+	if c.protocol == ProtocolGraphqlWS {
+		c.write(&wsMessage{Type: wsKeepAlive})
+		go c.keepAlive()
+	}
+	return true
+}
+
+func (c *wsConnection) keepAlive() {
+	ticker := time.NewTicker(SubscriptionKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.write(&wsMessage{Type: wsKeepAlive}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConnection) subscribe(id string, payload json.RawMessage) bool {
+	var req = new(graphqlRequest)
+	if err := json.Unmarshal(payload, req); err != nil || len(id) <= 0 {
+		c.closeWithCode(wsCloseBadRequest, "invalid subscribe message")
+		return false
+	}
+	c.mutex.Lock()
+	if _, ok := c.subs[id]; ok {
+		c.mutex.Unlock()
+		c.closeWithCode(wsCloseSubscriberExist, "subscriber for "+id+" already exists")
+		return false
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.subs[id] = cancel
+	c.mutex.Unlock()
+
+	// 与http请求一样先补全持久化查询并校验白名单, 再校验查询限制
+	err := c.daemon.resolvePersistedQuery(nil, req)
+	if err == nil {
+		err = c.daemon.checkQueryLimits(req)
+	}
+	if err != nil {
+		c.sendError(id, err)
+		c.unsubscribe(id)
+		return true
+	}
+	go c.execute(ctx, id, req)
+	return true
+}
+
+func (c *wsConnection) unsubscribe(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if cancel, ok := c.subs[id]; ok {
+		cancel()
+		delete(c.subs, id)
+	}
+}
+
+// execute 执行请求, subscription以外的操作只返回一次结果, websocket和SSE共用
+// graphql-go的所有subscription事件共用同一个context, 请求级别的DataLoader只用于query和mutation,
+// 否则缓存的结果(比如用户角色)在整个订阅期间不会更新
+func (d *Daemon) execute(ctx context.Context, req *graphqlRequest) chan *graphql.Result {
+	var (
+		results chan *graphql.Result
+		params  = graphql.Params{
+			Schema:         *d.Schema(),
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        ctx,
+		}
+	)
+	if isSubscriptionRequest(req) {
+		return graphql.Subscribe(params)
+	}
+	params.Context = dataloader.WithRegistry(ctx)
+	results = make(chan *graphql.Result, 1)
+	results <- graphql.Do(params)
+	close(results)
+	return results
+}
+
+func (c *wsConnection) execute(ctx context.Context, id string, req *graphqlRequest) {
+	results := c.daemon.execute(ctx, req)
+	for {
+		select {
+		case <-ctx.Done():
+			// 取消后graphql-go可能阻塞在发送结果上, 读空channel让其退出
+			go func() {
+				for range results {
+				}
+			}()
+			return
+		case result, ok := <-results:
+			if !ok {
+				c.write(&wsMessage{ID: id, Type: wsComplete})
+				c.unsubscribe(id)
+				return
+			}
+			bts, _ := json.Marshal(result)
+			typ := wsNext
+			if c.protocol == ProtocolGraphqlWS {
+				typ = wsData
+			}
+			c.write(&wsMessage{ID: id, Type: typ, Payload: bts})
+		}
+	}
+}
+
+// sendError 执行前的错误, graphql-transport-ws的payload为错误列表
+func (c *wsConnection) sendError(id string, err error) {
+	var payload interface{} = gqlerrors.FormatErrors(err)
+	if c.protocol == ProtocolGraphqlWS {
+		payload = gqlerrors.FormatErrors(err)[0]
+	}
+	bts, _ := json.Marshal(payload)
+	c.write(&wsMessage{ID: id, Type: wsError, Payload: bts})
+}
+
+func (c *wsConnection) write(msg *wsMessage) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsConnection) closeWithCode(code int, reason string) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.conn.Close()
+}
+
+func (c *wsConnection) close() {
+	c.mutex.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.subs = make(map[string]context.CancelFunc)
+	c.mutex.Unlock()
+	c.conn.Close()
+}
+
+// isSubscriptionRequest 请求中要执行的操作是否为subscription
+func isSubscriptionRequest(req *graphqlRequest) bool {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if req.OperationName == "" || (op.Name != nil && op.Name.Value == req.OperationName) {
+			return op.Operation == ast.OperationTypeSubscription
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/cache"
+	"github.com/microsvs/base/pkg/dataloader"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/pubsub"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSubscriptionServer(t *testing.T, ps pubsub.PubSub) (*httptest.Server, *Daemon) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"ping": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return "pong", nil
+					},
+				},
+			},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Subscription",
+			Fields: graphql.Fields{
+				"companyChanged": &graphql.Field{
+					Type: GLCompany,
+					Subscribe: pubsub.Subscriber(ps, func(p graphql.ResolveParams) string {
+						return "company"
+					}),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	d := &Daemon{service: rpc.FGSGateway, schema: &schema}
+	return httptest.NewServer(d), d
+}
+
+func dialSubscription(t *testing.T, server *httptest.Server, protocol string) *websocket.Conn {
+	dialer := websocket.Dialer{Subprotocols: []string{protocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/graphql", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, protocol, conn.Subprotocol())
+	assert.Nil(t, conn.WriteJSON(map[string]interface{}{"type": "connection_init"}))
+	var msg wsMessage
+	assert.Nil(t, conn.ReadJSON(&msg))
+	assert.Equal(t, wsConnectionAck, msg.Type)
+	return conn
+}
+
+func TestSubscriptionTransportWS(t *testing.T) {
+	ps := pubsub.NewMemory()
+	server, _ := newSubscriptionServer(t, ps)
+	defer server.Close()
+	conn := dialSubscription(t, server, ProtocolGraphqlTransportWS)
+	defer conn.Close()
+
+	conn.WriteJSON(map[string]interface{}{
+		"id":      "1",
+		"type":    wsSubscribe,
+		"payload": map[string]interface{}{"query": "subscription { companyChanged { name } }"},
+	})
+	time.Sleep(50 * time.Millisecond)
+	pubsub.Publish(ps, "company", map[string]interface{}{"name": "xhj"})
+
+	var msg wsMessage
+	assert.Nil(t, conn.ReadJSON(&msg))
+	assert.Equal(t, wsNext, msg.Type)
+	assert.Equal(t, "1", msg.ID)
+	assert.JSONEq(t, `{"data":{"companyChanged":{"name":"xhj"}}}`, string(msg.Payload))
+
+	// 查询只返回一次结果
+	conn.WriteJSON(map[string]interface{}{
+		"id":      "2",
+		"type":    wsSubscribe,
+		"payload": map[string]interface{}{"query": "{ ping }"},
+	})
+	assert.Nil(t, conn.ReadJSON(&msg))
+	assert.Equal(t, wsNext, msg.Type)
+	assert.JSONEq(t, `{"data":{"ping":"pong"}}`, string(msg.Payload))
+	assert.Nil(t, conn.ReadJSON(&msg))
+	assert.Equal(t, wsComplete, msg.Type)
+	assert.Equal(t, "2", msg.ID)
+}
+
+func TestSubscriptionGraphqlWS(t *testing.T) {
+	ps := pubsub.NewMemory()
+	server, _ := newSubscriptionServer(t, ps)
+	defer server.Close()
+	conn := dialSubscription(t, server, ProtocolGraphqlWS)
+	defer conn.Close()
+
+	var msg wsMessage
+	assert.Nil(t, conn.ReadJSON(&msg))
+	assert.Equal(t, wsKeepAlive, msg.Type)
+
+	conn.WriteJSON(map[string]interface{}{
+		"id":      "1",
+		"type":    wsStart,
+		"payload": map[string]interface{}{"query": "subscription { companyChanged { name } }"},
+	})
+	time.Sleep(50 * time.Millisecond)
+	bts, _ := json.Marshal(map[string]interface{}{"name": "xhj"})
+	ps.Publish("company", bts)
+	assert.Nil(t, conn.ReadJSON(&msg))
+	assert.Equal(t, wsData, msg.Type)
+	assert.JSONEq(t, `{"data":{"companyChanged":{"name":"xhj"}}}`, string(msg.Payload))
+}
+
+// 跨域的websocket连接需要在SetAllowedOrigins中设置
+func TestSubscriptionOrigin(t *testing.T) {
+	server, d := newSubscriptionServer(t, pubsub.NewMemory())
+	defer server.Close()
+	dial := func(origin string) error {
+		header := http.Header{}
+		header.Set("Origin", origin)
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/graphql", header)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	assert.Nil(t, dial(server.URL))
+	assert.NotNil(t, dial("https://evil.example.com"))
+	d.SetAllowedOrigins("https://www.example.com")
+	assert.Nil(t, dial("https://www.example.com"))
+	assert.NotNil(t, dial("https://evil.example.com"))
+}
+
+// websocket上的查询同样受白名单限制
+func TestSubscriptionAllowList(t *testing.T) {
+	server, d := newSubscriptionServer(t, pubsub.NewMemory())
+	defer server.Close()
+	conn, err := cache.NewMemoryConnection()
+	assert.Nil(t, err)
+	d.SetPersistedQueries(NewCacheQueryStore(conn, rpc.FGSGateway), true)
+	ws := dialSubscription(t, server, ProtocolGraphqlTransportWS)
+	defer ws.Close()
+
+	ws.WriteJSON(map[string]interface{}{
+		"id":      "1",
+		"type":    wsSubscribe,
+		"payload": map[string]interface{}{"query": "{ ping }"},
+	})
+	var msg wsMessage
+	assert.Nil(t, ws.ReadJSON(&msg))
+	assert.Equal(t, wsError, msg.Type)
+	assert.Contains(t, string(msg.Payload), strconv.Itoa(int(errors.FGEQueryNotAllowed)))
+}
+
+// subscription的每个事件重新加载DataLoader的数据, query内的重复加载被合并
+func TestSubscriptionDataLoader(t *testing.T) {
+	var loads int32
+	load := func(p graphql.ResolveParams) (interface{}, error) {
+		loader := dataloader.For(p.Context, "company", func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			return map[string]interface{}{keys[0]: "xhj"}, nil
+		})
+		return loader.Load(p.Context, "1")()
+	}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"name":  &graphql.Field{Type: graphql.String, Resolve: load},
+				"title": &graphql.Field{Type: graphql.String, Resolve: load},
+			},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Subscription",
+			Fields: graphql.Fields{
+				"companyName": &graphql.Field{
+					Type: graphql.String,
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						ch := make(chan interface{}, 2)
+						ch <- 1
+						ch <- 2
+						close(ch)
+						return ch, nil
+					},
+					Resolve: load,
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	d := &Daemon{schema: &schema}
+
+	for range d.execute(context.Background(), &graphqlRequest{Query: "{ name title }"}) {
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	atomic.StoreInt32(&loads, 0)
+	for range d.execute(context.Background(), &graphqlRequest{Query: "subscription { companyName }"}) {
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+}
@@ -0,0 +1,286 @@
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/types"
+)
+
+const ContentTypeMultipart = "multipart/form-data"
+
+// operations和map字段的最大长度
+const maxMultipartFieldSize = 1 << 20
+
+// UploadLimits 文件上传限制, TempDir为空时使用系统临时目录
+type UploadLimits struct {
+	MaxFileSize  int64 // 单个文件最大字节数
+	MaxTotalSize int64 // 一次请求所有文件最大字节数
+	TempDir      string
+}
+
+var DefaultUploadLimits = UploadLimits{
+	MaxFileSize:  10 << 20,
+	MaxTotalSize: 50 << 20,
+}
+
+// SetUploadLimits 设置文件上传限制, 未设置时使用DefaultUploadLimits
+func (d *Daemon) SetUploadLimits(limits UploadLimits) {
+	d.mutex.Lock()
+	d.uploads = limits
+	d.mutex.Unlock()
+}
+
+func (d *Daemon) getUploadLimits() UploadLimits {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.uploads == (UploadLimits{}) {
+		return DefaultUploadLimits
+	}
+	return d.uploads
+}
+
+func isMultipartRequest(r *http.Request) bool {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.Method == http.MethodPost && contentType == ContentTypeMultipart
+}
+
+// serveMultipart 处理graphql multipart请求, 上传文件通过Upload类型的变量传入resolver
+// 请求结束后删除临时文件, resolver需要在返回前处理完文件
+/* example
+curl localhost:8087/graphql \
+  -F operations='{"query":"mutation($file: Upload!){uploadPicture(file: $file){url}}","variables":{"file":null}}' \
+  -F map='{"0":["variables.file"]}' \
+  -F 0=@a.png
+*/
+func (d *Daemon) serveMultipart(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, uploads, err := d.readMultipartRequest(w, r)
+	defer removeUploads(uploads)
+	if err != nil {
+		return err
+	}
+	// operations中的查询同样需要补全持久化查询并校验白名单
+	if err = d.resolvePersistedQuery(nil, req); err != nil {
+		return err
+	}
+	if err = d.checkQueryLimits(req); err != nil {
+		return err
+	}
+	result := graphql.Do(graphql.Params{
+		Schema:         *d.Schema(),
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+	writeGraphqlResult(w, result)
+	return nil
+}
+
+// readMultipartRequest 依次读取operations, map和文件, 文件直接写入临时目录
+func (d *Daemon) readMultipartRequest(w http.ResponseWriter, r *http.Request) (
+	*graphqlRequest, []*types.Upload, error) {
+	var (
+		limits  = d.getUploadLimits()
+		req     *graphqlRequest
+		fileMap map[string][]string
+		uploads []*types.Upload
+		total   int64
+	)
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxTotalSize+2*maxMultipartFieldSize)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, errors.FGEInvalidRequestParam
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.ErrorRaw("[readMultipartRequest] read part failed. err=%s", err.Error())
+			return nil, uploads, errors.FGEInvalidRequestParam
+		}
+		switch name := part.FormName(); name {
+		case "operations":
+			// 不支持批量请求
+			req = new(graphqlRequest)
+			err = json.NewDecoder(io.LimitReader(part, maxMultipartFieldSize)).Decode(req)
+		case "map":
+			err = json.NewDecoder(io.LimitReader(part, maxMultipartFieldSize)).Decode(&fileMap)
+		default:
+			paths, ok := fileMap[name]
+			if req == nil || !ok {
+				// 规范要求operations和map在文件之前
+				err = errors.FGEInvalidRequestParam
+				break
+			}
+			var upload *types.Upload
+			if upload, err = saveUpload(part, limits, &total); err != nil {
+				break
+			}
+			uploads = append(uploads, upload)
+			if req.Variables == nil {
+				req.Variables = make(map[string]interface{})
+			}
+			for _, path := range paths {
+				if !setVariablePath(req.Variables, strings.TrimPrefix(path, "variables."), upload) {
+					err = errors.FGEInvalidRequestParam
+					break
+				}
+			}
+		}
+		part.Close()
+		if err != nil {
+			if _, ok := err.(errors.FGErrorCode); !ok {
+				log.ErrorRaw("[readMultipartRequest] parse part %s failed. err=%s", part.FormName(), err.Error())
+				err = errors.FGEInvalidRequestParam
+			}
+			return nil, uploads, err
+		}
+	}
+	if req == nil {
+		return nil, uploads, errors.FGEInvalidRequestParam
+	}
+	return req, uploads, nil
+}
+
+// saveUpload 把文件写入临时目录, 超过单文件或者总大小限制时返回FGEUploadTooLarge
+func saveUpload(part *multipart.Part, limits UploadLimits, total *int64) (*types.Upload, error) {
+	var maxSize = limits.MaxFileSize
+	if remain := limits.MaxTotalSize - *total; remain < maxSize {
+		maxSize = remain
+	}
+	f, err := ioutil.TempFile(limits.TempDir, "upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, io.LimitReader(part, maxSize+1))
+	if err == nil && n > maxSize {
+		err = errors.FGEUploadTooLarge
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	*total += n
+	return &types.Upload{
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Size:        n,
+		Path:        f.Name(),
+	}, nil
+}
+
+func removeUploads(uploads []*types.Upload) {
+	for _, upload := range uploads {
+		os.Remove(upload.Path)
+	}
+}
+
+// setVariablePath 按照map中的路径设置变量, 比如: files.0, input.avatar
+func setVariablePath(variables map[string]interface{}, path string, value interface{}) bool {
+	var (
+		keys             = strings.Split(path, ".")
+		cur  interface{} = variables
+	)
+	for idx, key := range keys {
+		last := idx == len(keys)-1
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			if last {
+				c[key] = value
+				return true
+			}
+			cur = c[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return false
+			}
+			if last {
+				c[i] = value
+				return true
+			}
+			cur = c[i]
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// writeGraphqlResult 与handler的返回格式一致
+func writeGraphqlResult(w http.ResponseWriter, result *graphql.Result) {
+	resp := map[string]interface{}{
+		"data": result.Data,
+	}
+	if len(result.Errors) > 0 {
+		resp["error"] = customErrorFormat(result.Errors)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	bts, _ := json.Marshal(resp)
+	w.Write(bts)
+}
+
+var invalidVariableChars = regexp.MustCompile(`[^_0-9A-Za-z]`)
+
+// uploadVariable 转发时上传文件对应的变量名, 由临时文件名生成, 同一个文件多次引用时相同
+func uploadVariable(upload *types.Upload) string {
+	return "upload_" + invalidVariableChars.ReplaceAllString(filepath.Base(upload.Path), "_")
+}
+
+// collectUploads 收集参数中的上传文件, key为uploadVariable
+func collectUploads(values ...interface{}) map[string]*types.Upload {
+	var (
+		uploads = make(map[string]*types.Upload)
+		walk    func(v interface{})
+	)
+	walk = func(v interface{}) {
+		switch val := v.(type) {
+		case *types.Upload:
+			uploads[uploadVariable(val)] = val
+		case map[string]interface{}:
+			for _, item := range val {
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range val {
+				walk(item)
+			}
+		}
+	}
+	for _, v := range values {
+		walk(v)
+	}
+	return uploads
+}
+
+// uploadVariableDefinitions 转发请求的变量定义, 比如: ($upload_1: Upload)
+func uploadVariableDefinitions(uploads map[string]*types.Upload) string {
+	if len(uploads) <= 0 {
+		return ""
+	}
+	defs := make([]string, 0, len(uploads))
+	for name := range uploads {
+		defs = append(defs, "$"+name+": "+types.GLUpload.Name())
+	}
+	sort.Strings(defs)
+	return "(" + strings.Join(defs, ", ") + ")"
+}
@@ -0,0 +1,158 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/cache"
+	"github.com/microsvs/base/pkg/errors"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newUploadSchema(resolve graphql.FieldResolveFn) graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: graphql.Fields{"ping": &graphql.Field{Type: graphql.String}},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"upload": &graphql.Field{
+					Type: graphql.NewList(graphql.String),
+					Args: graphql.FieldConfigArgument{
+						"file":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(types.GLUpload)},
+						"files": &graphql.ArgumentConfig{Type: graphql.NewList(types.GLUpload)},
+					},
+					Resolve: resolve,
+				},
+			},
+		}),
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+	return schema
+}
+
+func newMultipartRequest(url string, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("operations", `{"query":"mutation($file: Upload!, $files: [Upload]){upload(file: $file, files: $files)}",`+
+		`"variables":{"file":null,"files":[null]}}`)
+	mw.WriteField("map", `{"0":["variables.file"],"1":["variables.files.0"]}`)
+	for _, name := range []string{"0", "1"} {
+		part, _ := mw.CreateFormFile(name, name+".txt")
+		part.Write([]byte(files[name]))
+	}
+	mw.Close()
+	r, _ := http.NewRequest("POST", url, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestServeMultipart(t *testing.T) {
+	var paths []string
+	schema := newUploadSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		var ret []string
+		uploads := append([]interface{}{p.Args["file"]}, p.Args["files"].([]interface{})...)
+		for _, item := range uploads {
+			upload := item.(*types.Upload)
+			bts, err := ioutil.ReadFile(upload.Path)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, upload.Path)
+			ret = append(ret, upload.Filename+":"+string(bts))
+		}
+		return ret, nil
+	})
+	d := &Daemon{service: rpc.FGSImage, schema: &schema}
+	server := httptest.NewServer(d)
+	defer server.Close()
+
+	resp, err := http.DefaultClient.Do(newMultipartRequest(server.URL+"/graphql",
+		map[string]string{"0": "hello", "1": "world"}))
+	assert.Nil(t, err)
+	var ret map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&ret)
+	resp.Body.Close()
+	assert.Equal(t, map[string]interface{}{
+		"upload": []interface{}{"0.txt:hello", "1.txt:world"},
+	}, ret["data"])
+	// 请求结束后删除临时文件
+	assert.Len(t, paths, 2)
+	for _, path := range paths {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// 第二个文件超过总大小限制
+	d.SetUploadLimits(UploadLimits{MaxFileSize: 8, MaxTotalSize: 8})
+	resp, err = http.DefaultClient.Do(newMultipartRequest(server.URL+"/graphql",
+		map[string]string{"0": "hello", "1": "world"}))
+	assert.Nil(t, err)
+	ret = nil
+	json.NewDecoder(resp.Body).Decode(&ret)
+	resp.Body.Close()
+	assert.Equal(t, float64(errors.FGEUploadTooLarge), ret["error"].(map[string]interface{})["code"])
+
+	// 白名单模式下multipart请求的查询同样需要注册
+	conn, _ := cache.NewMemoryConnection()
+	d.SetUploadLimits(UploadLimits{})
+	d.SetPersistedQueries(NewCacheQueryStore(conn, rpc.FGSImage), true)
+	resp, err = http.DefaultClient.Do(newMultipartRequest(server.URL+"/graphql",
+		map[string]string{"0": "hello", "1": "world"}))
+	assert.Nil(t, err)
+	ret = nil
+	json.NewDecoder(resp.Body).Decode(&ret)
+	resp.Body.Close()
+	assert.Equal(t, float64(errors.FGEQueryNotAllowed), ret["error"].(map[string]interface{})["code"])
+}
+
+func TestBuildRedirectQueryUpload(t *testing.T) {
+	var query string
+	schema := newUploadSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		query, _, _ = buildRedirectQuery(p, nil, nil)
+		return nil, nil
+	})
+	upload := &types.Upload{Filename: "a.png", Path: "/tmp/upload-123"}
+	graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  `mutation($file: Upload!){ upload(file: $file, files: [$file]) }`,
+		VariableValues: map[string]interface{}{"file": upload},
+	})
+	assert.Equal(t, `mutation($upload_upload_123: Upload){upload(file:$upload_upload_123, files:[$upload_upload_123])}`, query)
+}
+
+func TestCallServiceWithUploads(t *testing.T) {
+	schema := newUploadSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		upload := p.Args["file"].(*types.Upload)
+		bts, _ := ioutil.ReadFile(upload.Path)
+		return []string{upload.Filename + ":" + string(bts)}, nil
+	})
+	server := httptest.NewServer(&Daemon{service: rpc.FGSImage, schema: &schema})
+	defer server.Close()
+
+	f, _ := ioutil.TempFile("", "upload-")
+	f.WriteString("hello")
+	f.Close()
+	defer os.Remove(f.Name())
+	ctx := context.WithValue(context.Background(), rpc.KeyTraceID, "upload")
+	data, err := rpc.CallServiceWithUploads(ctx, strings.TrimPrefix(server.URL, "http://"),
+		`mutation($a: Upload!){upload(file: $a)}`, nil,
+		map[string]*types.Upload{"a": {Filename: "a.txt", Path: f.Name()}})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a.txt:hello"}, data["upload"])
+}
@@ -0,0 +1,296 @@
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/microsvs/base/pkg/cache"
+	"github.com/microsvs/base/pkg/log"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/microsvs/base/pkg/timer"
+)
+
+// UsageRecord 客户端的某个操作对字段或者参数的使用次数
+// Path为Type.field或者Type.field.arg, 类型的使用次数为其字段使用次数之和
+type UsageRecord struct {
+	Client    string `json:"client"`
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Count     int64  `json:"count"`
+}
+
+// UsageSink 定期接收统计结果, Flush失败时该周期的数据丢弃
+type UsageSink interface {
+	Flush(service rpc.FGService, records []UsageRecord) error
+}
+
+var (
+	// DefaultUsageInterval TrackUsage未指定周期时的上报周期
+	DefaultUsageInterval = time.Minute
+	// UsageMaxClients 统计的客户端数量上限, 超过以后新的客户端记为other
+	UsageMaxClients = 100
+	// UsageMaxOperations 统计的操作名称数量上限, 超过以后新的操作记为other
+	UsageMaxOperations = 1000
+)
+
+// usageOther 超过数量上限的客户端和操作名称
+const usageOther = "other"
+
+type usageKey struct {
+	client    string
+	operation string
+	path      string
+}
+
+type usageTracker struct {
+	service    rpc.FGService
+	sink       UsageSink
+	window     sync.Map // usageKey -> *int64, 上报后清零
+	totals     sync.Map // usageKey -> *int64, 启动以来的累计值
+	clients    boundedNames
+	operations boundedNames
+	stop       chan struct{}
+}
+
+// boundedNames 客户端和操作名称由请求方决定, 限制数量避免统计无限增长
+type boundedNames struct {
+	names sync.Map
+	count int64
+}
+
+// get 已记录或者未达到上限时返回name, 否则返回other
+func (b *boundedNames) get(name string, max int) string {
+	if _, ok := b.names.Load(name); ok {
+		return name
+	}
+	if atomic.LoadInt64(&b.count) >= int64(max) {
+		return usageOther
+	}
+	if _, loaded := b.names.LoadOrStore(name, struct{}{}); !loaded {
+		atomic.AddInt64(&b.count, 1)
+	}
+	return name
+}
+
+// TrackUsage 按客户端appid和操作名称统计字段和参数的使用次数, 定期上报给sink
+// 同时提供/usage/deprecated接口, 返回仍在使用的deprecated字段, 需要在Listen之前调用
+// 再次调用时停止之前的统计
+/* example
+d.TrackUsage(base.NewCacheUsageSink(conn), time.Minute)
+curl localhost:8085/usage/deprecated
+*/
+func (d *Daemon) TrackUsage(sink UsageSink, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultUsageInterval
+	}
+	tracker := &usageTracker{service: d.service, sink: sink, stop: make(chan struct{})}
+	d.mutex.Lock()
+	if d.usage != nil {
+		close(d.usage.stop)
+	}
+	d.usage = tracker
+	if d.extHandlers == nil {
+		d.extHandlers = make(map[string]http.Handler)
+	}
+	d.extHandlers["/usage/deprecated"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bts, _ := json.Marshal(d.DeprecatedUsage())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(bts)
+	})
+	d.mutex.Unlock()
+	go tracker.run(interval)
+}
+
+// StopTrackUsage 停止统计, 上报最后一个周期的数据
+func (d *Daemon) StopTrackUsage() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.usage != nil {
+		close(d.usage.stop)
+		d.usage = nil
+	}
+}
+
+// DeprecatedUsage 启动以来仍被调用的deprecated字段, 按路径和次数排序
+func (d *Daemon) DeprecatedUsage() []UsageRecord {
+	d.mutex.RLock()
+	tracker, schema := d.usage, d.schema
+	d.mutex.RUnlock()
+	if tracker == nil || schema == nil {
+		return nil
+	}
+	var ret []UsageRecord
+	for _, record := range collectUsage(&tracker.totals, false) {
+		if isDeprecatedField(schema, record.Path) {
+			ret = append(ret, record)
+		}
+	}
+	return ret
+}
+
+// installUsageTracking 统计resolve的字段和请求中出现的参数, 未调用TrackUsage时不统计
+func (d *Daemon) installUsageTracking(schema *graphql.Schema) {
+	walkSchemaFields(schema, func(obj *graphql.Object, field *graphql.FieldDefinition) {
+		if field.Resolve != nil {
+			field.Resolve = d.usageResolve(obj.Name()+"."+field.Name, field.Resolve)
+		}
+	})
+}
+
+func (d *Daemon) usageResolve(path string, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		d.mutex.RLock()
+		tracker := d.usage
+		d.mutex.RUnlock()
+		if tracker != nil {
+			key := usageKey{client: tracker.clients.get(usageClient(p.Context), UsageMaxClients), operation: "anonymous", path: path}
+			if op, ok := p.Info.Operation.(*ast.OperationDefinition); ok && op.Name != nil {
+				key.operation = tracker.operations.get(op.Name.Value, UsageMaxOperations)
+			}
+			tracker.add(key)
+			if len(p.Info.FieldASTs) > 0 {
+				for _, arg := range p.Info.FieldASTs[0].Arguments {
+					tracker.add(usageKey{client: key.client, operation: key.operation, path: path + "." + arg.Name.Value})
+				}
+			}
+		}
+		return next(p)
+	}
+}
+
+// usageClient 签名校验通过的appid, 内部调用使用原请求的appid, 其他请求记为unknown
+// url参数和header中的appid由客户端设置, 不使用
+func usageClient(ctx context.Context) string {
+	if ctx == nil {
+		return "unknown"
+	}
+	if appid, ok := ctx.Value(rpc.KeyAppID).(string); ok && len(appid) > 0 {
+		return appid
+	}
+	return "unknown"
+}
+
+func (t *usageTracker) add(key usageKey) {
+	for _, m := range []*sync.Map{&t.window, &t.totals} {
+		counter, ok := m.Load(key)
+		if !ok {
+			counter, _ = m.LoadOrStore(key, new(int64))
+		}
+		atomic.AddInt64(counter.(*int64), 1)
+	}
+}
+
+func (t *usageTracker) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush()
+		case <-t.stop:
+			t.flush()
+			return
+		}
+	}
+}
+
+func (t *usageTracker) flush() {
+	records := collectUsage(&t.window, true)
+	if len(records) <= 0 {
+		return
+	}
+	if err := t.sink.Flush(t.service, records); err != nil {
+		log.ErrorRaw("[usageTracker] flush %d usage records failed. err=%s", len(records), err.Error())
+	}
+}
+
+// collectUsage 收集计数不为0的记录, reset为true时清零
+func collectUsage(m *sync.Map, reset bool) []UsageRecord {
+	var records []UsageRecord
+	m.Range(func(k, v interface{}) bool {
+		var count int64
+		if reset {
+			count = atomic.SwapInt64(v.(*int64), 0)
+		} else {
+			count = atomic.LoadInt64(v.(*int64))
+		}
+		if count > 0 {
+			key := k.(usageKey)
+			records = append(records, UsageRecord{
+				Client:    key.client,
+				Operation: key.operation,
+				Path:      key.path,
+				Count:     count,
+			})
+		}
+		return true
+	})
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Path != records[j].Path {
+			return records[i].Path < records[j].Path
+		}
+		return records[i].Count > records[j].Count
+	})
+	return records
+}
+
+// isDeprecatedField path为Type.field时判断字段是否deprecated, 参数不支持deprecated
+func isDeprecatedField(schema *graphql.Schema, path string) bool {
+	names := strings.Split(path, ".")
+	if len(names) != 2 {
+		return false
+	}
+	obj, ok := schema.Type(names[0]).(*graphql.Object)
+	if !ok {
+		return false
+	}
+	field, ok := obj.Fields()[names[1]]
+	return ok && len(field.DeprecationReason) > 0
+}
+
+// logUsageSink 以json格式写入日志
+type logUsageSink struct{}
+
+// NewLogUsageSink 统计结果写入日志, 由日志采集统一处理
+func NewLogUsageSink() UsageSink {
+	return logUsageSink{}
+}
+
+func (logUsageSink) Flush(service rpc.FGService, records []UsageRecord) error {
+	bts, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	log.InfoRaw("[usage] service=%s records=%s", service, bts)
+	return nil
+}
+
+// cacheUsageSink 按天累加到缓存的hash中, key为: usage:<service>:<20060102>, field为: client|operation|path
+type cacheUsageSink struct {
+	conn cache.Connection
+}
+
+// NewCacheUsageSink 统计结果累加到缓存, 需要支持HINCRBY命令
+func NewCacheUsageSink(conn cache.Connection) UsageSink {
+	return &cacheUsageSink{conn: conn}
+}
+
+func (s *cacheUsageSink) Flush(service rpc.FGService, records []UsageRecord) error {
+	key := fmt.Sprintf("usage:%s:%s", service, timer.Now.Format("20060102"))
+	for _, record := range records {
+		field := strings.Join([]string{record.Client, record.Operation, record.Path}, "|")
+		if _, err := s.conn.ComplexCmd("HINCRBY", key, field, record.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package base
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/microsvs/base/pkg/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryUsageSink struct {
+	records []UsageRecord
+}
+
+func (s *memoryUsageSink) Flush(service rpc.FGService, records []UsageRecord) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func TestTrackUsage(t *testing.T) {
+	schema, _ := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"user": &graphql.Field{
+					Type: graphql.NewObject(graphql.ObjectConfig{
+						Name: "UsageUser",
+						Fields: graphql.Fields{
+							"name":     &graphql.Field{Type: graphql.String},
+							"nickname": &graphql.Field{Type: graphql.String, DeprecationReason: "use name"},
+						},
+					}),
+					Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.String}},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return map[string]interface{}{"name": "xhj", "nickname": "xhj"}, nil
+					},
+				},
+			},
+		}),
+	})
+	installRedirectResolvers(&schema)
+	d := &Daemon{schema: &schema}
+	d.installUsageTracking(&schema)
+	sink := new(memoryUsageSink)
+	d.TrackUsage(sink, time.Hour)
+
+	ctx := requestContext("ios")
+	for _, query := range []string{`query Q1 { user(id: "1") { name nickname } }`, `query Q1 { user { name } }`} {
+		result := graphql.Do(graphql.Params{Schema: schema, RequestString: query, Context: ctx})
+		assert.Empty(t, result.Errors)
+	}
+	d.usage.flush()
+	assert.Equal(t, []UsageRecord{
+		{Client: "ios", Operation: "Q1", Path: "Query.user", Count: 2},
+		{Client: "ios", Operation: "Q1", Path: "Query.user.id", Count: 1},
+		{Client: "ios", Operation: "Q1", Path: "UsageUser.name", Count: 2},
+		{Client: "ios", Operation: "Q1", Path: "UsageUser.nickname", Count: 1},
+	}, sink.records)
+	// 上报以后清零, 累计值不受影响
+	d.usage.flush()
+	assert.Len(t, sink.records, 4)
+	assert.Equal(t, []UsageRecord{
+		{Client: "ios", Operation: "Q1", Path: "UsageUser.nickname", Count: 1},
+	}, d.DeprecatedUsage())
+}
+
+type chanUsageSink chan []UsageRecord
+
+func (s chanUsageSink) Flush(service rpc.FGService, records []UsageRecord) error {
+	s <- records
+	return nil
+}
+
+// 只统计签名校验通过的appid, 超过上限的客户端记为other, 停止时上报最后的数据
+func TestTrackUsageClients(t *testing.T) {
+	schema := newRedirectSchema(func(p graphql.ResolveParams) (interface{}, error) {
+		return map[string]interface{}{"name": "xhj"}, nil
+	})
+	d := &Daemon{schema: &schema}
+	d.installUsageTracking(&schema)
+	sink := make(chanUsageSink, 1)
+	d.TrackUsage(sink, time.Hour)
+	max := UsageMaxClients
+	defer func() { UsageMaxClients = max }()
+	UsageMaxClients = 2
+
+	// 内部调用通过rpc context传递原请求的appid
+	r, _ := http.NewRequest("POST", "http://localhost/graphql", nil)
+	assert.Nil(t, rpc.ContextToHTTPRequest(context.WithValue(requestContext("ios"), rpc.KeyService, "order"), r))
+	internal, err := rpc.ContextFromHTTPRequest(nil, r)
+	assert.Nil(t, err)
+	assert.Equal(t, "ios", usageClient(internal))
+	// 未经校验的appid参数和header不使用
+	unverified, _ := http.NewRequest("POST", "http://localhost/graphql?appid=ops", nil)
+	unverified.Header.Set("X-App-ID", "ops")
+	external := context.WithValue(context.Background(), rpc.KeyRawRequest, unverified)
+	assert.Equal(t, "unknown", usageClient(external))
+
+	for _, ctx := range []context.Context{internal, external, requestContext("android")} {
+		result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ company { name } }", Context: ctx})
+		assert.Empty(t, result.Errors)
+	}
+	d.StopTrackUsage()
+	assert.Nil(t, d.usage)
+	var clients []string
+	for _, record := range <-sink {
+		if record.Path == "Query.company" {
+			clients = append(clients, record.Client)
+		}
+	}
+	sort.Strings(clients)
+	assert.Equal(t, []string{"ios", "other", "unknown"}, clients)
+}
+
+// requestContext 签名校验通过的请求
+func requestContext(appid string) context.Context {
+	r, _ := http.NewRequest("POST", "http://localhost/graphql", nil)
+	ctx := context.WithValue(context.Background(), rpc.KeyRawRequest, r)
+	return context.WithValue(ctx, rpc.KeyAppID, appid)
+}